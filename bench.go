@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// benchZoneID is the zone ID under which the logs of a benchmark are served.
+const benchZoneID = "bench"
+
+// benchStage is the measured cost of a stage of the collection pipeline.
+type benchStage struct {
+	name     string
+	duration time.Duration
+	allocs   uint64
+	bytes    uint64
+}
+
+// measure runs f and returns its duration and heap allocations.
+func measure(name string, f func() error) (benchStage, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	err := f()
+	d := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	return benchStage{name, d, after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc}, err
+}
+
+// runBench implements the bench subcommand, which runs the collection pipeline
+// against a local file of newline-delimited JSON log entries, as returned by
+// Logpull, and writes the throughput and allocations of every stage to
+// output. Usage and errors are written to errOutput. flag.ErrHelp is returned
+// if -help was given.
+func runBench(args []string, output, errOutput io.Writer) error {
+	fs := flag.NewFlagSet("cloudflare-logpull-exporter bench", flag.ContinueOnError)
+	fs.SetOutput(errOutput)
+	fs.Usage = func() {
+		fmt.Fprintf(errOutput, "Usage: cloudflare-logpull-exporter bench [flags] FILE\n\n")
+		fmt.Fprintf(errOutput, "Runs the collection pipeline against a file of newline-delimited JSON log\n")
+		fmt.Fprintf(errOutput, "entries, with every opt-in metric enabled, and reports its cost.\n\n")
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "path of the configuration file defining the response labels")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		err := fmt.Errorf("expected exactly one file, got %d arguments", fs.NArg())
+		fmt.Fprintln(errOutput, err)
+		fs.Usage()
+		return err
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("reading logs: %w", err)
+	}
+
+	labels := collector.DefaultResponseLabels
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", *configFile, err)
+		}
+		if len(cfg.Responses.Labels) > 0 {
+			labels = cfg.Responses.Labels
+		}
+	}
+
+	var lines int
+	decode, err := measure("decode", func() error {
+		return collector.DecodeLogEntries(bytes.NewReader(data), func(collector.LogEntry) error {
+			lines++
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("decoding logs: %w", err)
+	}
+
+	// The logs are served over a local listener, so that the pipeline
+	// measured is the same as when pulling from Cloudflare, minus the
+	// network.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Write errors surface as decode errors in the collector.
+		_, _ = w.Write(data)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	api, err := collector.NewLogpullAPIWithToken("", collector.WithBaseURL("http://"+l.Addr().String()))
+	if err != nil {
+		return fmt.Errorf("creating client: %w", err)
+	}
+
+	var collectErr error
+	c, err := collector.New(api, []string{benchZoneID}, time.Minute, func(err error) {
+		collectErr = err
+	})
+	if err != nil {
+		return fmt.Errorf("creating collector: %w", err)
+	}
+	if err := c.SetResponseLabels(labels); err != nil {
+		return fmt.Errorf("configuring collector: %w", err)
+	}
+	c.SetResponseBytes(true)
+	c.SetCacheStatus(true)
+	c.SetOriginDuration(true)
+	c.SetFirewallEvents(true)
+	c.SetTieredCache(true)
+	if err := c.SetJA3TopN(10); err != nil {
+		return fmt.Errorf("configuring collector: %w", err)
+	}
+	if err := c.SetASNTopN(10); err != nil {
+		return fmt.Errorf("configuring collector: %w", err)
+	}
+
+	var series int
+	pipeline, err := measure("pipeline", func() error {
+		ch := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+				series++
+			}
+			close(done)
+		}()
+
+		c.Collect(ch)
+		close(ch)
+		<-done
+		return collectErr
+	})
+	if err != nil {
+		return fmt.Errorf("collecting logs: %w", err)
+	}
+
+	// Aggregation can't be timed on its own, since it is interleaved with
+	// decoding; it is estimated as the difference of the two.
+	aggregate := benchStage{name: "aggregate (estimated)"}
+	if pipeline.duration > decode.duration {
+		aggregate.duration = pipeline.duration - decode.duration
+	}
+	if pipeline.allocs > decode.allocs {
+		aggregate.allocs = pipeline.allocs - decode.allocs
+	}
+	if pipeline.bytes > decode.bytes {
+		aggregate.bytes = pipeline.bytes - decode.bytes
+	}
+
+	fmt.Fprintf(output, "%d log entries (%d bytes), %d metric series\n\n", lines, len(data), series)
+
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tDURATION\tENTRIES/S\tALLOCS/ENTRY\tBYTES/ENTRY")
+	for _, s := range []benchStage{decode, aggregate, pipeline} {
+		perSecond, allocs, allocBytes := 0.0, 0.0, 0.0
+		if s.duration > 0 {
+			perSecond = float64(lines) / s.duration.Seconds()
+		}
+		if lines > 0 {
+			allocs = float64(s.allocs) / float64(lines)
+			allocBytes = float64(s.bytes) / float64(lines)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.0f\t%.1f\t%.0f\n", s.name, s.duration.Round(time.Microsecond), perSecond, allocs, allocBytes)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunBench checks that the bench subcommand reports every stage for a
+// valid log file, and rejects malformed ones.
+func TestRunBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs.ndjson")
+	logs := `{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 404, "OriginResponseStatus": 404}
+`
+	if err := ioutil.WriteFile(path, []byte(logs), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var output bytes.Buffer
+	if err := runBench([]string{path}, &output, ioutil.Discard); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !strings.HasPrefix(output.String(), "2 log entries") {
+		t.Errorf("unexpected summary: %s", output.String())
+	}
+	for _, stage := range []string{"decode", "aggregate (estimated)", "pipeline"} {
+		if !strings.Contains(output.String(), "\n"+stage+" ") {
+			t.Errorf("expected stage %q to be reported, got: %s", stage, output.String())
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := runBench([]string{path}, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected error when called with malformed logs")
+	}
+
+	if err := runBench(nil, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected error when called without a file")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+	"gopkg.in/yaml.v2"
+)
+
+// config is the format of the optional configuration file. Since YAML is a
+// superset of JSON, the file may be written in either.
+type config struct {
+	// Listeners, if non-empty, replaces EXPORTER_LISTEN_ADDR.
+	Listeners []listenerConfig `yaml:"listeners"`
+
+	// Zones, if non-empty, replaces CLOUDFLARE_ZONE_NAMES.
+	Zones []string `yaml:"zones"`
+
+	// Credentials are further sets of credentials, each with the zones
+	// collected with it, in addition to those of the environment.
+	Credentials []credentialsConfig `yaml:"credentials"`
+
+	// Responses configures the cloudflare_logs_http_responses metric.
+	Responses struct {
+		// Labels, if non-empty, replaces the default label set.
+		Labels []collector.LabelConfig `yaml:"labels"`
+
+		// Keys are label sets reported for every window, with zero
+		// values if no response had them.
+		Keys []map[string]string `yaml:"keys"`
+	} `yaml:"responses"`
+
+	// Endpoints configures the cloudflare_logs_endpoint_requests metric,
+	// which is only reported if either setting is given.
+	Endpoints struct {
+		// Method enables the method label.
+		Method bool `yaml:"method"`
+
+		// Paths, if non-empty, enables the path_group label.
+		Paths []collector.PathGroupConfig `yaml:"paths"`
+	} `yaml:"endpoints"`
+
+	// RelabelConfigs are applied to every exposed series, in order.
+	RelabelConfigs []relabelConfig `yaml:"relabel_configs"`
+
+	// Metrics override the help text and unit of exposed metrics, after
+	// relabeling.
+	Metrics []metricOverrideConfig `yaml:"metrics"`
+}
+
+// loadConfig reads and parses the configuration file at the given path.
+// Unknown keys are rejected, so that typos don't go unnoticed.
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	var cfg config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+)
+
+// TestLoadConfig checks that both YAML and JSON configuration files are
+// parsed, and that unknown keys are rejected.
+func TestLoadConfig(t *testing.T) {
+	expected := []collector.LabelConfig{{Field: "CacheCacheStatus", Label: "cache_status"}}
+
+	testCases := []struct {
+		condition       string
+		data            string
+		isErrorExpected bool
+	}{
+		{"with YAML", "responses:\n  labels:\n  - field: CacheCacheStatus\n    label: cache_status\n", false},
+		{"with JSON", `{"responses": {"labels": [{"field": "CacheCacheStatus", "label": "cache_status"}]}}`, false},
+		{"with unknown key", "responses:\n  lables: []\n", true},
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			path := filepath.Join(dir, "config")
+			if err := ioutil.WriteFile(path, []byte(c.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := loadConfig(path)
+			if err == nil && c.isErrorExpected {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if err != nil && !c.isErrorExpected {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if err == nil && !c.isErrorExpected && !reflect.DeepEqual(cfg.Responses.Labels, expected) {
+				t.Errorf("got %v, want %v", cfg.Responses.Labels, expected)
+			}
+		})
+	}
+}
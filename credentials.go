@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/cfgraphql"
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// credentialsConfig is a set of Cloudflare API credentials of the
+// configuration file, along with the zones collected with them, so that zones
+// of several accounts can be collected by one exporter. Exactly one of
+// APIToken, APITokenFile, APIKey, APIKeyFile and APIUserServiceKey must be
+// given. The credentials of the environment are given in the same form.
+type credentialsConfig struct {
+	APIToken          string   `yaml:"api_token"`
+	APITokenFile      string   `yaml:"api_token_file"`
+	APIKey            string   `yaml:"api_key"`
+	APIKeyFile        string   `yaml:"api_key_file"`
+	APIEmail          string   `yaml:"api_email"`
+	APIUserServiceKey string   `yaml:"api_user_service_key"`
+	Zones             []string `yaml:"zones"`
+}
+
+// hasFiles reports whether any credentials are read from files.
+func (c credentialsConfig) hasFiles() bool {
+	return c.APITokenFile != "" || c.APIKeyFile != ""
+}
+
+// cloudflareAPI creates a Cloudflare API client from the credentials, reading
+// those given as files. Returns an error unless exactly one kind of
+// credentials is given, or if a file can't be read.
+func (c credentialsConfig) cloudflareAPI(opts ...cloudflare.Option) (*cloudflare.API, error) {
+	if c.APIToken != "" && c.APITokenFile != "" || c.APIKey != "" && c.APIKeyFile != "" {
+		return nil, errors.New("invalid parameter: credentials given both directly and as a file")
+	}
+
+	token, key := c.APIToken, c.APIKey
+	var err error
+	if c.APITokenFile != "" {
+		if token, err = readSecretFile(c.APITokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.APIKeyFile != "" {
+		if key, err = readSecretFile(c.APIKeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return newCloudflareAPI(token, key, c.APIEmail, c.APIUserServiceKey, opts...)
+}
+
+// readSecretFile returns the secret held by the file at the given path, such
+// as a Docker or Kubernetes secret, without surrounding whitespace. Returns
+// an error if the file can't be read or is empty.
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("reading secret: %s is empty", path)
+	}
+	return secret, nil
+}
+
+// newCloudflareAPI creates a Cloudflare API client from an API token, an API
+// key and email address, or a User-Service key. Returns an error unless
+// exactly one of them is given.
+func newCloudflareAPI(token, key, email, userServiceKey string, opts ...cloudflare.Option) (*cloudflare.API, error) {
+	numAuthSettings := 0
+	for _, v := range []string{token, key, userServiceKey} {
+		if v != "" {
+			numAuthSettings++
+		}
+	}
+
+	if numAuthSettings != 1 {
+		return nil, errors.New("invalid parameter: exactly one of token, key or userServiceKey must be given")
+	}
+
+	switch {
+	case token != "":
+		return cloudflare.NewWithAPIToken(token, opts...)
+	case key != "":
+		if email == "" {
+			return nil, errors.New("invalid parameter: key given without email")
+		}
+		return cloudflare.New(key, email, opts...)
+	default:
+		return cloudflare.NewWithUserServiceKey(userServiceKey, opts...)
+	}
+}
+
+// credentialSet holds the API clients created from a set of credentials.
+type credentialSet struct {
+	config credentialsConfig
+	cfopts []cloudflare.Option
+	lpapi  *collector.LogpullAPI
+
+	// graphql is nil for User-Service keys, which the GraphQL Analytics
+	// API does not accept.
+	graphql *cfgraphql.Client
+
+	// cfapi is replaced when the credentials are read again from their
+	// files.
+	mu    sync.RWMutex
+	cfapi *cloudflare.API
+
+	// zoneNames are the names of the zones collected with the credentials,
+	// unless they are resolved otherwise, as those of the environment are.
+	zoneNames []string
+}
+
+// newCredentialSet creates the API clients of the given credentials, with the
+// given options. Returns an error if the credentials or options are invalid.
+func newCredentialSet(config credentialsConfig, cfopts []cloudflare.Option, lpopts []collector.LogpullOption, gqlopts []cfgraphql.Option) (*credentialSet, error) {
+	cfapi, err := config.cloudflareAPI(cfopts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating cfapi client: %w", err)
+	}
+
+	lpapi, err := collector.NewLogpullAPIFromCloudflare(cfapi, lpopts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating lpapi client: %w", err)
+	}
+
+	graphql, err := newGraphQLClient(cfapi, gqlopts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating graphql client: %w", err)
+	}
+
+	return &credentialSet{
+		config:    config,
+		cfopts:    cfopts,
+		lpapi:     lpapi,
+		graphql:   graphql,
+		cfapi:     cfapi,
+		zoneNames: config.Zones,
+	}, nil
+}
+
+// cloudflareAPI returns the Cloudflare API client of the credentials.
+func (s *credentialSet) cloudflareAPI() *cloudflare.API {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cfapi
+}
+
+// refresh reads the credentials given as files again, so that rotated
+// secrets take effect without a restart, and reports whether they changed.
+// The previous credentials stay in use if the files can't be read.
+func (s *credentialSet) refresh() (bool, error) {
+	if !s.config.hasFiles() {
+		return false, nil
+	}
+
+	cfapi, err := s.config.cloudflareAPI(s.cfopts...)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cfapi.APIToken == s.cfapi.APIToken && cfapi.APIKey == s.cfapi.APIKey {
+		return false, nil
+	}
+	if err := s.lpapi.SetCredentials(cfapi); err != nil {
+		return false, err
+	}
+	if s.graphql != nil {
+		if err := s.graphql.SetCredentials(graphqlCredentials(cfapi)); err != nil {
+			return false, err
+		}
+	}
+	s.cfapi = cfapi
+	return true, nil
+}
+
+// zoneCredentials maps the zones to the credential sets they are collected
+// with. It is safe for concurrent use, since the zones may be reloaded while
+// it is in use.
+type zoneCredentials struct {
+	mu   sync.RWMutex
+	sets map[string]*credentialSet
+}
+
+// set replaces the credential sets of the zones, keyed by zone ID.
+func (z *zoneCredentials) set(sets map[string]*credentialSet) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.sets = sets
+}
+
+// logpullAPIs returns the Logpull API clients of the zones, keyed by zone ID.
+func (z *zoneCredentials) logpullAPIs() map[string]*collector.LogpullAPI {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	apis := make(map[string]*collector.LogpullAPI, len(z.sets))
+	for zoneID, set := range z.sets {
+		apis[zoneID] = set.lpapi
+	}
+	return apis
+}
+
+// each calls fn once for every credential set of the given zones, with the
+// zones collected with it, in order. It stops at the first error, and returns
+// an error if a zone has no credentials.
+func (z *zoneCredentials) each(zoneIDs []string, fn func(set *credentialSet, zoneIDs []string) error) error {
+	z.mu.RLock()
+	var order []*credentialSet
+	groups := make(map[*credentialSet][]string)
+	for _, zoneID := range zoneIDs {
+		set, ok := z.sets[zoneID]
+		if !ok {
+			z.mu.RUnlock()
+			return fmt.Errorf("no credentials for zone %s", zoneID)
+		}
+		if _, ok := groups[set]; !ok {
+			order = append(order, set)
+		}
+		groups[set] = append(groups[set], zoneID)
+	}
+	z.mu.RUnlock()
+
+	for _, set := range order {
+		if err := fn(set, groups[set]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newGraphQLClient creates a GraphQL Analytics API client with the
+// credentials of the given Cloudflare API client. It returns nil for
+// User-Service keys, which the GraphQL Analytics API does not accept.
+func newGraphQLClient(cfapi *cloudflare.API, opts ...cfgraphql.Option) (*cfgraphql.Client, error) {
+	if cfapi.APIToken == "" && cfapi.APIKey == "" {
+		return nil, nil
+	}
+	return cfgraphql.New(graphqlCredentials(cfapi), opts...)
+}
+
+// graphqlCredentials returns the credentials of the given Cloudflare API
+// client for the GraphQL Analytics API.
+func graphqlCredentials(cfapi *cloudflare.API) cfgraphql.Credentials {
+	return cfgraphql.Credentials{
+		APIToken: cfapi.APIToken,
+		APIKey:   cfapi.APIKey,
+		APIEmail: cfapi.APIEmail,
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+var (
+	goodToken = "good-token"
+	goodKey   = "good-key"
+	goodEmail = "good@example.org"
+)
+
+// TestNewCloudflareAPI checks that exactly one kind of credentials is
+// accepted.
+func TestNewCloudflareAPI(t *testing.T) {
+	testCases := []struct {
+		condition                         string
+		token, key, email, userServiceKey string
+		expectErr                         bool
+	}{
+		{"with token", goodToken, "", "", "", false},
+		{"with key and email", "", goodKey, goodEmail, "", false},
+		{"with user service key", "", "", "", "good-service-key", false},
+		{"without credentials", "", "", "", "", true},
+		{"with token and key", goodToken, goodKey, goodEmail, "", true},
+		{"with key without email", "", goodKey, "", "", true},
+	}
+
+	for _, c := range testCases {
+		_, err := newCloudflareAPI(c.token, c.key, c.email, c.userServiceKey)
+		if c.expectErr && err == nil {
+			t.Errorf("expected error when called %s", c.condition)
+		} else if !c.expectErr && err != nil {
+			t.Errorf("unexpected error when called %s: %s", c.condition, err)
+		}
+	}
+}
+
+// TestZoneCredentialsEach checks that zones are grouped by credential set, in
+// order, and that zones without credentials are reported.
+func TestZoneCredentialsEach(t *testing.T) {
+	a, b := &credentialSet{}, &credentialSet{}
+	credentials := &zoneCredentials{}
+	credentials.set(map[string]*credentialSet{"zone-a": a, "zone-b": b, "zone-c": a})
+
+	var sets []*credentialSet
+	var groups [][]string
+	err := credentials.each([]string{"zone-b", "zone-a", "zone-c"}, func(set *credentialSet, zoneIDs []string) error {
+		sets = append(sets, set)
+		groups = append(groups, zoneIDs)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(sets) != 2 || sets[0] != b || sets[1] != a {
+		t.Errorf("unexpected credential sets: %v", sets)
+	}
+	if expected := [][]string{{"zone-b"}, {"zone-a", "zone-c"}}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %v, want %v", groups, expected)
+	}
+
+	if err := credentials.each([]string{"zone-d"}, func(*credentialSet, []string) error { return nil }); err == nil {
+		t.Error("expected error for zone without credentials")
+	}
+}
+
+// TestCredentialSetRefresh checks that credentials given as files are read
+// again, and that the previous ones stay in use if the file is empty.
+func TestCredentialSetRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte(goodToken+"\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The Logpull API client is checked to use the rotated token by having
+	// it verified.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer rotated-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		if _, err := w.Write([]byte(`{"success": true, "result": {"status": "active"}}`)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+	cfopts := []cloudflare.Option{func(api *cloudflare.API) error {
+		api.BaseURL = ts.URL
+		return nil
+	}}
+
+	set, err := newCredentialSet(credentialsConfig{APITokenFile: path}, cfopts, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token := set.cloudflareAPI().APIToken; token != goodToken {
+		t.Errorf("got token %q, want %q", token, goodToken)
+	}
+
+	if changed, err := set.refresh(); changed || err != nil {
+		t.Errorf("unexpected refresh of unchanged file: %t, %v", changed, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("rotated-token"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if changed, err := set.refresh(); !changed || err != nil {
+		t.Errorf("expected refresh of rotated file: %t, %v", changed, err)
+	}
+	if token := set.cloudflareAPI().APIToken; token != "rotated-token" {
+		t.Errorf("got token %q, want %q", token, "rotated-token")
+	}
+	if err := set.lpapi.VerifyTokenContext(context.Background()); err != nil {
+		t.Errorf("expected Logpull API client to use the rotated token, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := set.refresh(); err == nil {
+		t.Error("expected error for empty file")
+	}
+	if token := set.cloudflareAPI().APIToken; token != "rotated-token" {
+		t.Errorf("got token %q, want %q", token, "rotated-token")
+	}
+
+	if _, err := newCredentialSet(credentialsConfig{APIToken: goodToken, APITokenFile: path}, nil, nil, nil); err == nil {
+		t.Error("expected error for token given both directly and as a file")
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+)
+
+// envConfig holds the settings of the exporter given by environment
+// variables, or the command-line flags overriding them, as parsed by
+// loadEnvConfig. Settings which are not given hold their default values.
+type envConfig struct {
+	logLevel  string
+	logFormat string
+
+	listenAddr      string
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+	configFile      string
+	metricNamespace string
+
+	apiEmail          string
+	apiKey            string
+	apiKeyFile        string
+	apiToken          string
+	apiTokenFile      string
+	apiUserServiceKey string
+
+	zoneNames           []string
+	discoverZones       bool
+	zoneInclude         string
+	zoneExclude         string
+	zoneIDLabel         bool
+	zoneMetadataLabels  []string
+	zoneMetadataRefresh time.Duration
+
+	retentionCheck     string
+	preflight          string
+	credentialsRefresh time.Duration
+	fipsRequired       bool
+
+	clientIsolation  string
+	maxRetries       int
+	retryMinBackoff  time.Duration
+	retryMaxBackoff  time.Duration
+	rateLimit        float64
+	rateLimitBurst   int
+	sampleRate       float64
+	timestamps       string
+	stallTimeout     time.Duration
+	maxDownloadBytes int64
+	oversizeAction   string
+
+	outboundDNSServers      string
+	outboundHosts           string
+	outboundIPv4Only        bool
+	outboundProxy           string
+	outboundTLSCipherSuites []string
+	outboundTLSFIPS         bool
+	outboundTLSMinVersion   string
+
+	logPeriod       time.Duration
+	scrapeTimeout   time.Duration
+	refreshInterval time.Duration
+	concurrency     int
+	incremental     bool
+	statusErrors    int
+	schemaCheck     bool
+	eventLogFile    string
+
+	maxHosts    int
+	hostInclude string
+	hostExclude string
+
+	responseBytes         bool
+	cacheStatus           bool
+	firewallEvents        bool
+	tieredCache           bool
+	botScores             bool
+	splitStatus           bool
+	originDuration        bool
+	originDurationBuckets []float64
+	ja3TopN               int
+	asnTopN               int
+	countryTopN           int
+	coloTopN              int
+	anomalyAlpha          float64
+
+	webhookURL         string
+	webhookURLFile     string
+	webhookThreshold   int
+	webhookLagLimit    time.Duration
+	healthcheckURL     string
+	healthcheckURLFile string
+
+	fileSDPath   string
+	fileSDTarget string
+
+	enableReload bool
+	debugVars    bool
+	profiling    bool
+}
+
+// loadEnvConfig reads the settings of the exporter with getenv, and returns
+// an error naming the first variable which is invalid, or which conflicts
+// with another. Checks which need the configuration file are left to
+// checkConfig.
+func loadEnvConfig(getenv func(string) string) (*envConfig, error) {
+	r := &envReader{getenv: getenv}
+	e := &envConfig{
+		logLevel:  r.envString("EXPORTER_LOG_LEVEL", "info"),
+		logFormat: r.envString("EXPORTER_LOG_FORMAT", "text"),
+
+		listenAddr:      r.envString("EXPORTER_LISTEN_ADDR", ":9299"),
+		tlsCertFile:     r.envString("EXPORTER_TLS_CERT_FILE", ""),
+		tlsKeyFile:      r.envString("EXPORTER_TLS_KEY_FILE", ""),
+		tlsClientCAFile: r.envString("EXPORTER_TLS_CLIENT_CA_FILE", ""),
+		configFile:      r.envString("EXPORTER_CONFIG_FILE", ""),
+		metricNamespace: r.envString("EXPORTER_METRIC_NAMESPACE", ""),
+
+		apiEmail:          r.envString("CLOUDFLARE_API_EMAIL", ""),
+		apiKey:            r.envString("CLOUDFLARE_API_KEY", ""),
+		apiKeyFile:        r.envString("CLOUDFLARE_API_KEY_FILE", ""),
+		apiToken:          r.envString("CLOUDFLARE_API_TOKEN", ""),
+		apiTokenFile:      r.envString("CLOUDFLARE_API_TOKEN_FILE", ""),
+		apiUserServiceKey: r.envString("CLOUDFLARE_API_USER_SERVICE_KEY", ""),
+
+		zoneNames:           r.envList("CLOUDFLARE_ZONE_NAMES"),
+		discoverZones:       r.envBool("CLOUDFLARE_DISCOVER_ZONES"),
+		zoneInclude:         r.envString("CLOUDFLARE_ZONE_INCLUDE", ""),
+		zoneExclude:         r.envString("CLOUDFLARE_ZONE_EXCLUDE", ""),
+		zoneIDLabel:         r.envBool("EXPORTER_ZONE_ID_LABEL"),
+		zoneMetadataLabels:  r.envList("EXPORTER_ZONE_METADATA_LABELS"),
+		zoneMetadataRefresh: r.envDuration("EXPORTER_ZONE_METADATA_REFRESH", time.Hour),
+
+		retentionCheck:     r.envEnum("EXPORTER_RETENTION_CHECK", "warn", "off", "warn", "fail", "enable", "graphql"),
+		preflight:          r.envEnum("EXPORTER_PREFLIGHT", "warn", "off", "warn", "fail"),
+		credentialsRefresh: r.envDuration("EXPORTER_CREDENTIALS_REFRESH", time.Minute),
+		fipsRequired:       r.envBool("EXPORTER_FIPS_REQUIRED"),
+
+		clientIsolation:  r.envEnum("EXPORTER_CLIENT_ISOLATION", "off", "off", "zone", "account"),
+		maxRetries:       r.envInt("EXPORTER_MAX_RETRIES", 3),
+		retryMinBackoff:  r.envDuration("EXPORTER_RETRY_MIN_BACKOFF", time.Second),
+		retryMaxBackoff:  r.envDuration("EXPORTER_RETRY_MAX_BACKOFF", 10*time.Second),
+		rateLimit:        r.envFloat("EXPORTER_RATE_LIMIT", 0),
+		rateLimitBurst:   r.envInt("EXPORTER_RATE_LIMIT_BURST", 1),
+		sampleRate:       r.envFloat("EXPORTER_SAMPLE_RATE", 1),
+		timestamps:       r.envEnum("EXPORTER_TIMESTAMPS", "", collector.TimestampsRFC3339, collector.TimestampsUnix, collector.TimestampsUnixNano),
+		stallTimeout:     r.envDuration("EXPORTER_STALL_TIMEOUT", 30*time.Second),
+		maxDownloadBytes: r.envInt64("EXPORTER_MAX_DOWNLOAD_BYTES", 0),
+		oversizeAction:   r.envEnum("EXPORTER_OVERSIZE_ACTION", collector.OversizeSkip, collector.OversizeSkip, collector.OversizeSplit, collector.OversizeSample),
+
+		outboundDNSServers:      r.envString("EXPORTER_OUTBOUND_DNS_SERVERS", ""),
+		outboundHosts:           r.envString("EXPORTER_OUTBOUND_HOSTS", ""),
+		outboundIPv4Only:        r.envBool("EXPORTER_OUTBOUND_IPV4_ONLY"),
+		outboundProxy:           r.envString("EXPORTER_OUTBOUND_PROXY", ""),
+		outboundTLSCipherSuites: r.envList("EXPORTER_OUTBOUND_TLS_CIPHER_SUITES"),
+		outboundTLSFIPS:         r.envBool("EXPORTER_OUTBOUND_TLS_FIPS"),
+		outboundTLSMinVersion:   r.envString("EXPORTER_OUTBOUND_TLS_MIN_VERSION", "1.2"),
+
+		logPeriod:       r.envDuration("EXPORTER_LOG_PERIOD", time.Minute),
+		scrapeTimeout:   r.envDuration("EXPORTER_SCRAPE_TIMEOUT", time.Minute),
+		refreshInterval: r.envDuration("EXPORTER_REFRESH_INTERVAL", 0),
+		concurrency:     r.envInt("EXPORTER_CONCURRENCY", 10),
+		incremental:     r.envBool("EXPORTER_INCREMENTAL"),
+		statusErrors:    r.envInt("EXPORTER_STATUS_ERRORS", 10),
+		schemaCheck:     r.envBool("EXPORTER_SCHEMA_CHECK"),
+		eventLogFile:    r.envString("EXPORTER_EVENT_LOG_FILE", ""),
+
+		maxHosts:    r.envInt("EXPORTER_MAX_HOSTS", 0),
+		hostInclude: r.envString("EXPORTER_HOST_INCLUDE", ""),
+		hostExclude: r.envString("EXPORTER_HOST_EXCLUDE", ""),
+
+		responseBytes:         r.envBool("EXPORTER_RESPONSE_BYTES"),
+		cacheStatus:           r.envBool("EXPORTER_CACHE_STATUS"),
+		firewallEvents:        r.envBool("EXPORTER_FIREWALL_EVENTS"),
+		tieredCache:           r.envBool("EXPORTER_TIERED_CACHE"),
+		botScores:             r.envBool("EXPORTER_BOT_SCORES"),
+		splitStatus:           r.envBool("EXPORTER_SPLIT_STATUS"),
+		originDuration:        r.envBool("EXPORTER_ORIGIN_DURATION"),
+		originDurationBuckets: r.envFloats("EXPORTER_ORIGIN_DURATION_BUCKETS"),
+		ja3TopN:               r.envInt("EXPORTER_JA3_TOP_N", 0),
+		asnTopN:               r.envInt("EXPORTER_ASN_TOP_N", 0),
+		countryTopN:           r.envInt("EXPORTER_COUNTRY_TOP_N", 0),
+		coloTopN:              r.envInt("EXPORTER_COLO_TOP_N", 0),
+		anomalyAlpha:          r.envFloat("EXPORTER_ANOMALY_ALPHA", 0),
+
+		webhookURL:         r.envString("EXPORTER_WEBHOOK_URL", ""),
+		webhookURLFile:     r.envString("EXPORTER_WEBHOOK_URL_FILE", ""),
+		webhookThreshold:   r.envInt("EXPORTER_WEBHOOK_FAILURE_THRESHOLD", 3),
+		webhookLagLimit:    r.envDuration("EXPORTER_WEBHOOK_LAG_LIMIT", 0),
+		healthcheckURL:     r.envString("EXPORTER_HEALTHCHECK_URL", ""),
+		healthcheckURLFile: r.envString("EXPORTER_HEALTHCHECK_URL_FILE", ""),
+
+		fileSDPath:   r.envString("EXPORTER_FILE_SD_PATH", ""),
+		fileSDTarget: r.envString("EXPORTER_FILE_SD_TARGET", ""),
+
+		enableReload: r.envBool("EXPORTER_ENABLE_RELOAD"),
+		debugVars:    r.envBool("EXPORTER_DEBUG_VARS"),
+		profiling:    r.envBool("EXPORTER_PROFILING"),
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	switch {
+	case (e.apiKey != "" || e.apiKeyFile != "") && e.apiEmail == "":
+		return nil, errors.New("CLOUDFLARE_API_KEY specified without CLOUDFLARE_API_EMAIL; both must be provided")
+	case len(e.zoneNames) > 0 && e.discoverZones:
+		return nil, errors.New("CLOUDFLARE_ZONE_NAMES and CLOUDFLARE_DISCOVER_ZONES are mutually exclusive")
+	case e.credentialsRefresh <= 0:
+		return nil, errors.New("EXPORTER_CREDENTIALS_REFRESH must be positive")
+	case len(e.zoneMetadataLabels) > 0 && e.zoneMetadataRefresh <= 0:
+		return nil, errors.New("EXPORTER_ZONE_METADATA_REFRESH must be positive")
+	case len(e.originDurationBuckets) > 0 && !e.originDuration:
+		return nil, errors.New("EXPORTER_ORIGIN_DURATION_BUCKETS requires EXPORTER_ORIGIN_DURATION to be set to true")
+	case e.webhookURL != "" && e.webhookURLFile != "":
+		return nil, errors.New("EXPORTER_WEBHOOK_URL and EXPORTER_WEBHOOK_URL_FILE must not be given together")
+	case e.healthcheckURL != "" && e.healthcheckURLFile != "":
+		return nil, errors.New("EXPORTER_HEALTHCHECK_URL and EXPORTER_HEALTHCHECK_URL_FILE must not be given together")
+	}
+
+	return e, nil
+}
+
+// authSettings returns the number of ways of authenticating with the
+// Cloudflare API which are given.
+func (e *envConfig) authSettings() int {
+	n := 0
+	for _, v := range []string{e.apiToken, e.apiTokenFile, e.apiKey, e.apiKeyFile, e.apiUserServiceKey} {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkConfig checks the settings against those of the configuration file,
+// which may give the zones and credentials instead.
+func (e *envConfig) checkConfig(cfg *config) error {
+	// The credentials of the environment may be left out if those of the
+	// configuration file are given.
+	n := e.authSettings()
+	switch {
+	case n > 1 || n == 0 && len(cfg.Credentials) == 0:
+		return errors.New("must specify exactly one of CLOUDFLARE_API_TOKEN, CLOUDFLARE_API_TOKEN_FILE, CLOUDFLARE_API_KEY, CLOUDFLARE_API_KEY_FILE or CLOUDFLARE_API_USER_SERVICE_KEY")
+	case n == 0 && (len(e.zoneNames) > 0 || len(cfg.Zones) > 0 || e.discoverZones):
+		return errors.New("CLOUDFLARE_ZONE_NAMES and CLOUDFLARE_DISCOVER_ZONES require the credentials of the environment")
+	case len(e.zoneNames) == 0 && len(cfg.Zones) == 0 && !e.discoverZones && len(cfg.Credentials) == 0:
+		return errors.New("a comma-separated list of zone names must be specified in CLOUDFLARE_ZONE_NAMES, or CLOUDFLARE_DISCOVER_ZONES must be enabled")
+	case len(cfg.Zones) > 0 && e.discoverZones:
+		return errors.New("the zones of the configuration file and CLOUDFLARE_DISCOVER_ZONES are mutually exclusive")
+	}
+
+	for i, c := range cfg.Credentials {
+		if len(c.Zones) == 0 {
+			return fmt.Errorf("credentials %d of the configuration file must list their zones", i)
+		}
+	}
+	return nil
+}
+
+// envReader reads typed settings with getenv. Settings which are empty take
+// the given default value. The first error is kept in err, after which the
+// zero value is returned for settings which fail to parse.
+type envReader struct {
+	getenv func(string) string
+	err    error
+}
+
+// fail records the given error of the named setting, unless an error was
+// recorded before.
+func (r *envReader) fail(name string, err error) {
+	if r.err == nil {
+		r.err = fmt.Errorf("parsing %s: %w", name, err)
+	}
+}
+
+// envString reads a setting as is.
+func (r *envReader) envString(name, def string) string {
+	if v := r.getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean setting, which is false by default.
+func (r *envReader) envBool(name string) bool {
+	v := r.getenv(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.fail(name, err)
+	}
+	return b
+}
+
+// envInt reads an integer setting.
+func (r *envReader) envInt(name string, def int) int {
+	v := r.getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		r.fail(name, err)
+	}
+	return n
+}
+
+// envInt64 reads a 64-bit integer setting, such as a number of bytes.
+func (r *envReader) envInt64(name string, def int64) int64 {
+	v := r.getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		r.fail(name, err)
+	}
+	return n
+}
+
+// envFloat reads a floating-point setting, such as a rate.
+func (r *envReader) envFloat(name string, def float64) float64 {
+	v := r.getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.fail(name, err)
+	}
+	return f
+}
+
+// envDuration reads a setting given as a Go duration, such as 1m30s.
+func (r *envReader) envDuration(name string, def time.Duration) time.Duration {
+	v := r.getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		r.fail(name, err)
+	}
+	return d
+}
+
+// envEnum reads a setting which must be one of the given values.
+func (r *envReader) envEnum(name, def string, values ...string) string {
+	v := r.getenv(name)
+	if v == "" {
+		return def
+	}
+	for _, value := range values {
+		if v == value {
+			return v
+		}
+	}
+	r.fail(name, fmt.Errorf("%q is not one of %s or %s", v, strings.Join(values[:len(values)-1], ", "), values[len(values)-1]))
+	return def
+}
+
+// envList reads a comma-separated list, leaving out empty elements, which is
+// empty by default.
+func (r *envReader) envList(name string) []string {
+	var list []string
+	for _, s := range strings.Split(r.getenv(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// envFloats reads a comma-separated list of numbers, which is empty by
+// default.
+func (r *envReader) envFloats(name string) []float64 {
+	var list []float64
+	for _, s := range r.envList(name) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			r.fail(name, err)
+			return nil
+		}
+		list = append(list, f)
+	}
+	return list
+}
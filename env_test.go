@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+)
+
+// TestLoadEnvConfig checks that settings are parsed as their type, and that
+// settings which are not given take their default values.
+func TestLoadEnvConfig(t *testing.T) {
+	env := map[string]string{
+		"CLOUDFLARE_API_TOKEN":             "token",
+		"CLOUDFLARE_ZONE_NAMES":            "example.org, example.com,",
+		"EXPORTER_MAX_RETRIES":             "5",
+		"EXPORTER_SCRAPE_TIMEOUT":          "1m30s",
+		"EXPORTER_SAMPLE_RATE":             "0.5",
+		"EXPORTER_MAX_DOWNLOAD_BYTES":      "10000000000",
+		"EXPORTER_INCREMENTAL":             "true",
+		"EXPORTER_RETENTION_CHECK":         "graphql",
+		"EXPORTER_ORIGIN_DURATION":         "true",
+		"EXPORTER_ORIGIN_DURATION_BUCKETS": "0.1, 1,10",
+	}
+
+	e, err := loadEnvConfig(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, c := range map[string]struct{ got, want interface{} }{
+		"apiToken":              {e.apiToken, "token"},
+		"zoneNames":             {e.zoneNames, []string{"example.org", "example.com"}},
+		"maxRetries":            {e.maxRetries, 5},
+		"scrapeTimeout":         {e.scrapeTimeout, 90 * time.Second},
+		"sampleRate":            {e.sampleRate, 0.5},
+		"maxDownloadBytes":      {e.maxDownloadBytes, int64(10000000000)},
+		"incremental":           {e.incremental, true},
+		"retentionCheck":        {e.retentionCheck, "graphql"},
+		"originDurationBuckets": {e.originDurationBuckets, []float64{0.1, 1, 10}},
+
+		// Defaults
+		"listenAddr":      {e.listenAddr, ":9299"},
+		"logLevel":        {e.logLevel, "info"},
+		"preflight":       {e.preflight, "warn"},
+		"clientIsolation": {e.clientIsolation, "off"},
+		"oversizeAction":  {e.oversizeAction, collector.OversizeSkip},
+		"timestamps":      {e.timestamps, ""},
+		"logPeriod":       {e.logPeriod, time.Minute},
+		"retryMaxBackoff": {e.retryMaxBackoff, 10 * time.Second},
+		"concurrency":     {e.concurrency, 10},
+		"rateLimitBurst":  {e.rateLimitBurst, 1},
+		"discoverZones":   {e.discoverZones, false},
+		"metadataLabels":  {e.zoneMetadataLabels, []string(nil)},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("got %#v for %s, want %#v", c.got, name, c.want)
+		}
+	}
+}
+
+// TestLoadEnvConfigErrors checks that invalid and conflicting settings are
+// reported by name.
+func TestLoadEnvConfigErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		env       map[string]string
+		expected  string
+	}{
+		{"with invalid integer", map[string]string{"EXPORTER_MAX_RETRIES": "three"}, "EXPORTER_MAX_RETRIES"},
+		{"with invalid duration", map[string]string{"EXPORTER_LOG_PERIOD": "60"}, "EXPORTER_LOG_PERIOD"},
+		{"with invalid boolean", map[string]string{"EXPORTER_INCREMENTAL": "yes"}, "EXPORTER_INCREMENTAL"},
+		{"with invalid float", map[string]string{"EXPORTER_RATE_LIMIT": "fast"}, "EXPORTER_RATE_LIMIT"},
+		{"with invalid choice", map[string]string{"EXPORTER_PREFLIGHT": "skip"}, `"skip" is not one of off, warn or fail`},
+		{"with invalid bucket", map[string]string{"EXPORTER_ORIGIN_DURATION": "true", "EXPORTER_ORIGIN_DURATION_BUCKETS": "0.1,1s"}, "EXPORTER_ORIGIN_DURATION_BUCKETS"},
+		{"with buckets without histogram", map[string]string{"EXPORTER_ORIGIN_DURATION_BUCKETS": "0.1"}, "requires EXPORTER_ORIGIN_DURATION"},
+		{"with key without email", map[string]string{"CLOUDFLARE_API_KEY": "key"}, "CLOUDFLARE_API_EMAIL"},
+		{"with zone names and discovery", map[string]string{"CLOUDFLARE_ZONE_NAMES": "example.org", "CLOUDFLARE_DISCOVER_ZONES": "true"}, "mutually exclusive"},
+		{"with zero credentials refresh", map[string]string{"EXPORTER_CREDENTIALS_REFRESH": "0s"}, "EXPORTER_CREDENTIALS_REFRESH must be positive"},
+		{"with webhook URL and file", map[string]string{"EXPORTER_WEBHOOK_URL": "https://example.org", "EXPORTER_WEBHOOK_URL_FILE": "webhook"}, "must not be given together"},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			_, err := loadEnvConfig(func(name string) string { return c.env[name] })
+			if err == nil || !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("expected error containing %q when called %s, got %v", c.expected, c.condition, err)
+			}
+		})
+	}
+}
+
+// TestEnvConfigCheckConfig checks that the credentials and zones may be given
+// by either the environment or the configuration file, but not both.
+func TestEnvConfigCheckConfig(t *testing.T) {
+	fileCredentials := []credentialsConfig{{APIToken: "token", Zones: []string{"example.com"}}}
+
+	testCases := []struct {
+		condition       string
+		env             envConfig
+		cfg             config
+		isErrorExpected bool
+	}{
+		{"with token and zone names", envConfig{apiToken: "token", zoneNames: []string{"example.org"}}, config{}, false},
+		{"with token and zones of the file", envConfig{apiToken: "token"}, config{Zones: []string{"example.org"}}, false},
+		{"with credentials of the file only", envConfig{}, config{Credentials: fileCredentials}, false},
+		{"with token and key", envConfig{apiToken: "token", apiKey: "key", apiEmail: "user@example.com", zoneNames: []string{"example.org"}}, config{}, true},
+		{"without credentials", envConfig{zoneNames: []string{"example.org"}}, config{}, true},
+		{"with zone names without credentials", envConfig{zoneNames: []string{"example.org"}}, config{Credentials: fileCredentials}, true},
+		{"without zones", envConfig{apiToken: "token"}, config{}, true},
+		{"with zones of the file and discovery", envConfig{apiToken: "token", discoverZones: true}, config{Zones: []string{"example.org"}}, true},
+		{"with credentials of the file without zones", envConfig{}, config{Credentials: []credentialsConfig{{APIToken: "token"}}}, true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			err := c.env.checkConfig(&c.cfg)
+			if c.isErrorExpected && err == nil {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
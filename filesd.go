@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileSDTargetGroup is a target group in the format read by Prometheus'
+// file-based service discovery.
+// https://prometheus.io/docs/prometheus/latest/configuration/configuration/#file_sd_config
+type fileSDTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// writeFileSD writes a file-based service discovery file to path, listing the
+// given target, which is the address at which Prometheus can scrape this
+// exporter, labeled with the IDs of the zones it serves. The file is replaced
+// atomically, so Prometheus never reads a partially written file.
+func writeFileSD(path, target string, zoneIDs []string) error {
+	ids := append([]string{}, zoneIDs...)
+	sort.Strings(ids)
+
+	data, err := json.MarshalIndent([]fileSDTargetGroup{{
+		Targets: []string{target},
+		Labels: map[string]string{
+			"cloudflare_zone_ids": strings.Join(ids, ","),
+		},
+	}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding file_sd targets: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating file_sd file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing file_sd file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing file_sd file: %w", err)
+	}
+
+	// ioutil.TempFile creates files readable only by their owner, but
+	// Prometheus may run as a different user.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("writing file_sd file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing file_sd file: %w", err)
+	}
+
+	return nil
+}
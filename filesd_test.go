@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestWriteFileSD checks that the written file can be read back as a list of
+// target groups containing the target and zone IDs.
+func TestWriteFileSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.json")
+	if err := writeFileSD(path, "exporter:9299", []string{"zone-b", "zone-a"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var groups []fileSDTargetGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []fileSDTargetGroup{{
+		Targets: []string{"exporter:9299"},
+		Labels:  map[string]string{"cloudflare_zone_ids": "zone-a,zone-b"},
+	}}
+
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %v, want %v", groups, expected)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected temporary files to be removed, found %d files", len(files))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// flagKind is the type of the value of a command-line flag.
+type flagKind int
+
+const (
+	stringFlag flagKind = iota
+	boolFlag
+	durationFlag
+	intFlag
+	floatFlag
+)
+
+// cliFlag is a command-line flag which overrides an environment variable.
+type cliFlag struct {
+	name  string
+	env   string
+	kind  flagKind
+	usage string
+}
+
+// cliFlags are the command-line flags accepted by the exporter. Credentials,
+// and the outbound proxy, webhook and healthcheck URLs, which may hold
+// credentials, are deliberately left out, since command lines are visible to
+// other users of the machine. The URLs may be given as files instead.
+var cliFlags = []cliFlag{
+	{"anomaly-alpha", "EXPORTER_ANOMALY_ALPHA", floatFlag, "smoothing factor of the anomaly score moving averages"},
+	{"asn-top-n", "EXPORTER_ASN_TOP_N", intFlag, "number of client ASNs reported per zone"},
+	{"bot-scores", "EXPORTER_BOT_SCORES", boolFlag, "enable bot score metrics"},
+	{"cache-status", "EXPORTER_CACHE_STATUS", boolFlag, "enable cache status metrics"},
+	{"client-isolation", "EXPORTER_CLIENT_ISOLATION", stringFlag, "HTTP clients of Logpull API requests: off, zone or account"},
+	{"colo-top-n", "EXPORTER_COLO_TOP_N", intFlag, "number of edge data centers reported per zone"},
+	{"concurrency", "EXPORTER_CONCURRENCY", intFlag, "maximum number of zones collected at the same time"},
+	{"config", "EXPORTER_CONFIG_FILE", stringFlag, "path of the YAML or JSON configuration file"},
+	{"country-top-n", "EXPORTER_COUNTRY_TOP_N", intFlag, "number of client countries reported per zone"},
+	{"credentials-refresh", "EXPORTER_CREDENTIALS_REFRESH", durationFlag, "interval of reading credential files again"},
+	{"debug-vars", "EXPORTER_DEBUG_VARS", boolFlag, "serve the collector's internal state at /debug/vars"},
+	{"discover-zones", "CLOUDFLARE_DISCOVER_ZONES", boolFlag, "collect all zones accessible to the credentials"},
+	{"enable-reload", "EXPORTER_ENABLE_RELOAD", boolFlag, "reload the configuration on POST requests to /-/reload"},
+	{"event-log-file", "EXPORTER_EVENT_LOG_FILE", stringFlag, "file to append the event log to, or - for standard output"},
+	{"file-sd-path", "EXPORTER_FILE_SD_PATH", stringFlag, "file to write a file_sd target for the exporter to"},
+	{"file-sd-target", "EXPORTER_FILE_SD_TARGET", stringFlag, "address of the exporter in the file_sd target"},
+	{"fips-required", "EXPORTER_FIPS_REQUIRED", boolFlag, "refuse to start unless built with a FIPS 140-2 validated module"},
+	{"firewall-events", "EXPORTER_FIREWALL_EVENTS", boolFlag, "enable firewall event metrics"},
+	{"healthcheck-url-file", "EXPORTER_HEALTHCHECK_URL_FILE", stringFlag, "file holding the URL to ping after every successful scrape"},
+	{"host-exclude", "EXPORTER_HOST_EXCLUDE", stringFlag, "regular expression of hosts reported as other"},
+	{"host-include", "EXPORTER_HOST_INCLUDE", stringFlag, "regular expression of hosts reported individually"},
+	{"incremental", "EXPORTER_INCREMENTAL", boolFlag, "enable incremental collection"},
+	{"ja3-top-n", "EXPORTER_JA3_TOP_N", intFlag, "number of JA3 fingerprints reported"},
+	{"listen-addr", "EXPORTER_LISTEN_ADDR", stringFlag, "comma-separated addresses to listen on"},
+	{"log-format", "EXPORTER_LOG_FORMAT", stringFlag, "format of log lines: text or json"},
+	{"log-level", "EXPORTER_LOG_LEVEL", stringFlag, "minimum level of log lines: debug, info, warn or error"},
+	{"log-period", "EXPORTER_LOG_PERIOD", durationFlag, "period of logs pulled by every scrape"},
+	{"max-download-bytes", "EXPORTER_MAX_DOWNLOAD_BYTES", intFlag, "size budget of log downloads, in bytes as transferred"},
+	{"max-hosts", "EXPORTER_MAX_HOSTS", intFlag, "maximum number of hosts reported per zone"},
+	{"max-retries", "EXPORTER_MAX_RETRIES", intFlag, "number of retries of failed Logpull API requests"},
+	{"metric-namespace", "EXPORTER_METRIC_NAMESPACE", stringFlag, "prefix of the names of all metrics"},
+	{"origin-duration", "EXPORTER_ORIGIN_DURATION", boolFlag, "enable the origin response duration histogram"},
+	{"origin-duration-buckets", "EXPORTER_ORIGIN_DURATION_BUCKETS", stringFlag, "comma-separated buckets of the origin response duration histogram, in seconds"},
+	{"outbound-dns-servers", "EXPORTER_OUTBOUND_DNS_SERVERS", stringFlag, "comma-separated DNS servers resolving the hosts of outbound connections"},
+	{"outbound-hosts", "EXPORTER_OUTBOUND_HOSTS", stringFlag, "comma-separated host=IP overrides of outbound connections"},
+	{"outbound-ipv4-only", "EXPORTER_OUTBOUND_IPV4_ONLY", boolFlag, "only make outbound connections over IPv4"},
+	{"outbound-tls-cipher-suites", "EXPORTER_OUTBOUND_TLS_CIPHER_SUITES", stringFlag, "comma-separated TLS 1.2 cipher suites offered to the Cloudflare API, webhooks and healthchecks"},
+	{"outbound-tls-fips", "EXPORTER_OUTBOUND_TLS_FIPS", boolFlag, "only use FIPS-approved TLS settings for outbound connections"},
+	{"outbound-tls-min-version", "EXPORTER_OUTBOUND_TLS_MIN_VERSION", stringFlag, "minimum TLS version of outbound connections: 1.2 or 1.3"},
+	{"oversize-action", "EXPORTER_OVERSIZE_ACTION", stringFlag, "action on log downloads over the size budget: skip, split or sample"},
+	{"preflight", "EXPORTER_PREFLIGHT", stringFlag, "action on zones whose logs can't be pulled at startup: off, warn or fail"},
+	{"profiling", "EXPORTER_PROFILING", boolFlag, "serve runtime profiles at /debug/pprof/"},
+	{"rate-limit", "EXPORTER_RATE_LIMIT", floatFlag, "maximum rate of Logpull API requests per second"},
+	{"rate-limit-burst", "EXPORTER_RATE_LIMIT_BURST", intFlag, "maximum burst of Logpull API requests"},
+	{"refresh-interval", "EXPORTER_REFRESH_INTERVAL", durationFlag, "interval of background collection"},
+	{"response-bytes", "EXPORTER_RESPONSE_BYTES", boolFlag, "enable the HTTP response bytes metric"},
+	{"retention-check", "EXPORTER_RETENTION_CHECK", stringFlag, "action on zones with log retention disabled: off, warn, fail, enable or graphql"},
+	{"retry-max-backoff", "EXPORTER_RETRY_MAX_BACKOFF", durationFlag, "maximum delay between retries"},
+	{"retry-min-backoff", "EXPORTER_RETRY_MIN_BACKOFF", durationFlag, "minimum delay between retries"},
+	{"sample-rate", "EXPORTER_SAMPLE_RATE", floatFlag, "fraction of log entries pulled, between 0.001 and 1"},
+	{"schema-check", "EXPORTER_SCHEMA_CHECK", boolFlag, "enable checks of the available Logpull fields"},
+	{"scrape-timeout", "EXPORTER_SCRAPE_TIMEOUT", durationFlag, "maximum time spent pulling logs per scrape"},
+	{"split-status", "EXPORTER_SPLIT_STATUS", boolFlag, "report edge and origin response statuses as separate metrics"},
+	{"stall-timeout", "EXPORTER_STALL_TIMEOUT", durationFlag, "time without data after which log downloads are aborted"},
+	{"status-errors", "EXPORTER_STATUS_ERRORS", intFlag, "number of recent errors of every zone served by the status API"},
+	{"tiered-cache", "EXPORTER_TIERED_CACHE", boolFlag, "enable tiered cache metrics"},
+	{"timestamps", "EXPORTER_TIMESTAMPS", stringFlag, "format of the timestamps of log entries requested from Logpull: rfc3339, unix or unixnano"},
+	{"tls-cert-file", "EXPORTER_TLS_CERT_FILE", stringFlag, "TLS certificate file of the listen addresses"},
+	{"tls-client-ca-file", "EXPORTER_TLS_CLIENT_CA_FILE", stringFlag, "CA certificate file for mutual TLS"},
+	{"tls-key-file", "EXPORTER_TLS_KEY_FILE", stringFlag, "TLS key file of the listen addresses"},
+	{"webhook-failure-threshold", "EXPORTER_WEBHOOK_FAILURE_THRESHOLD", intFlag, "consecutive failures of a zone before notifying the webhook"},
+	{"webhook-lag-limit", "EXPORTER_WEBHOOK_LAG_LIMIT", durationFlag, "lag of a zone at which the webhook is notified"},
+	{"webhook-url-file", "EXPORTER_WEBHOOK_URL_FILE", stringFlag, "file holding the webhook to notify of failing zones"},
+	{"zone-exclude", "CLOUDFLARE_ZONE_EXCLUDE", stringFlag, "regular expression of discovered zone names to exclude"},
+	{"zone-id-label", "EXPORTER_ZONE_ID_LABEL", boolFlag, "add a zone_id label to per-zone metrics"},
+	{"zone-include", "CLOUDFLARE_ZONE_INCLUDE", stringFlag, "regular expression of discovered zone names to include"},
+	{"zone-metadata-labels", "EXPORTER_ZONE_METADATA_LABELS", stringFlag, "comma-separated zone metadata labels of per-zone metrics: zone_account, zone_plan"},
+	{"zone-metadata-refresh", "EXPORTER_ZONE_METADATA_REFRESH", durationFlag, "interval of zone metadata refreshes"},
+	{"zones", "CLOUDFLARE_ZONE_NAMES", stringFlag, "comma-separated names of the zones to collect"},
+}
+
+// parseFlags parses the given command-line arguments, and returns a function
+// which looks up configuration by environment variable name. Flags which were
+// given take precedence over the environment, as looked up by getenv. Usage
+// and errors are written to output. flag.ErrHelp is returned if -help was
+// given.
+func parseFlags(args []string, getenv func(string) string, output io.Writer) (func(string) string, error) {
+	fs := flag.NewFlagSet("cloudflare-logpull-exporter", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: cloudflare-logpull-exporter [flags]\n")
+		fmt.Fprintf(output, "       cloudflare-logpull-exporter bench [flags] FILE\n\n")
+		fmt.Fprintf(output, "Every flag overrides the environment variable given in brackets. Credentials,\n")
+		fmt.Fprintf(output, "and the outbound proxy, webhook and healthcheck URLs, can only be given in the\n")
+		fmt.Fprintf(output, "environment, or the URLs as files.\n\n")
+		fs.PrintDefaults()
+	}
+
+	// Flags are parsed as their type, so that boolean flags may be given
+	// without a value and invalid values are reported right away, and are
+	// then formatted like the environment variables they override.
+	values := make(map[string]func() string, len(cliFlags))
+	for _, f := range cliFlags {
+		usage := fmt.Sprintf("%s [%s]", f.usage, f.env)
+		switch f.kind {
+		case boolFlag:
+			v := fs.Bool(f.name, false, usage)
+			values[f.env] = func() string { return strconv.FormatBool(*v) }
+		case durationFlag:
+			v := fs.Duration(f.name, 0, usage)
+			values[f.env] = func() string { return v.String() }
+		case intFlag:
+			v := fs.Int64(f.name, 0, usage)
+			values[f.env] = func() string { return strconv.FormatInt(*v, 10) }
+		case floatFlag:
+			v := fs.Float64(f.name, 0, usage)
+			values[f.env] = func() string { return strconv.FormatFloat(*v, 'g', -1, 64) }
+		default:
+			v := fs.String(f.name, "", usage)
+			values[f.env] = func() string { return *v }
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument: %s", fs.Arg(0))
+		fmt.Fprintln(output, err)
+		fs.Usage()
+		return nil, err
+	}
+
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		for _, c := range cliFlags {
+			if c.name == f.Name {
+				set[c.env] = values[c.env]()
+			}
+		}
+	})
+
+	return func(env string) string {
+		if v, ok := set[env]; ok {
+			return v
+		}
+		return getenv(env)
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+// TestParseFlags checks that flags take precedence over the environment, and
+// that the environment is used otherwise.
+func TestParseFlags(t *testing.T) {
+	env := map[string]string{
+		"EXPORTER_LISTEN_ADDR":  ":9299",
+		"CLOUDFLARE_ZONE_NAMES": "example.org",
+	}
+
+	getenv, err := parseFlags([]string{"-listen-addr", ":9300", "-zones=example.com"}, func(name string) string {
+		return env[name]
+	}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"EXPORTER_LISTEN_ADDR":  ":9300",
+		"CLOUDFLARE_ZONE_NAMES": "example.com",
+		"EXPORTER_CONFIG_FILE":  "",
+	} {
+		if got := getenv(name); got != want {
+			t.Errorf("got %q for %s, want %q", got, name, want)
+		}
+	}
+
+	// Typed flags are formatted like environment variables, and boolean
+	// flags need no value.
+	getenv, err = parseFlags([]string{"-incremental", "-zone-id-label=false", "-scrape-timeout", "90s", "-max-retries", "5", "-sample-rate", "0.5"}, func(name string) string {
+		return env[name]
+	}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, want := range map[string]string{
+		"EXPORTER_INCREMENTAL":    "true",
+		"EXPORTER_ZONE_ID_LABEL":  "false",
+		"EXPORTER_SCRAPE_TIMEOUT": "1m30s",
+		"EXPORTER_MAX_RETRIES":    "5",
+		"EXPORTER_SAMPLE_RATE":    "0.5",
+	} {
+		if got := getenv(name); got != want {
+			t.Errorf("got %q for %s, want %q", got, name, want)
+		}
+	}
+
+	// An explicitly empty flag still overrides the environment.
+	getenv, err = parseFlags([]string{"-zones="}, func(name string) string {
+		return env[name]
+	}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := getenv("CLOUDFLARE_ZONE_NAMES"); got != "" {
+		t.Errorf("got %q, want empty value", got)
+	}
+}
+
+// TestParseFlagsErrors checks that unknown flags and arguments are rejected,
+// and that -help is reported.
+func TestParseFlagsErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		args      []string
+	}{
+		{"with unknown flag", []string{"-api-token", "secret"}},
+		{"with secret URL", []string{"-webhook-url", "https://hooks.example.org/secret"}},
+		{"with invalid boolean", []string{"-incremental=yes please"}},
+		{"with invalid duration", []string{"-scrape-timeout", "90"}},
+		{"with invalid number", []string{"-max-retries", "three"}},
+		{"with argument", []string{"example.org"}},
+		{"with help", []string{"-help"}},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			_, err := parseFlags(c.args, func(string) string { return "" }, ioutil.Discard)
+			if err == nil {
+				t.Errorf("expected error when called %s", c.condition)
+			}
+		})
+	}
+
+	if _, err := parseFlags([]string{"-h"}, func(string) string { return "" }, ioutil.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+}
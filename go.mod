@@ -5,5 +5,8 @@ go 1.15
 require (
 	github.com/cloudflare/cloudflare-go v0.13.7
 	github.com/prometheus/client_golang v1.9.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/prometheus/common v0.15.0
+	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthcheckTimeout bounds how long a single healthcheck ping may take.
+const healthcheckTimeout = 10 * time.Second
+
+// healthcheckPinger pings a URL, such as a healthchecks.io check or a Dead
+// Man's Snitch, after every collection in which all zones were collected
+// successfully. If the pings stop, the external service raises an alert, which
+// detects failures that the exporter's own metrics can't report, such as the
+// exporter or Prometheus being down.
+type healthcheckPinger struct {
+	url          string
+	httpClient   *http.Client
+	errorHandler func(error)
+}
+
+// newHealthcheckPinger creates a new healthcheckPinger. Errors pinging the URL
+// are passed to errorHandler.
+func newHealthcheckPinger(url string, errorHandler func(error)) (*healthcheckPinger, error) {
+	if url == "" {
+		return nil, errors.New("invalid parameter: url must not be empty")
+	}
+
+	return &healthcheckPinger{
+		url:          url,
+		httpClient:   &http.Client{Timeout: healthcheckTimeout},
+		errorHandler: errorHandler,
+	}, nil
+}
+
+// setTransport makes requests use the given transport, instead of
+// http.DefaultTransport.
+func (p *healthcheckPinger) setTransport(transport http.RoundTripper) {
+	p.httpClient.Transport = transport
+}
+
+// observe pings the URL in the background if ok is true.
+func (p *healthcheckPinger) observe(ok bool) {
+	if !ok {
+		return
+	}
+
+	go func() {
+		if err := p.ping(); err != nil {
+			p.errorHandler(err)
+		}
+	}()
+}
+
+// ping performs a single request to the URL.
+func (p *healthcheckPinger) ping() error {
+	resp, err := p.httpClient.Get(p.url)
+	if err != nil {
+		return fmt.Errorf("performing healthcheck request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected healthcheck response: %s", resp.Status)
+	}
+
+	return nil
+}
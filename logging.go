@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log line.
+type logLevel int
+
+// Log levels, in increasing order of severity.
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// logger writes structured log lines, made of a message and key-value pairs,
+// as logfmt-style text or as JSON objects. Lines below the configured level
+// are discarded. It is safe for concurrent use.
+type logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+	json  bool
+	exit  func(int)
+}
+
+// newLogger creates a new logger writing to w. level is one of debug, info,
+// warn or error, and format is either text or json.
+func newLogger(w io.Writer, level, format string) (*logger, error) {
+	l := &logger{w: w, level: -1, exit: os.Exit}
+
+	for i, name := range logLevelNames {
+		if level == name {
+			l.level = logLevel(i)
+		}
+	}
+	if l.level < 0 {
+		return nil, fmt.Errorf("invalid parameter: unsupported log level %q", level)
+	}
+
+	switch format {
+	case "text":
+	case "json":
+		l.json = true
+	default:
+		return nil, fmt.Errorf("invalid parameter: unsupported log format %q", format)
+	}
+
+	return l, nil
+}
+
+// log writes a line with the given level, message and key-value pairs, whose
+// keys must be strings. Errors and durations are formatted as strings.
+func (l *logger) log(level logLevel, msg string, keyvals ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	keys := []string{"time", "level", "msg"}
+	values := []interface{}{time.Now().UTC().Format(time.RFC3339Nano), level.String(), msg}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		value := keyvals[i+1]
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case time.Duration:
+			value = v.String()
+		}
+		keys = append(keys, fmt.Sprint(keyvals[i]))
+		values = append(values, value)
+	}
+
+	var line string
+	if l.json {
+		// Marshalling a map would sort the keys, so the object is
+		// written field by field to keep time, level and msg first.
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			k, _ := json.Marshal(key)
+			v, err := json.Marshal(values[i])
+			if err != nil {
+				v, _ = json.Marshal(fmt.Sprint(values[i]))
+			}
+			b.Write(k)
+			b.WriteByte(':')
+			b.Write(v)
+		}
+		b.WriteByte('}')
+		line = b.String()
+	} else {
+		fields := make([]string, len(keys))
+		for i, key := range keys {
+			fields[i] = key + "=" + logfmtValue(fmt.Sprint(values[i]))
+		}
+		line = strings.Join(fields, " ")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// There is nowhere left to report a failure to write a log line.
+	_, _ = io.WriteString(l.w, line+"\n")
+}
+
+// logfmtValue quotes s if it is empty or contains spaces, quotes, equal signs
+// or control characters.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (l *logger) debug(msg string, keyvals ...interface{}) { l.log(levelDebug, msg, keyvals...) }
+func (l *logger) info(msg string, keyvals ...interface{})  { l.log(levelInfo, msg, keyvals...) }
+func (l *logger) warn(msg string, keyvals ...interface{})  { l.log(levelWarn, msg, keyvals...) }
+func (l *logger) error(msg string, keyvals ...interface{}) { l.log(levelError, msg, keyvals...) }
+
+// collectorLogger adapts a logger to the collector.Logger interface.
+type collectorLogger struct{ l *logger }
+
+func (c collectorLogger) Debug(msg string, keyvals ...interface{}) { c.l.debug(msg, keyvals...) }
+func (c collectorLogger) Warn(msg string, keyvals ...interface{})  { c.l.warn(msg, keyvals...) }
+
+// fatal writes a line at the error level, and exits the process.
+func (l *logger) fatal(msg string, keyvals ...interface{}) {
+	l.log(levelError, msg, keyvals...)
+	l.exit(1)
+}
+
+// printf writes a formatted message at the info level, for use by functions
+// taking a printf-style logging function.
+func (l *logger) printf(format string, v ...interface{}) {
+	l.log(levelInfo, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "verbose", "text"); err == nil {
+		t.Error("expected error for unsupported log level")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected error for unsupported log format")
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, "warn", "text")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	l.debug("debug")
+	l.info("info")
+	l.warn("warn")
+	l.error("error")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "level=warn msg=warn") || !strings.Contains(lines[1], "level=error msg=error") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, "info", "text")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	l.info("pull failed", "zone", "example.com", "error", errors.New(`bad "request"`), "duration", 1500*time.Millisecond, "entries", 0)
+
+	line := buf.String()
+	expected := ` level=info msg="pull failed" zone=example.com error="bad \"request\"" duration=1.5s entries=0` + "\n"
+	if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, expected) {
+		t.Errorf("unexpected line %q", line)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, "debug", "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	l.debug("pull_succeeded", "zone", "example.com", "entries", 3, "error", errors.New("none"))
+
+	if !strings.HasPrefix(buf.String(), `{"time":`) {
+		t.Errorf("expected time to come first, got %q", buf.String())
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if line["level"] != "debug" || line["msg"] != "pull_succeeded" || line["zone"] != "example.com" || line["entries"] != 3.0 || line["error"] != "none" {
+		t.Errorf("unexpected line %v", line)
+	}
+}
+
+func TestLoggerFatal(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, "info", "text")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	code := -1
+	l.exit = func(c int) { code = c }
+	l.fatal("giving up")
+
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(buf.String(), "level=error msg=\"giving up\"") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
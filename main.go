@@ -1,87 +1,771 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/cfgraphql"
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	addr := os.Getenv("EXPORTER_LISTEN_ADDR")
-	if addr == "" {
-		addr = ":9299"
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		err := runBench(os.Args[2:], os.Stdout, os.Stderr)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "bench: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	getenv, err := parseFlags(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	env, err := loadEnvConfig(getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(2)
 	}
 
-	apiEmail := os.Getenv("CLOUDFLARE_API_EMAIL")
-	apiKey := os.Getenv("CLOUDFLARE_API_KEY")
-	apiToken := os.Getenv("CLOUDFLARE_API_TOKEN")
-	apiUserServiceKey := os.Getenv("CLOUDFLARE_API_USER_SERVICE_KEY")
-	zoneNames := os.Getenv("CLOUDFLARE_ZONE_NAMES")
+	logger, err := newLogger(os.Stderr, env.logLevel, env.logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating logger: %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := collector.CheckPluginFields(); err != nil {
+		logger.fatal("checking aggregator plugins", "error", err)
+	}
 
-	numAuthSettings := 0
-	for _, v := range []string{apiToken, apiKey, apiUserServiceKey} {
-		if v != "" {
-			numAuthSettings++
+	cfg := &config{}
+	if env.configFile != "" {
+		cfg, err = loadConfig(env.configFile)
+		if err != nil {
+			logger.fatal("loading config", "path", env.configFile, "error", err)
 		}
 	}
 
-	if numAuthSettings != 1 {
-		log.Fatal("Must specify exactly one of CLOUDFLARE_API_TOKEN, CLOUDFLARE_API_KEY or CLOUDFLARE_API_USER_SERVICE_KEY.")
+	if err := env.checkConfig(cfg); err != nil {
+		logger.fatal("checking configuration", "error", err)
+	}
+
+	filter, err := newZoneFilter(env.zoneInclude, env.zoneExclude)
+	if err != nil {
+		logger.fatal("creating zone filter", "error", err)
+	}
+
+	if env.fipsRequired && !collector.FIPSEnabled() {
+		logger.fatal("EXPORTER_FIPS_REQUIRED is set, but the exporter was not built with a FIPS 140-2 validated module.")
+	}
+	logger.info("Checked cryptographic module", "fips", collector.FIPSEnabled())
+
+	tlsConfig, err := newOutboundTLSConfig(env.outboundTLSMinVersion, env.outboundTLSCipherSuites, env.outboundTLSFIPS)
+	if err != nil {
+		logger.fatal("configuring outbound TLS", "error", err)
+	}
+
+	// All outbound requests share a transport, and thus the TLS policy,
+	// name resolution, proxy and metrics.
+	resolver, err := newOutboundResolver(env.outboundDNSServers, env.outboundHosts, env.outboundIPv4Only)
+	if err != nil {
+		logger.fatal("configuring outbound DNS resolution", "error", err)
 	}
 
-	if apiKey != "" && apiEmail == "" {
-		log.Fatal("CLOUDFLARE_API_KEY specified without CLOUDFLARE_API_EMAIL. Both must be provided.")
+	proxyTransport, err := newOutboundTransport(tlsConfig, env.outboundProxy, resolver)
+	if err != nil {
+		logger.fatal("configuring outbound proxy", "error", err)
 	}
+	outbound := collector.NewOutboundMetrics()
+	transport := outbound.Transport(proxyTransport)
+	httpClient := &http.Client{Transport: transport}
+
+	// zoneAccounts maps zone IDs to account IDs when clients are isolated
+	// by account. It is filled once the zones are loaded, before any pull.
+	zoneAccounts := make(map[string]string)
 
-	if zoneNames == "" {
-		log.Fatal("A comma-separated list of zone names must be specified in CLOUDFLARE_ZONE_NAMES")
+	lpopts := []collector.LogpullOption{
+		collector.WithHTTPClient(httpClient),
+		collector.WithRetry(env.maxRetries, env.retryMinBackoff, env.retryMaxBackoff),
+		collector.WithStallTimeout(env.stallTimeout),
+		collector.WithSample(env.sampleRate),
+		collector.WithSizeBudget(env.maxDownloadBytes, env.oversizeAction),
 	}
 
-	var cfapi *cloudflare.API
-	var lpapi *logpullAPI
-	var err error
+	if env.clientIsolation != "off" {
+		// Every client has a transport of its own, and thus its own
+		// connection pool, with the settings of the shared one.
+		newClient := func() *http.Client {
+			return &http.Client{Transport: outbound.Transport(proxyTransport.Clone())}
+		}
+		// Zones of unknown accounts, such as those added on reload, are
+		// isolated on their own.
+		group := func(zoneID string) string {
+			if account, ok := zoneAccounts[zoneID]; ok {
+				return account
+			}
+			return zoneID
+		}
+		lpopts = append(lpopts, collector.WithClientIsolation(newClient, group))
+	}
+
+	if env.rateLimit != 0 {
+		lpopts = append(lpopts, collector.WithRateLimit(env.rateLimit, env.rateLimitBurst))
+	}
+
+	if env.timestamps != "" {
+		lpopts = append(lpopts, collector.WithTimestamps(env.timestamps))
+	}
+
+	// The Cloudflare API client retries with the same policy, in whole
+	// seconds.
+	seconds := func(d time.Duration) int {
+		return int((d + time.Second - 1) / time.Second)
+	}
+	cfopts := []cloudflare.Option{
+		cloudflare.HTTPClient(httpClient),
+		cloudflare.UsingRetryPolicy(env.maxRetries, seconds(env.retryMinBackoff), seconds(env.retryMaxBackoff)),
+	}
+
+	// The GraphQL Analytics API is rate limited apart from Logpull, so its
+	// client shares the outbound transport only.
+	gqlopts := []cfgraphql.Option{
+		cfgraphql.WithHTTPClient(httpClient),
+	}
+
+	// Every set of credentials has clients of its own, the first of which
+	// are those of the environment, if given. The sets of the
+	// configuration file are not reloaded.
+	var envCredentials *credentialSet
+	var credentialSets []*credentialSet
+	if env.authSettings() > 0 {
+		envCredentials, err = newCredentialSet(credentialsConfig{
+			APIToken:          env.apiToken,
+			APITokenFile:      env.apiTokenFile,
+			APIKey:            env.apiKey,
+			APIKeyFile:        env.apiKeyFile,
+			APIEmail:          env.apiEmail,
+			APIUserServiceKey: env.apiUserServiceKey,
+		}, cfopts, lpopts, gqlopts)
+		if err != nil {
+			logger.fatal("creating API clients", "error", err)
+		}
+		credentialSets = append(credentialSets, envCredentials)
+	}
+
+	for i, c := range cfg.Credentials {
+		set, err := newCredentialSet(c, cfopts, lpopts, gqlopts)
+		if err != nil {
+			logger.fatal("creating API clients", "credentials", i, "error", err)
+		}
+		credentialSets = append(credentialSets, set)
+	}
+
+	// Credentials read from files, such as mounted secrets, are read again
+	// periodically, so that rotated secrets take effect without a restart.
+	go func() {
+		for range time.Tick(env.credentialsRefresh) {
+			for i, set := range credentialSets {
+				changed, err := set.refresh()
+				if err != nil {
+					logger.warn("Reading credentials failed; keeping the previous ones", "credentials", i, "error", err)
+				} else if changed {
+					logger.info("Read rotated credentials", "credentials", i)
+				}
+			}
+		}
+	}()
+
+	// loadZones resolves the zones to collect, which are listed in the
+	// configuration file or CLOUDFLARE_ZONE_NAMES, or discovered, along
+	// with those of every further set of credentials, and the credentials
+	// of every zone.
+	loadZones := func(cfg *config) ([]string, map[string]string, map[string]*credentialSet, error) {
+		zoneIDs := make([]string, 0)
+		zoneNamesByID := make(map[string]string)
+		sets := make(map[string]*credentialSet)
 
-	if apiToken != "" {
-		cfapi, err = cloudflare.NewWithAPIToken(apiToken)
-		lpapi = newLogpullAPIWithToken(apiToken)
-	} else if apiKey != "" {
-		cfapi, err = cloudflare.New(apiKey, apiEmail)
-		lpapi = newLogpullAPI(apiKey, apiEmail)
-	} else {
-		cfapi, err = cloudflare.NewWithUserServiceKey(apiUserServiceKey)
-		lpapi = newLogpullAPIWithUserServiceKey(apiUserServiceKey)
+		resolve := func(set *credentialSet, names []string) error {
+			for _, zoneName := range names {
+				zoneName = strings.TrimSpace(zoneName)
+				id, err := set.cloudflareAPI().ZoneIDByName(zoneName)
+				if err != nil {
+					return fmt.Errorf("zone id lookup: %w", err)
+				}
+				if _, ok := sets[id]; ok {
+					return fmt.Errorf("zone %s listed more than once", zoneName)
+				}
+				zoneIDs = append(zoneIDs, id)
+				zoneNamesByID[id] = zoneName
+				sets[id] = set
+			}
+			return nil
+		}
+
+		switch {
+		case env.discoverZones:
+			discovered, err := discoverZones(envCredentials.cloudflareAPI(), filter)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("zone discovery: %w", err)
+			}
+
+			if len(discovered) == 0 {
+				return nil, nil, nil, errors.New("zone discovery: no matching zones found")
+			}
+			var ids []string
+			for id, name := range discovered {
+				ids = append(ids, id)
+				zoneNamesByID[id] = name
+				sets[id] = envCredentials
+			}
+			sort.Strings(ids)
+			zoneIDs = append(zoneIDs, ids...)
+			logger.info("Discovered zones", "zones", len(ids))
+		case len(cfg.Zones) > 0:
+			if err := resolve(envCredentials, cfg.Zones); err != nil {
+				return nil, nil, nil, err
+			}
+		case len(env.zoneNames) > 0:
+			if err := resolve(envCredentials, env.zoneNames); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+
+		for _, set := range credentialSets {
+			if err := resolve(set, set.zoneNames); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		return zoneIDs, zoneNamesByID, sets, nil
 	}
 
+	zoneIDs, zoneNamesByID, zoneSets, err := loadZones(cfg)
 	if err != nil {
-		log.Fatalf("creating cfapi client: %s", err)
+		logger.fatal("loading zones", "error", err)
 	}
 
-	zoneIDs := make([]string, 0)
-	for _, zoneName := range strings.Split(zoneNames, ",") {
-		id, err := cfapi.ZoneIDByName(strings.TrimSpace(zoneName))
+	credentials := &zoneCredentials{}
+	credentials.set(zoneSets)
+
+	if env.clientIsolation == "account" {
+		err := credentials.each(zoneIDs, func(set *credentialSet, zoneIDs []string) error {
+			accounts, err := fetchZoneAccounts(set.cloudflareAPI(), zoneIDs)
+			if err != nil {
+				return err
+			}
+			for zoneID, account := range accounts {
+				zoneAccounts[zoneID] = account
+			}
+			return nil
+		})
+		if err != nil {
+			logger.fatal("fetching zone accounts", "error", err)
+		}
+	}
+
+	// checkRetention checks the log retention of the given zones, at
+	// startup and on reload, and deals with the zones without it as set by
+	// EXPORTER_RETENTION_CHECK. It returns the GraphQL Analytics API
+	// clients of the zones to collect through it, and the zones without log
+	// retention which are collected regardless.
+	checkRetention := func(zoneIDs []string, zoneNamesByID map[string]string, credentials *zoneCredentials) (map[string]*cfgraphql.Client, map[string]bool, error) {
+		graphqlZones := make(map[string]*cfgraphql.Client)
+		retentionDisabled := make(map[string]bool)
+		if env.retentionCheck == "off" {
+			return graphqlZones, retentionDisabled, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		err := credentials.each(zoneIDs, func(set *credentialSet, zoneIDs []string) error {
+			disabled, err := collector.ZonesWithoutRetention(ctx, set.lpapi, zoneIDs)
+			if err != nil {
+				return err
+			}
+
+			for _, zoneID := range disabled {
+				switch env.retentionCheck {
+				case "warn":
+					logger.warn("Log retention is disabled; no logs can be pulled until it is enabled", "zone", zoneNamesByID[zoneID], "zone_id", zoneID)
+					retentionDisabled[zoneID] = true
+				case "fail":
+					return fmt.Errorf("log retention of zone %s is disabled; enable it, or set EXPORTER_RETENTION_CHECK to warn or enable", zoneNamesByID[zoneID])
+				case "enable":
+					if err := set.lpapi.SetRetentionContext(ctx, zoneID, true); err != nil {
+						return fmt.Errorf("enabling log retention of zone %s: %w", zoneNamesByID[zoneID], err)
+					}
+					logger.info("Enabled log retention", "zone", zoneNamesByID[zoneID], "zone_id", zoneID)
+				case "graphql":
+					if set.graphql == nil {
+						return fmt.Errorf("log retention of zone %s is disabled, and the GraphQL Analytics API does not accept User-Service keys; use an API token or key, or set EXPORTER_RETENTION_CHECK otherwise", zoneNamesByID[zoneID])
+					}
+					logger.info("Log retention is disabled; collecting through the GraphQL Analytics API", "zone", zoneNamesByID[zoneID], "zone_id", zoneID)
+					graphqlZones[zoneID] = set.graphql
+					retentionDisabled[zoneID] = true
+				}
+			}
+			return nil
+		})
 		if err != nil {
-			log.Fatalf("zone id lookup: %s", err)
+			return nil, nil, err
+		}
+		return graphqlZones, retentionDisabled, nil
+	}
+
+	graphqlZones, retentionDisabled, err := checkRetention(zoneIDs, zoneNamesByID, credentials)
+	if err != nil {
+		logger.fatal("checking log retention", "error", err)
+	}
+
+	// Zones without log retention which are collected regardless don't
+	// fail the readiness check.
+	retentionExempt := &zoneSet{}
+	retentionExempt.set(retentionDisabled)
+
+	// The preflight checks report every zone whose logs can't be pulled,
+	// with the likely cause, and only give up with fail, so that a single
+	// misconfigured zone doesn't keep the others from being collected.
+	// Zones known to be without log retention have been dealt with above.
+	if env.preflight != "off" {
+		report := logger.warn
+		if env.preflight == "fail" {
+			report = logger.error
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		failed := 0
+		err := credentials.each(zoneIDs, func(set *credentialSet, zoneIDs []string) error {
+			if err := set.lpapi.VerifyTokenContext(ctx); err != nil {
+				report("Verifying API token failed", "zones", len(zoneIDs), "error", err)
+				failed += len(zoneIDs)
+				return nil
+			}
+			for _, zoneID := range zoneIDs {
+				if retentionDisabled[zoneID] {
+					continue
+				}
+				if err := set.lpapi.PreflightZoneContext(ctx, zoneID); err != nil {
+					report("Pulling logs failed", "zone", zoneNamesByID[zoneID], "zone_id", zoneID, "error", err)
+					failed++
+				}
+			}
+			return nil
+		})
+		cancel()
+		switch {
+		case err != nil:
+			logger.fatal("preflight check", "error", err)
+		case failed > 0 && env.preflight == "fail":
+			logger.fatal("Logs can't be pulled for some zones. Fix the errors above, or set EXPORTER_PREFLIGHT to warn.", "zones", failed)
+		case failed > 0:
+			logger.warn("Logs can't be pulled for some zones, which are collected regardless. Fix the warnings above.", "zones", failed)
+		default:
+			logger.info("Preflight check passed", "zones", len(zoneIDs))
 		}
-		zoneIDs = append(zoneIDs, id)
 	}
 
 	collectorErrorHandler := func(err error) {
-		log.Printf("collector: %s", err)
+		logger.error("collector", "error", err)
+	}
+
+	coll, err := collector.New(credentialSets[0].lpapi, zoneIDs, env.logPeriod, collectorErrorHandler)
+	if err != nil {
+		logger.fatal("creating collector", "error", err)
+	}
+
+	coll.SetZoneNames(zoneNamesByID)
+	coll.SetGraphQLZones(graphqlZones)
+	coll.SetLogger(collectorLogger{logger})
+	coll.SetOutboundMetrics(outbound)
+	coll.SetZoneAPIs(credentials.logpullAPIs())
+	for _, set := range credentialSets {
+		set.lpapi.SetRequestHook(coll.ObserveRequest)
+		set.lpapi.SetOversizeHook(coll.ObserveOversize)
+	}
+
+	coll.SetZoneIDLabel(env.zoneIDLabel)
+
+	// fetchMetadata fetches the metadata of the given zones with their
+	// credentials.
+	fetchMetadata := func(credentials *zoneCredentials, zoneIDs []string) (map[string]map[string]string, error) {
+		metadata := make(map[string]map[string]string, len(zoneIDs))
+		err := credentials.each(zoneIDs, func(set *credentialSet, zoneIDs []string) error {
+			m, err := fetchZoneMetadata(set.cloudflareAPI(), zoneIDs, env.zoneMetadataLabels)
+			if err != nil {
+				return err
+			}
+			for zoneID, labels := range m {
+				metadata[zoneID] = labels
+			}
+			return nil
+		})
+		return metadata, err
+	}
+
+	if len(env.zoneMetadataLabels) > 0 {
+		if err := coll.SetZoneMetadataLabels(env.zoneMetadataLabels); err != nil {
+			logger.fatal("configuring collector", "error", err, "supported", strings.Join(collector.ZoneMetadataLabelNames(), ","))
+		}
+
+		metadata, err := fetchMetadata(credentials, zoneIDs)
+		if err != nil {
+			logger.fatal("fetching zone metadata", "error", err)
+		}
+		coll.SetZoneMetadata(metadata)
+
+		go func() {
+			for range time.Tick(env.zoneMetadataRefresh) {
+				metadata, err := fetchMetadata(credentials, coll.Zones())
+				if err != nil {
+					logger.warn("Refreshing zone metadata failed; keeping the previous values", "error", err)
+					continue
+				}
+				coll.SetZoneMetadata(metadata)
+			}
+		}()
+	}
+
+	if len(cfg.Responses.Labels) > 0 {
+		if err := coll.SetResponseLabels(cfg.Responses.Labels); err != nil {
+			logger.fatal("configuring collector", "error", err)
+		}
+	}
+
+	if err := coll.SetResponseKeys(cfg.Responses.Keys); err != nil {
+		logger.fatal("configuring collector", "error", err)
+	}
+
+	if cfg.Endpoints.Method || len(cfg.Endpoints.Paths) > 0 {
+		var paths *collector.PathGrouper
+		if len(cfg.Endpoints.Paths) > 0 {
+			paths, err = collector.NewPathGrouper(cfg.Endpoints.Paths)
+			if err != nil {
+				logger.fatal("configuring path groups", "error", err)
+			}
+		}
+		coll.SetEndpoints(cfg.Endpoints.Method, paths)
 	}
 
-	collector, err := newCollector(lpapi, zoneIDs, time.Minute, collectorErrorHandler)
+	// The relabeling rules and metric overrides are applied even when
+	// empty, so that they can be added on reload.
+	relabeler, err := newRelabelGatherer(prometheus.DefaultGatherer, cfg.RelabelConfigs)
 	if err != nil {
-		log.Fatalf("creating collector: %s", err)
+		logger.fatal("configuring relabeling", "error", err)
+	}
+	overrider, err := newOverrideGatherer(relabeler, cfg.Metrics)
+	if err != nil {
+		logger.fatal("configuring metric overrides", "error", err)
+	}
+
+	if env.eventLogFile != "" {
+		w := os.Stdout
+		if env.eventLogFile != "-" {
+			w, err = os.OpenFile(env.eventLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				logger.fatal("opening event log", "error", err)
+			}
+		}
+
+		coll.SetEventLog(collector.NewEventLog(w, func(err error) {
+			logger.error("event log", "error", err)
+		}))
+	}
+
+	coll.SetIncremental(env.incremental)
+	coll.SetSchemaCheck(env.schemaCheck)
+	coll.SetResponseBytes(env.responseBytes)
+	coll.SetCacheStatus(env.cacheStatus)
+	coll.SetFirewallEvents(env.firewallEvents)
+	coll.SetTieredCache(env.tieredCache)
+	coll.SetBotScores(env.botScores)
+	coll.SetSplitStatus(env.splitStatus)
+	coll.SetOriginDuration(env.originDuration)
+
+	for _, err := range []error{
+		coll.SetTimeout(env.scrapeTimeout),
+		coll.SetConcurrency(env.concurrency),
+		coll.SetStatusErrors(env.statusErrors),
+		coll.SetRefreshInterval(env.refreshInterval),
+		coll.SetJA3TopN(env.ja3TopN),
+		coll.SetASNTopN(env.asnTopN),
+		coll.SetCountryTopN(env.countryTopN),
+		coll.SetColoTopN(env.coloTopN),
+		coll.SetAnomalyAlpha(env.anomalyAlpha),
+	} {
+		if err != nil {
+			logger.fatal("configuring collector", "error", err)
+		}
+	}
+
+	if len(env.originDurationBuckets) > 0 {
+		if err := coll.SetOriginDurationBuckets(env.originDurationBuckets); err != nil {
+			logger.fatal("configuring collector", "error", err)
+		}
+	}
+
+	if env.maxHosts != 0 || env.hostInclude != "" || env.hostExclude != "" {
+		hosts, err := collector.NewHostLimiter(env.maxHosts, env.hostInclude, env.hostExclude)
+		if err != nil {
+			logger.fatal("configuring host limits", "error", err)
+		}
+		coll.SetHostLimiter(hosts)
+	}
+
+	webhookURL := env.webhookURL
+	if env.webhookURLFile != "" {
+		if webhookURL, err = readSecretFile(env.webhookURLFile); err != nil {
+			logger.fatal("reading EXPORTER_WEBHOOK_URL_FILE", "error", err)
+		}
+	}
+
+	if webhookURL != "" {
+		notifier, err := newWebhookNotifier(webhookURL, env.webhookThreshold, func(err error) {
+			logger.error("webhook", "error", err)
+		})
+		if err != nil {
+			logger.fatal("creating webhook notifier", "error", err)
+		}
+		notifier.setTransport(transport)
+		notifier.setZoneLabel(func(zoneID string) string {
+			return coll.ZoneLabelValues(zoneID)[0]
+		})
+
+		if env.webhookLagLimit != 0 {
+			if err := notifier.setLagLimit(env.webhookLagLimit, coll.ZoneLag); err != nil {
+				logger.fatal("configuring webhook notifier", "error", err)
+			}
+		}
+
+		coll.SetZoneHandler(notifier.observe)
+	}
+
+	probes, err := newProbes(zoneAccessCheck(credentials, coll.Zones, retentionExempt))
+	if err != nil {
+		logger.fatal("creating probes", "error", err)
+	}
+
+	collectHandlers := []func(ok bool){probes.observe}
+
+	healthcheckURL := env.healthcheckURL
+	if env.healthcheckURLFile != "" {
+		if healthcheckURL, err = readSecretFile(env.healthcheckURLFile); err != nil {
+			logger.fatal("reading EXPORTER_HEALTHCHECK_URL_FILE", "error", err)
+		}
+	}
+
+	if healthcheckURL != "" {
+		pinger, err := newHealthcheckPinger(healthcheckURL, func(err error) {
+			logger.error("healthcheck", "error", err)
+		})
+		if err != nil {
+			logger.fatal("creating healthcheck pinger", "error", err)
+		}
+		pinger.setTransport(transport)
+
+		collectHandlers = append(collectHandlers, pinger.observe)
+	}
+
+	coll.SetCollectHandler(func(ok bool) {
+		for _, handler := range collectHandlers {
+			handler(ok)
+		}
+	})
+
+	listeners := cfg.Listeners
+	if len(listeners) == 0 {
+		listeners = listenersFromAddrs(env.listenAddr)
+		for i := range listeners {
+			listeners[i].TLSCertFile = env.tlsCertFile
+			listeners[i].TLSKeyFile = env.tlsKeyFile
+			listeners[i].TLSClientCAFile = env.tlsClientCAFile
+		}
+	}
+
+	fileSDTarget := env.fileSDTarget
+	if env.fileSDPath != "" {
+		if fileSDTarget == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				logger.fatal("determining file_sd target", "error", err)
+			}
+			_, port, err := net.SplitHostPort(listeners[0].Address)
+			if err != nil {
+				logger.fatal("determining file_sd target", "error", err)
+			}
+			fileSDTarget = net.JoinHostPort(hostname, port)
+		}
+
+		if err := writeFileSD(env.fileSDPath, fileSDTarget, zoneIDs); err != nil {
+			logger.fatal("writing file_sd file", "error", err)
+		}
+	}
+
+	// reload re-reads the configuration file, resolves the zones again and
+	// checks their log retention. Listeners and environment variables are
+	// not reloaded. The whole configuration is validated, and the metadata
+	// of the zones fetched, before any of it is applied, so that a failed
+	// reload leaves the previous configuration in place. Reloads are
+	// serialized, since they may be triggered by SIGHUP and /-/reload at
+	// the same time.
+	var reloadMu sync.Mutex
+	reload := func() error {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+
+		cfg := &config{}
+		if env.configFile != "" {
+			var err error
+			cfg, err = loadConfig(env.configFile)
+			if err != nil {
+				return fmt.Errorf("loading %s: %w", env.configFile, err)
+			}
+		}
+		if err := env.checkConfig(cfg); err != nil {
+			return err
+		}
+
+		zoneIDs, zoneNamesByID, zoneSets, err := loadZones(cfg)
+		if err != nil {
+			return err
+		}
+		next := &zoneCredentials{}
+		next.set(zoneSets)
+
+		graphqlZones, retentionDisabled, err := checkRetention(zoneIDs, zoneNamesByID, next)
+		if err != nil {
+			return fmt.Errorf("checking log retention: %w", err)
+		}
+
+		if _, err := compileRelabelConfigs(cfg.RelabelConfigs); err != nil {
+			return fmt.Errorf("reloading relabeling rules: %w", err)
+		}
+		if _, err := compileOverrides(cfg.Metrics); err != nil {
+			return fmt.Errorf("reloading metric overrides: %w", err)
+		}
+
+		labels := cfg.Responses.Labels
+		if len(labels) == 0 {
+			labels = collector.DefaultResponseLabels
+		}
+		if err := collector.ValidateResponseLabels(labels); err != nil {
+			return fmt.Errorf("reloading response labels: %w", err)
+		}
+		if err := collector.ValidateResponseKeys(cfg.Responses.Keys, labels); err != nil {
+			return fmt.Errorf("reloading response keys: %w", err)
+		}
+
+		var metadata map[string]map[string]string
+		if len(env.zoneMetadataLabels) > 0 {
+			if metadata, err = fetchMetadata(next, zoneIDs); err != nil {
+				return err
+			}
+		}
+
+		if env.fileSDPath != "" {
+			if err := writeFileSD(env.fileSDPath, fileSDTarget, zoneIDs); err != nil {
+				return fmt.Errorf("writing file_sd file: %w", err)
+			}
+		}
+
+		// The configuration is applied only once all of it is valid.
+		err = coll.Reload(collector.ReloadConfig{
+			ZoneIDs:        zoneIDs,
+			ZoneNames:      zoneNamesByID,
+			ZoneAPIs:       next.logpullAPIs(),
+			GraphQLZones:   graphqlZones,
+			ResponseLabels: labels,
+			ResponseKeys:   cfg.Responses.Keys,
+		})
+		if err != nil {
+			return fmt.Errorf("reloading collector: %w", err)
+		}
+		credentials.set(zoneSets)
+		retentionExempt.set(retentionDisabled)
+		if metadata != nil {
+			coll.SetZoneMetadata(metadata)
+		}
+		if err := relabeler.setRules(cfg.RelabelConfigs); err != nil {
+			return fmt.Errorf("reloading relabeling rules: %w", err)
+		}
+		if err := overrider.setOverrides(cfg.Metrics); err != nil {
+			return fmt.Errorf("reloading metric overrides: %w", err)
+		}
+
+		logger.info("Reloaded configuration", "zones", len(zoneIDs))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = coll.CheckFields(ctx)
+	cancel()
+
+	// Only fields known to be unavailable are fatal, as the fields endpoint
+	// may fail like any other, and the zones are still collected meanwhile.
+	var unknownErr *collector.UnknownFieldsError
+	if errors.As(err, &unknownErr) {
+		logger.fatal("checking fields", "error", err)
+	} else if err != nil {
+		logger.warn("Checking fields failed; the zones are collected regardless", "error", err)
+	}
+
+	// Reloads are only handled once the configuration of the startup has
+	// been validated.
+	go reloadOnSignal(reload, func(err error) {
+		logger.error("reload", "error", err)
+	})
+
+	go coll.Run(context.Background())
+
+	if err := coll.Register(prometheus.DefaultRegisterer, env.metricNamespace); err != nil {
+		logger.fatal("registering collector", "error", err)
+	}
+	metricsHandler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(overrider, promhttp.HandlerOpts{}))
+
+	routes := endpoints{
+		handlerMetrics: {"/metrics": coll.ScrapeHandler(metricsHandler)},
+		handlerStatus:  {"/api/v1/zones": coll.StatusHandler()},
+		handlerHealth: {
+			"/healthz": probes.healthHandler(),
+			"/readyz":  probes.readinessHandler(),
+		},
+		handlerAdmin: {},
+	}
+	admin := routes[handlerAdmin]
+
+	if env.enableReload {
+		admin["/-/reload"] = reloadHandler(reload)
+	}
+
+	if env.debugVars {
+		admin["/debug/vars"] = coll.DebugVarsHandler()
+	}
+
+	if env.profiling {
+		admin["/debug/pprof/"] = http.HandlerFunc(pprof.Index)
+		admin["/debug/pprof/cmdline"] = http.HandlerFunc(pprof.Cmdline)
+		admin["/debug/pprof/profile"] = http.HandlerFunc(pprof.Profile)
+		admin["/debug/pprof/symbol"] = http.HandlerFunc(pprof.Symbol)
+		admin["/debug/pprof/trace"] = http.HandlerFunc(pprof.Trace)
 	}
 
-	prometheus.MustRegister(collector)
-	http.Handle("/metrics", promhttp.Handler())
-	log.Printf("Listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	logger.fatal("serving", "error", serve(listeners, routes, logger.printf))
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// outboundTLSVersions are the accepted minimum TLS versions of outbound
+// connections, by name.
+var outboundTLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// fipsCipherSuites are the TLS 1.2 cipher suites approved by FIPS 140-2,
+// which are the only ones offered in FIPS mode.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// newOutboundTLSConfig returns the TLS configuration of the exporter's
+// outbound connections, to the Cloudflare API, webhooks and healthchecks.
+// minVersion is either 1.2 or 1.3. cipherSuites restricts the TLS 1.2 cipher
+// suites offered to the given ones, by their standard names; if empty, Go's
+// defaults are used. In FIPS mode, only TLS 1.2 with FIPS-approved cipher
+// suites and curves is negotiated, as TLS 1.3 cipher suites can't be
+// restricted.
+func newOutboundTLSConfig(minVersion string, cipherSuites []string, fips bool) (*tls.Config, error) {
+	version, ok := outboundTLSVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid parameter: unsupported TLS version %q", minVersion)
+	}
+
+	cfg := &tls.Config{MinVersion: version}
+
+	if len(cipherSuites) > 0 {
+		if version == tls.VersionTLS13 {
+			return nil, errors.New("invalid parameter: cipher suites can't be restricted with TLS 1.3")
+		}
+
+		ids := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			ids[suite.Name] = suite.ID
+		}
+
+		for _, name := range cipherSuites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid parameter: unsupported or insecure cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if fips {
+		if version == tls.VersionTLS13 {
+			return nil, errors.New("invalid parameter: FIPS mode requires TLS 1.2")
+		}
+
+		if len(cfg.CipherSuites) == 0 {
+			cfg.CipherSuites = fipsCipherSuites
+		}
+		for _, id := range cfg.CipherSuites {
+			if !containsCipherSuite(fipsCipherSuites, id) {
+				return nil, fmt.Errorf("invalid parameter: cipher suite %s is not approved for FIPS mode", tls.CipherSuiteName(id))
+			}
+		}
+
+		cfg.MaxVersion = tls.VersionTLS12
+		cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	}
+
+	return cfg, nil
+}
+
+// containsCipherSuite reports whether suites contains the given suite.
+func containsCipherSuite(suites []uint16, id uint16) bool {
+	for _, suite := range suites {
+		if suite == id {
+			return true
+		}
+	}
+	return false
+}
+
+// outboundProxySchemes are the accepted schemes of outbound proxy URLs.
+var outboundProxySchemes = map[string]bool{"http": true, "https": true, "socks5": true}
+
+// newOutboundTransport returns an HTTP transport for outbound requests, with
+// the settings of http.DefaultTransport, the given TLS configuration, and
+// host names resolved by the given resolver. If proxyURL is given, all
+// requests are sent through that HTTP, HTTPS or SOCKS5 proxy, authenticating
+// with the credentials in the URL, if any; otherwise, the proxy is taken from
+// the environment, as by http.ProxyFromEnvironment.
+func newOutboundTransport(tlsConfig *tls.Config, proxyURL string, resolver *outboundResolver) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = resolver.dialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+
+	if proxyURL != "" {
+		// Parse errors are not wrapped, since they would reveal the
+		// credentials in the URL.
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, errors.New("invalid parameter: malformed proxy URL")
+		}
+		if !outboundProxySchemes[u.Scheme] || u.Host == "" {
+			return nil, fmt.Errorf("invalid parameter: proxy URL must be http://, https:// or socks5:// with a host, got %q", u.Redacted())
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	return transport, nil
+}
+
+// outboundResolver controls how the host names of outbound connections are
+// resolved, for networks in which the system resolver can't resolve them.
+// The zero value uses the system resolver.
+type outboundResolver struct {
+	servers  []string
+	hosts    map[string]string
+	ipv4Only bool
+	next     uint32
+}
+
+// newOutboundResolver creates a new outboundResolver. servers is a
+// comma-separated list of DNS servers, as IP addresses with an optional port,
+// which are queried in turn instead of the system's. hosts is a
+// comma-separated list of host=IP pairs, which take precedence over DNS. If
+// ipv4Only is true, only IPv4 addresses are connected to.
+func newOutboundResolver(servers, hosts string, ipv4Only bool) (*outboundResolver, error) {
+	r := &outboundResolver{hosts: make(map[string]string), ipv4Only: ipv4Only}
+
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		if net.ParseIP(strings.Trim(server, "[]")) != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		host, port, err := net.SplitHostPort(server)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || n < 1 || n > 65535 || net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid parameter: DNS server %q is not an IP address with an optional port", server)
+		}
+		r.servers = append(r.servers, server)
+	}
+
+	for _, pair := range strings.Split(hosts, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid parameter: host override %q is not of the form host=IP", pair)
+		}
+		host, addr := strings.ToLower(strings.TrimSpace(pair[:i])), strings.TrimSpace(pair[i+1:])
+		ip := net.ParseIP(addr)
+		if host == "" || ip == nil {
+			return nil, fmt.Errorf("invalid parameter: host override %q is not of the form host=IP", pair)
+		}
+		if ipv4Only && ip.To4() == nil {
+			return nil, fmt.Errorf("invalid parameter: host override %q is not an IPv4 address", pair)
+		}
+		r.hosts[host] = ip.String()
+	}
+
+	return r, nil
+}
+
+// dialContext returns a function dialing connections with the given dialer,
+// according to the resolver's settings.
+func (r *outboundResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(r.servers) > 0 {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				// Every query goes to the next server, so that
+				// retries skip servers which don't respond.
+				i := atomic.AddUint32(&r.next, 1)
+				var d net.Dialer
+				return d.DialContext(ctx, network, r.servers[int(i)%len(r.servers)])
+			},
+		}
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if r.ipv4Only && network == "tcp" {
+			network = "tcp4"
+		}
+
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := r.hosts[strings.ToLower(host)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
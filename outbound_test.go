@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestNewOutboundTLSConfig(t *testing.T) {
+	testCases := []struct {
+		condition       string
+		minVersion      string
+		cipherSuites    []string
+		fips            bool
+		isErrorExpected bool
+	}{
+		{"with TLS 1.2", "1.2", nil, false, false},
+		{"with TLS 1.3", "1.3", nil, false, false},
+		{"with TLS 1.1", "1.1", nil, false, true},
+		{"with empty version", "", nil, false, true},
+		{"with cipher suites", "1.2", []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}, false, false},
+		{"with insecure cipher suite", "1.2", []string{"TLS_RSA_WITH_RC4_128_SHA"}, false, true},
+		{"with unknown cipher suite", "1.2", []string{"TLS_GARBAGE"}, false, true},
+		{"with cipher suites and TLS 1.3", "1.3", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, false, true},
+		{"in FIPS mode", "1.2", nil, true, false},
+		{"in FIPS mode with approved cipher suite", "1.2", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, true, false},
+		{"in FIPS mode with unapproved cipher suite", "1.2", []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}, true, true},
+		{"in FIPS mode with TLS 1.3", "1.3", nil, true, true},
+	}
+
+	for _, c := range testCases {
+		_, err := newOutboundTLSConfig(c.minVersion, c.cipherSuites, c.fips)
+		if err == nil && c.isErrorExpected {
+			t.Errorf("expected error when called %s", c.condition)
+		} else if err != nil && !c.isErrorExpected {
+			t.Errorf("unexpected error when called %s: %s", c.condition, err)
+		}
+	}
+
+	cfg, err := newOutboundTLSConfig("1.2", nil, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 only in FIPS mode, got %x to %x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	if !reflect.DeepEqual(cfg.CipherSuites, fipsCipherSuites) {
+		t.Errorf("expected FIPS cipher suites, got %v", cfg.CipherSuites)
+	}
+}
+
+// TestOutboundTransport checks that the outbound transport negotiates TLS
+// according to the policy.
+func TestOutboundTransport(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, c := range []struct {
+		minVersion      string
+		isErrorExpected bool
+	}{{"1.2", false}, {"1.3", true}} {
+		cfg, err := newOutboundTLSConfig(c.minVersion, nil, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cfg.RootCAs = ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+		transport, err := newOutboundTransport(cfg, "", &outboundResolver{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if err == nil && c.isErrorExpected {
+			t.Errorf("expected error with minimum version %s against a TLS 1.2 server", c.minVersion)
+		} else if err != nil && !c.isErrorExpected {
+			t.Errorf("unexpected error with minimum version %s: %s", c.minVersion, err)
+		}
+	}
+}
+
+// serveSOCKS5 serves a minimal SOCKS5 proxy on l, which requires the given
+// username and password, and sends the addresses of the CONNECT requests it
+// receives to targets.
+func serveSOCKS5(t *testing.T, l net.Listener, username, password string, targets chan<- string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+
+			// Greeting, offering authentication methods.
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(r, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(r, make([]byte, header[1])); err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte{5, 2}); err != nil {
+				return
+			}
+
+			// Username and password authentication, as in RFC 1929.
+			readString := func() string {
+				n, err := r.ReadByte()
+				if err != nil {
+					return ""
+				}
+				b := make([]byte, n)
+				if _, err := io.ReadFull(r, b); err != nil {
+					return ""
+				}
+				return string(b)
+			}
+			if _, err := r.ReadByte(); err != nil {
+				return
+			}
+			if readString() != username || readString() != password {
+				_, _ = conn.Write([]byte{1, 1})
+				return
+			}
+			if _, err := conn.Write([]byte{1, 0}); err != nil {
+				return
+			}
+
+			// CONNECT request.
+			request := make([]byte, 4)
+			if _, err := io.ReadFull(r, request); err != nil {
+				return
+			}
+			var host string
+			switch request[3] {
+			case 1:
+				ip := make([]byte, 4)
+				if _, err := io.ReadFull(r, ip); err != nil {
+					return
+				}
+				host = net.IP(ip).String()
+			case 3:
+				host = readString()
+			default:
+				t.Errorf("unexpected address type %d", request[3])
+				return
+			}
+			port := make([]byte, 2)
+			if _, err := io.ReadFull(r, port); err != nil {
+				return
+			}
+			target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+			targets <- target
+
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			defer upstream.Close()
+			if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+				return
+			}
+
+			go func() { _, _ = io.Copy(upstream, r) }()
+			_, _ = io.Copy(conn, upstream)
+		}()
+	}
+}
+
+// TestOutboundTransportSOCKS5 checks that requests are sent through a SOCKS5
+// proxy, authenticating with the credentials in its URL.
+func TestOutboundTransportSOCKS5(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.Close()
+
+	targets := make(chan string, 1)
+	go serveSOCKS5(t, l, "user", "secret", targets)
+
+	cfg, err := newOutboundTLSConfig("1.2", nil, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	transport, err := newOutboundTransport(cfg, "socks5://user:secret@"+l.Addr().String(), &outboundResolver{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if target := <-targets; target != ts.Listener.Addr().String() {
+		t.Errorf("expected proxied connection to %s, got %s", ts.Listener.Addr(), target)
+	}
+
+	for _, proxyURL := range []string{"ftp://proxy:21", "socks5://", "://proxy"} {
+		if _, err := newOutboundTransport(cfg, proxyURL, &outboundResolver{}); err == nil {
+			t.Errorf("expected error when called with proxy URL %q", proxyURL)
+		}
+	}
+}
+
+// serveDNS serves a minimal DNS server on conn, which answers A queries for
+// any name with the given IPv4 address, and other queries with no records.
+func serveDNS(conn net.PacketConn, ip net.IP) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := buf[:n]
+		if len(query) < 12 {
+			continue
+		}
+
+		// The question is the only one, and ends after the name.
+		end := 12
+		for end < len(query) && query[end] != 0 {
+			end += int(query[end]) + 1
+		}
+		end += 5
+		if end > len(query) {
+			continue
+		}
+		qtype := binary.BigEndian.Uint16(query[end-4:])
+
+		resp := append([]byte{}, query[:2]...)
+		resp = append(resp, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0)
+		resp = append(resp, query[12:end]...)
+		if qtype == 1 {
+			resp[7] = 1
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			resp = append(resp, ip.To4()...)
+		}
+		_, _ = conn.WriteTo(resp, addr)
+	}
+}
+
+// TestOutboundResolver checks that host names are resolved by the given DNS
+// servers, and that host overrides take precedence.
+func TestOutboundResolver(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dns, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer dns.Close()
+	go serveDNS(dns, net.IPv4(127, 0, 0, 1))
+
+	testCases := []struct {
+		condition string
+		servers   string
+		hosts     string
+		host      string
+	}{
+		{"with DNS server", dns.LocalAddr().String(), "", "logpull.example.test"},
+		{"with host override", "", "api.example.test=127.0.0.1", "api.example.test"},
+		{"with host override and unreachable DNS server", "127.0.0.1:1", "API.example.test=127.0.0.1", "api.example.test"},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			resolver, err := newOutboundResolver(c.servers, c.hosts, true)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			transport, err := newOutboundTransport(nil, "", resolver)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			resp, err := (&http.Client{Transport: transport}).Get("http://" + net.JoinHostPort(c.host, port))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			resp.Body.Close()
+		})
+	}
+
+	for _, c := range []struct {
+		servers string
+		hosts   string
+	}{
+		{"dns.example.test", ""},
+		{"127.0.0.1:dns", ""},
+		{"", "api.example.test"},
+		{"", "api.example.test=localhost"},
+		{"", "api.example.test=::1"},
+	} {
+		if _, err := newOutboundResolver(c.servers, c.hosts, true); err == nil {
+			t.Errorf("expected error when called with servers %q and hosts %q", c.servers, c.hosts)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	prommodel "github.com/prometheus/common/model"
+)
+
+// metricOverrideConfig overrides the help text of an exposed metric, or adds
+// a unit suffix to its name.
+type metricOverrideConfig struct {
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	Unit string `yaml:"unit"`
+}
+
+// metricUnitRE matches the valid unit suffixes.
+var metricUnitRE = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// overrideGatherer applies metric overrides to the metric families gathered
+// by another prometheus.Gatherer.
+type overrideGatherer struct {
+	next prometheus.Gatherer
+
+	mu        sync.RWMutex
+	overrides map[string]metricOverrideConfig
+}
+
+// newOverrideGatherer creates a new overrideGatherer for the given gatherer
+// and overrides.
+func newOverrideGatherer(next prometheus.Gatherer, configs []metricOverrideConfig) (*overrideGatherer, error) {
+	g := &overrideGatherer{next: next}
+	if err := g.setOverrides(configs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// setOverrides replaces the overrides while the gatherer is in use.
+func (g *overrideGatherer) setOverrides(configs []metricOverrideConfig) error {
+	overrides, err := compileOverrides(configs)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.overrides = overrides
+	return nil
+}
+
+// compileOverrides validates the given metric overrides, and returns them
+// keyed by metric name.
+func compileOverrides(configs []metricOverrideConfig) (map[string]metricOverrideConfig, error) {
+	overrides := make(map[string]metricOverrideConfig, len(configs))
+	for _, cfg := range configs {
+		if !prommodel.IsValidMetricName(prommodel.LabelValue(cfg.Name)) {
+			return nil, fmt.Errorf("invalid metric name %q", cfg.Name)
+		}
+		if cfg.Unit != "" && !metricUnitRE.MatchString(cfg.Unit) {
+			return nil, fmt.Errorf("invalid unit %q of %s", cfg.Unit, cfg.Name)
+		}
+		if _, ok := overrides[cfg.Name]; ok {
+			return nil, fmt.Errorf("metric %s overridden more than once", cfg.Name)
+		}
+		overrides[cfg.Name] = cfg
+	}
+	return overrides, nil
+}
+
+// Gather is a required method of the prometheus.Gatherer interface. Metrics
+// whose names collide after adding unit suffixes are reported as an error.
+func (g *overrideGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.next.Gather()
+
+	g.mu.RLock()
+	overrides := g.overrides
+	g.mu.RUnlock()
+
+	if len(overrides) == 0 {
+		return families, err
+	}
+
+	var errs prometheus.MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	names := make(map[string]bool, len(families))
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+
+	kept := families[:0]
+	for _, family := range families {
+		override, ok := overrides[family.GetName()]
+		if ok && override.Help != "" {
+			help := override.Help
+			family.Help = &help
+		}
+		if ok && override.Unit != "" {
+			name := withUnitSuffix(family.GetName(), override.Unit, family.GetType())
+			if name != family.GetName() {
+				if names[name] {
+					errs = append(errs, fmt.Errorf("metric %s collides with %s after adding its unit", family.GetName(), name))
+					continue
+				}
+				names[name] = true
+				family.Name = &name
+			}
+		}
+		kept = append(kept, family)
+	}
+
+	// Renamed metrics are moved to their place in name order, as gathered.
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].GetName() < kept[j].GetName()
+	})
+
+	if len(errs) > 0 {
+		return kept, errs
+	}
+	return kept, nil
+}
+
+// withUnitSuffix returns the given metric name with the given unit suffix,
+// which precedes the _total suffix of counters, unless it already has it.
+func withUnitSuffix(name, unit string, metricType dto.MetricType) string {
+	base, total := name, ""
+	if metricType == dto.MetricType_COUNTER && strings.HasSuffix(name, "_total") {
+		base, total = strings.TrimSuffix(name, "_total"), "_total"
+	}
+
+	if strings.HasSuffix(base, "_"+unit) {
+		return name
+	}
+	return base + "_" + unit + total
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestOverrideGatherer checks that help texts are overridden and unit
+// suffixes added, before the _total suffix of counters.
+func TestOverrideGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	responses := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_logs_http_responses",
+		Help: "Test metric",
+	})
+	errorCount := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cloudflare_logs_errors_total",
+		Help: "Test metric",
+	})
+	bytes := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_logs_http_response_bytes",
+		Help: "Test metric",
+	})
+	registry.MustRegister(responses, errorCount, bytes)
+
+	g, err := newOverrideGatherer(registry, []metricOverrideConfig{
+		{Name: "cloudflare_logs_http_responses", Help: "HTTP responses", Unit: "requests"},
+		{Name: "cloudflare_logs_errors_total", Unit: "events"},
+		{Name: "cloudflare_logs_http_response_bytes", Unit: "bytes"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `
+# HELP cloudflare_logs_errors_events_total Test metric
+# TYPE cloudflare_logs_errors_events_total counter
+cloudflare_logs_errors_events_total 0
+# HELP cloudflare_logs_http_response_bytes Test metric
+# TYPE cloudflare_logs_http_response_bytes gauge
+cloudflare_logs_http_response_bytes 0
+# HELP cloudflare_logs_http_responses_requests HTTP responses
+# TYPE cloudflare_logs_http_responses_requests gauge
+cloudflare_logs_http_responses_requests 0
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if err := g.setOverrides([]metricOverrideConfig{{Name: "cloudflare_logs_http_response", Unit: "bytes"}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudflare_logs_http_response",
+		Help: "Test metric",
+	}))
+	if _, err := g.Gather(); err == nil {
+		t.Error("expected error when metric names collide")
+	}
+}
+
+// TestOverrideGathererErrors checks that invalid overrides are rejected.
+func TestOverrideGathererErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		configs   []metricOverrideConfig
+	}{
+		{"without name", []metricOverrideConfig{{Help: "help"}}},
+		{"with invalid unit", []metricOverrideConfig{{Name: "metric", Unit: "Seconds"}}},
+		{"with duplicate name", []metricOverrideConfig{{Name: "metric", Help: "a"}, {Name: "metric", Help: "b"}}},
+	}
+
+	for _, c := range testCases {
+		if _, err := newOverrideGatherer(prometheus.NewRegistry(), c.configs); err == nil {
+			t.Errorf("expected error when called %s", c.condition)
+		}
+	}
+}
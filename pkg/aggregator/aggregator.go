@@ -0,0 +1,111 @@
+// Package aggregator defines how the metrics of cloudflare-logpull-exporter
+// are aggregated from the log entries of every zone, so that custom metrics
+// can be added by registering further aggregations.
+//
+// An aggregation is registered from the init function of its package:
+//
+//	func init() {
+//		aggregator.Register("customers", customerAggregation{})
+//	}
+//
+// and is linked into the exporter by importing the package for its side
+// effects in the exporter's plugins.go.
+package aggregator
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Entry is a log entry of a zone.
+type Entry interface {
+	// Field returns the value of the named Logpull field, or of the value
+	// nested in an object field, such as RequestHeaders.user-agent,
+	// formatted for use as a Prometheus label value. It returns an empty
+	// string if the field is missing.
+	Field(name string) string
+}
+
+// Window is a window of a zone's logs.
+type Window struct {
+	// ZoneID is the ID of the zone, and Zone the value of its zone label.
+	ZoneID string
+	Zone   string
+
+	Start time.Time
+	End   time.Time
+}
+
+// Aggregation is a set of metrics aggregated from the log entries of every
+// zone. The exporter pulls the fields needed by all of its aggregations at
+// once, and feeds every entry of a window to an Aggregator created for it by
+// each aggregation.
+type Aggregation interface {
+	// Fields returns the Logpull fields needed by the aggregation, in the
+	// form taken by Entry.Field.
+	Fields() []string
+
+	// Describe sends the descriptors of the aggregation's metrics to ch.
+	Describe(ch chan<- *prometheus.Desc)
+
+	// Window returns an aggregator for the given window of a zone's logs.
+	Window(window Window) Aggregator
+}
+
+// Aggregator aggregates the log entries of a window of a zone's logs.
+type Aggregator interface {
+	// Observe adds a log entry, which stands for weight requests, since
+	// logs may be sampled.
+	Observe(entry Entry, weight float64)
+
+	// Emit sends the aggregated metrics to ch. It is only called if the
+	// whole window was pulled, since partial windows would look like a
+	// sudden drop in traffic.
+	Emit(ch chan<- prometheus.Metric)
+}
+
+// Registration is a registered aggregation.
+type Registration struct {
+	Name        string
+	Aggregation Aggregation
+}
+
+var (
+	mu            sync.RWMutex
+	registrations []Registration
+)
+
+// Register registers an aggregation under the given name, so that the
+// exporter collects it along with its own metrics. Register panics if the
+// name is empty or already registered, or if the aggregation is nil, since
+// it is meant to be called from init functions.
+func Register(name string, aggregation Aggregation) {
+	if name == "" {
+		panic("aggregator: aggregation registered without name")
+	}
+	if aggregation == nil {
+		panic(fmt.Sprintf("aggregator: aggregation %s is nil", name))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, r := range registrations {
+		if r.Name == name {
+			panic(fmt.Sprintf("aggregator: aggregation %s registered twice", name))
+		}
+	}
+	registrations = append(registrations, Registration{name, aggregation})
+}
+
+// Registered returns the registered aggregations, in the order they were
+// registered.
+func Registered() []Registration {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return append([]Registration(nil), registrations...)
+}
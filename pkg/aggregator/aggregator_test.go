@@ -0,0 +1,74 @@
+package aggregator
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nopAggregation is an aggregation without metrics.
+type nopAggregation struct{}
+
+func (nopAggregation) Fields() []string                 { return nil }
+func (nopAggregation) Describe(chan<- *prometheus.Desc) {}
+func (nopAggregation) Window(Window) Aggregator         { return nil }
+
+// withRegistrations clears the registered aggregations for the duration of a
+// test.
+func withRegistrations(t *testing.T) {
+	mu.Lock()
+	saved := registrations
+	registrations = nil
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		registrations = saved
+		mu.Unlock()
+	})
+}
+
+// TestRegister checks that aggregations are returned in the order they were
+// registered.
+func TestRegister(t *testing.T) {
+	withRegistrations(t)
+
+	Register("b", nopAggregation{})
+	Register("a", nopAggregation{})
+
+	registered := Registered()
+	if len(registered) != 2 || registered[0].Name != "b" || registered[1].Name != "a" {
+		t.Errorf("expected aggregations b and a, got %v", registered)
+	}
+}
+
+// TestRegisterErrors checks that invalid registrations panic.
+func TestRegisterErrors(t *testing.T) {
+	testCases := []struct {
+		condition   string
+		name        string
+		aggregation Aggregation
+	}{
+		{"without name", "", nopAggregation{}},
+		{"without aggregation", "nil", nil},
+		{"with duplicate name", "registered", nopAggregation{}},
+	}
+
+	withRegistrations(t)
+	Register("registered", nopAggregation{})
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic when called %s", c.condition)
+				}
+			}()
+			Register(c.name, c.aggregation)
+		})
+	}
+
+	if registered := Registered(); len(registered) != 1 {
+		t.Errorf("expected only the first aggregation to be registered, got %v", registered)
+	}
+}
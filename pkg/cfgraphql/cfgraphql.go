@@ -0,0 +1,354 @@
+// Package cfgraphql is a client of the Cloudflare GraphQL Analytics API.
+//
+// The API is separate from the Logpull API: it accepts API tokens and API
+// keys, but not User-Service keys, is rate limited on its own, and reports
+// errors of queries in the body of 200 OK responses. Requests are therefore
+// made with a client of their own, which queries HTTP requests and firewall
+// events aggregated by the API:
+//
+//	client, err := cfgraphql.New(cfgraphql.Credentials{APIToken: token})
+//	groups, err := client.RequestGroups(ctx, zoneID, start, end, []string{"edgeResponseStatus"})
+package cfgraphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the API, unless overridden with
+// WithBaseURL.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// defaultUserAgent is the User-Agent header of requests, unless overridden
+// with WithUserAgent.
+const defaultUserAgent = "cloudflare-logpull-exporter"
+
+// GroupLimit is the maximum number of groups requested for a query. Queries
+// with more groups fail with ErrTooManyGroups, rather than undercount.
+const GroupLimit = 10000
+
+// ErrTooManyGroups is returned when a query has GroupLimit or more groups.
+var ErrTooManyGroups = fmt.Errorf("graphql: %d or more groups", GroupLimit)
+
+// Credentials authenticate requests to the API. Either an API token, or an
+// API key and the email address of its user, must be given.
+type Credentials struct {
+	APIToken string
+	APIKey   string
+	APIEmail string
+}
+
+// validate returns an error unless the credentials are complete.
+func (c Credentials) validate() error {
+	switch {
+	case c.APIToken != "" && c.APIKey != "":
+		return errors.New("invalid parameter: exactly one of APIToken or APIKey must be given")
+	case c.APIToken != "":
+		return nil
+	case c.APIKey != "":
+		if c.APIEmail == "" {
+			return errors.New("invalid parameter: APIKey given without APIEmail")
+		}
+		return nil
+	default:
+		return errors.New("invalid parameter: exactly one of APIToken or APIKey must be given")
+	}
+}
+
+// Client is a client of the GraphQL Analytics API. It is safe for concurrent
+// use.
+type Client struct {
+	baseURL    string
+	userAgent  string
+	httpClient *http.Client
+
+	mu          sync.RWMutex
+	credentials Credentials
+}
+
+// Option configures a Client.
+type Option func(*Client) error
+
+// WithBaseURL sets the base URL of the API, instead of DefaultBaseURL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) error {
+		if baseURL == "" {
+			return errors.New("invalid parameter: baseURL must not be empty")
+		}
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client requests are made with, instead of
+// http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("invalid parameter: httpClient must not be nil")
+		}
+		c.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) error {
+		if userAgent == "" {
+			return errors.New("invalid parameter: userAgent must not be empty")
+		}
+		c.userAgent = userAgent
+		return nil
+	}
+}
+
+// New creates a client with the given credentials and options. Returns an
+// error if the credentials or options are invalid.
+func New(credentials Credentials, opts ...Option) (*Client, error) {
+	if err := credentials.validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		baseURL:     DefaultBaseURL,
+		userAgent:   defaultUserAgent,
+		httpClient:  http.DefaultClient,
+		credentials: credentials,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// SetCredentials replaces the credentials of the client while it is in use,
+// e.g. once they were rotated. Returns an error if they are incomplete.
+func (c *Client) SetCredentials(credentials Credentials) error {
+	if err := credentials.validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.credentials = credentials
+	return nil
+}
+
+// Group is a group of HTTP requests or firewall events of a zone, as
+// aggregated by an adaptive groups dataset.
+type Group struct {
+	// Count is the number of requests or events of the group, and
+	// ResponseBytes the bytes returned in response to the requests.
+	Count         float64
+	ResponseBytes float64
+
+	// Dimensions holds the values of the requested dimensions shared by
+	// the requests, formatted as strings.
+	Dimensions map[string]string
+}
+
+// Error is returned when the API reports errors of a query.
+type Error struct {
+	Messages []string
+}
+
+func (e *Error) Error() string {
+	return "graphql: " + strings.Join(e.Messages, "; ")
+}
+
+// StatusError is returned when the API responds with a status other than 200
+// OK.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("graphql: unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// DecodeError is returned when the response of the API can't be decoded.
+type DecodeError struct {
+	err error
+}
+
+func (e *DecodeError) Error() string { return e.err.Error() }
+func (e *DecodeError) Unwrap() error { return e.err }
+
+// request is the body of requests to the API.
+type request struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// RequestGroups returns the HTTP requests of the given zone from start to
+// end, grouped by the given dimensions of the httpRequestsAdaptiveGroups
+// dataset. Unlike the Logpull API, the dataset does not require log
+// retention, and is available on all plans.
+func (c *Client) RequestGroups(ctx context.Context, zoneTag string, start, end time.Time, dimensions []string) ([]Group, error) {
+	return c.groups(ctx, "httpRequestsAdaptiveGroups", "sum { edgeResponseBytes }", zoneTag, start, end, dimensions)
+}
+
+// FirewallEventGroups returns the firewall events of the given zone from
+// start to end, such as the requests blocked or challenged by the WAF or
+// firewall rules, grouped by the given dimensions of the
+// firewallEventsAdaptiveGroups dataset, such as action and source. The
+// ResponseBytes of the groups are zero.
+func (c *Client) FirewallEventGroups(ctx context.Context, zoneTag string, start, end time.Time, dimensions []string) ([]Group, error) {
+	return c.groups(ctx, "firewallEventsAdaptiveGroups", "", zoneTag, start, end, dimensions)
+}
+
+// groups returns the groups of the given adaptive groups dataset of a zone
+// from start to end, by the given dimensions, along with the given further
+// fields of the dataset, which may be empty.
+func (c *Client) groups(ctx context.Context, dataset, fields, zoneTag string, start, end time.Time, dimensions []string) ([]Group, error) {
+	query := `query ($zoneTag: string, $start: Time, $end: Time) {
+  viewer {
+    zones(filter: {zoneTag: $zoneTag}) {
+      ` + dataset + `(limit: ` + strconv.Itoa(GroupLimit) + `, filter: {datetime_geq: $start, datetime_lt: $end}) {
+        count`
+	if fields != "" {
+		query += `
+        ` + fields
+	}
+	if len(dimensions) > 0 {
+		query += `
+        dimensions { ` + strings.Join(dimensions, " ") + ` }`
+	}
+	query += `
+      }
+    }
+  }
+}`
+
+	type group struct {
+		Count int64 `json:"count"`
+		Sum   struct {
+			EdgeResponseBytes int64 `json:"edgeResponseBytes"`
+		} `json:"sum"`
+		Dimensions map[string]interface{} `json:"dimensions"`
+	}
+	var result struct {
+		Viewer struct {
+			Zones []map[string][]group `json:"zones"`
+		} `json:"viewer"`
+	}
+	err := c.query(ctx, query, map[string]interface{}{
+		"zoneTag": zoneTag,
+		"start":   start.UTC().Format(time.RFC3339),
+		"end":     end.UTC().Format(time.RFC3339),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Viewer.Zones) != 1 {
+		return nil, errors.New("graphql: zone not found")
+	}
+
+	var groups []Group
+	for _, g := range result.Viewer.Zones[0][dataset] {
+		group := Group{
+			Count:         float64(g.Count),
+			ResponseBytes: float64(g.Sum.EdgeResponseBytes),
+			Dimensions:    make(map[string]string, len(dimensions)),
+		}
+		for _, dimension := range dimensions {
+			switch v := g.Dimensions[dimension].(type) {
+			case string:
+				group.Dimensions[dimension] = v
+			case float64:
+				group.Dimensions[dimension] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	if len(groups) >= GroupLimit {
+		return nil, ErrTooManyGroups
+	}
+
+	return groups, nil
+}
+
+// query performs the given query with the given variables, and decodes the
+// data of the response into data.
+func (c *Client) query(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+	body, err := json.Marshal(request{Query: query, Variables: variables})
+	if err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/graphql", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating graphql request: %w", err)
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("User-Agent", c.userAgent)
+
+	c.mu.RLock()
+	if c.credentials.APIToken != "" {
+		req.Header.Add("Authorization", "Bearer "+c.credentials.APIToken)
+	} else {
+		req.Header.Add("X-Auth-Key", c.credentials.APIKey)
+		req.Header.Add("X-Auth-Email", c.credentials.APIEmail)
+	}
+	c.mu.RUnlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("performing graphql request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading graphql response: %w", err)
+		}
+		return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))}
+	}
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return &DecodeError{fmt.Errorf("json: %w", err)}
+	}
+
+	// Errors are returned with a 200 OK status.
+	if len(result.Errors) > 0 {
+		messages := make([]string, len(result.Errors))
+		for i, e := range result.Errors {
+			messages[i] = e.Message
+		}
+		return &Error{Messages: messages}
+	}
+
+	if len(result.Data) == 0 {
+		return &DecodeError{errors.New("json: response without data")}
+	}
+	if err := json.Unmarshal(result.Data, data); err != nil {
+		return &DecodeError{fmt.Errorf("json: data: %w", err)}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package cfgraphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	goodToken  = "good-token"
+	goodZoneID = "023e105f4ecef8ad9ca31a8372d0c353"
+)
+
+var (
+	goodStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	goodEnd   = goodStart.Add(time.Minute)
+)
+
+// groupsResponse is a response with two groups of requests.
+const groupsResponse = `{"data":{"viewer":{"zones":[{"httpRequestsAdaptiveGroups":[
+{"count":120,"sum":{"edgeResponseBytes":4800},"dimensions":{"clientRequestHTTPHost":"example.org","edgeResponseStatus":200}},
+{"count":3,"sum":{"edgeResponseBytes":90},"dimensions":{"clientRequestHTTPHost":"example.org","edgeResponseStatus":502}}
+]}]}},"errors":null}`
+
+// newTestServer returns a server which checks the requests made to it, and
+// responds with the given status and body.
+func newTestServer(t *testing.T, status int, response string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/graphql" {
+			t.Errorf("called unexpected endpoint: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer "+goodToken {
+			t.Errorf("unexpected authorization: %q", r.Header.Get("Authorization"))
+		}
+
+		var body request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if body.Variables["zoneTag"] != goodZoneID {
+			t.Errorf("unexpected zone: %v", body.Variables["zoneTag"])
+		}
+		if !strings.Contains(body.Query, "dimensions { edgeResponseStatus clientRequestHTTPHost }") {
+			t.Errorf("unexpected query: %s", body.Query)
+		}
+
+		w.WriteHeader(status)
+		if _, err := w.Write([]byte(response)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestNew checks that clients are only created with complete credentials.
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		condition       string
+		credentials     Credentials
+		isErrorExpected bool
+	}{
+		{"with token", Credentials{APIToken: goodToken}, false},
+		{"with key and email", Credentials{APIKey: "key", APIEmail: "user@example.com"}, false},
+		{"with key without email", Credentials{APIKey: "key"}, true},
+		{"with token and key", Credentials{APIToken: goodToken, APIKey: "key", APIEmail: "user@example.com"}, true},
+		{"without credentials", Credentials{}, true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			_, err := New(c.credentials)
+			if c.isErrorExpected && err == nil {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+// TestRequestGroups checks that RequestGroups returns the groups of requests
+// keyed by dimension.
+func TestRequestGroups(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK, groupsResponse)
+
+	client, err := New(Credentials{APIToken: goodToken}, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	groups, err := client.RequestGroups(context.Background(), goodZoneID, goodStart, goodEnd, []string{"edgeResponseStatus", "clientRequestHTTPHost"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []Group{
+		{Count: 120, ResponseBytes: 4800, Dimensions: map[string]string{"clientRequestHTTPHost": "example.org", "edgeResponseStatus": "200"}},
+		{Count: 3, ResponseBytes: 90, Dimensions: map[string]string{"clientRequestHTTPHost": "example.org", "edgeResponseStatus": "502"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %+v, want %+v", groups, expected)
+	}
+}
+
+// TestFirewallEventGroups checks that FirewallEventGroups queries the
+// firewall events dataset and returns its groups keyed by dimension.
+func TestFirewallEventGroups(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if !strings.Contains(body.Query, "firewallEventsAdaptiveGroups") || !strings.Contains(body.Query, "dimensions { action source }") {
+			t.Errorf("unexpected query: %s", body.Query)
+		}
+		if _, err := w.Write([]byte(`{"data":{"viewer":{"zones":[{"firewallEventsAdaptiveGroups":[
+{"count":7,"dimensions":{"action":"block","source":"waf"}},
+{"count":2,"dimensions":{"action":"log","source":"firewallRules"}}
+]}]}},"errors":null}`)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := New(Credentials{APIToken: goodToken}, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	groups, err := client.FirewallEventGroups(context.Background(), goodZoneID, goodStart, goodEnd, []string{"action", "source"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []Group{
+		{Count: 7, Dimensions: map[string]string{"action": "block", "source": "waf"}},
+		{Count: 2, Dimensions: map[string]string{"action": "log", "source": "firewallRules"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %+v, want %+v", groups, expected)
+	}
+}
+
+// TestRequestGroupsErrors checks that the errors of the API are returned as
+// errors of their own types.
+func TestRequestGroupsErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		status    int
+		response  string
+		check     func(err error) bool
+	}{
+		{"with query errors", http.StatusOK, `{"data":null,"errors":[{"message":"zone does not have access to the path"}]}`, func(err error) bool {
+			var e *Error
+			return errors.As(err, &e) && e.Messages[0] == "zone does not have access to the path"
+		}},
+		{"with status other than 200 OK", http.StatusTooManyRequests, `rate limited`, func(err error) bool {
+			var e *StatusError
+			return errors.As(err, &e) && e.StatusCode == http.StatusTooManyRequests && e.Body == "rate limited"
+		}},
+		{"with malformed response", http.StatusOK, `{"data":`, func(err error) bool {
+			var e *DecodeError
+			return errors.As(err, &e)
+		}},
+		{"with response without data", http.StatusOK, `{}`, func(err error) bool {
+			var e *DecodeError
+			return errors.As(err, &e)
+		}},
+		{"with unknown zone", http.StatusOK, `{"data":{"viewer":{"zones":[]}}}`, func(err error) bool {
+			return err != nil && strings.Contains(err.Error(), "zone not found")
+		}},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			ts := newTestServer(t, c.status, c.response)
+
+			client, err := New(Credentials{APIToken: goodToken}, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, err = client.RequestGroups(context.Background(), goodZoneID, goodStart, goodEnd, []string{"edgeResponseStatus", "clientRequestHTTPHost"})
+			if !c.check(err) {
+				t.Errorf("unexpected error when called %s: %v", c.condition, err)
+			}
+		})
+	}
+}
+
+// TestSetCredentials checks that rotated credentials are used by the
+// following requests.
+func TestSetCredentials(t *testing.T) {
+	ts := newTestServer(t, http.StatusOK, groupsResponse)
+
+	client, err := New(Credentials{APIToken: "old-token"}, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := client.SetCredentials(Credentials{}); err == nil {
+		t.Error("expected error when called without credentials")
+	}
+	if err := client.SetCredentials(Credentials{APIToken: goodToken}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := client.RequestGroups(context.Background(), goodZoneID, goodStart, goodEnd, []string{"edgeResponseStatus", "clientRequestHTTPHost"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
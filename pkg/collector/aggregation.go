@@ -0,0 +1,233 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/aggregator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aggregations returns the aggregations enabled by the collector's
+// configuration, followed by the registered plugins, in the order their
+// fields are requested. Further metrics of the exporter are defined by
+// implementing aggregator.Aggregation and returning it from here.
+func (c *Collector) aggregations() []aggregator.Aggregation {
+	aggregations := []aggregator.Aggregation{responseAggregation{c}}
+
+	if c.asnTopN > 0 {
+		aggregations = append(aggregations, topNAggregation{c, c.asnDesc, c.asnTopN, asnLogFields, false, func(entry *LogEntry) string {
+			return strconv.Itoa(entry.ClientASN)
+		}})
+	}
+	if c.countryTopN > 0 {
+		aggregations = append(aggregations, topNAggregation{c, c.countryDesc, c.countryTopN, countryLogFields, false, func(entry *LogEntry) string {
+			return entry.ClientCountry
+		}})
+	}
+	if c.coloTopN > 0 {
+		aggregations = append(aggregations, topNAggregation{c, c.coloDesc, c.coloTopN, coloLogFields, false, func(entry *LogEntry) string {
+			return entry.EdgeColoCode
+		}})
+	}
+	if c.ja3TopN > 0 {
+		// JA3 fingerprints are only logged for zones with Bot
+		// Management enabled.
+		aggregations = append(aggregations, topNAggregation{c, c.ja3Desc, c.ja3TopN, ja3LogFields, true, func(entry *LogEntry) string {
+			return entry.JA3Hash
+		}})
+	}
+
+	for _, r := range registeredAggregations() {
+		aggregations = append(aggregations, r.Aggregation)
+	}
+	return aggregations
+}
+
+// responseAggregation aggregates the HTTP response metrics, labeled by host
+// and status by default, along with the metrics derived from the same
+// responses, such as cache statuses and origin response durations, if
+// enabled. In
+// incremental mode, the counts of every window are added to the zone's cursor,
+// which the collector reports whether or not the window was pulled.
+type responseAggregation struct {
+	c *Collector
+}
+
+func (a responseAggregation) Fields() []string {
+	c := a.c
+
+	var fields []string
+	for _, l := range c.responseLabels {
+		fields = append(fields, logpullField(l.Field))
+	}
+	if c.responseBytes {
+		fields = append(fields, bytesLogFields...)
+	}
+	if c.cacheStatus {
+		fields = append(fields, cacheLogFields...)
+	}
+	if c.originDuration {
+		fields = append(fields, originDurationLogFields...)
+	}
+	if c.firewallEvents {
+		fields = append(fields, firewallLogFields...)
+	}
+	if c.tieredCache {
+		fields = append(fields, tieredCacheLogFields...)
+	}
+	if c.botScores {
+		fields = append(fields, botLogFields...)
+	}
+	if c.endpointMethods {
+		fields = append(fields, methodLogFields...)
+	}
+	if c.paths != nil {
+		fields = append(fields, pathLogFields...)
+	}
+	return fields
+}
+
+func (a responseAggregation) Describe(ch chan<- *prometheus.Desc) {
+	if a.c.splitStatus {
+		ch <- a.c.edgeResponseDesc
+		ch <- a.c.originResponseDesc
+	} else {
+		ch <- a.c.responseDesc
+	}
+	ch <- a.c.bytesDesc
+	ch <- a.c.cacheDesc
+	ch <- a.c.firewallDesc
+	ch <- a.c.tieredDesc
+	ch <- a.c.botDesc
+	ch <- a.c.endpointDesc
+	ch <- a.c.durationDesc
+}
+
+func (a responseAggregation) Window(w aggregator.Window) aggregator.Aggregator {
+	return &responseAggregator{c: a.c, zoneID: w.ZoneID, start: w.Start, end: w.End, counts: newWindowCounts()}
+}
+
+// responseAggregator is the aggregator of responseAggregation.
+type responseAggregator struct {
+	c      *Collector
+	zoneID string
+	start  time.Time
+	end    time.Time
+	counts windowCounts
+}
+
+func (a *responseAggregator) Observe(e aggregator.Entry, weight float64) {
+	c, counts, entry := a.c, a.counts, e.(*LogEntry)
+
+	values := make([]string, len(c.responseLabels))
+	for i, l := range c.responseLabels {
+		values[i] = entry.Field(l.Field)
+	}
+	key := strings.Join(values, labelValueSeparator)
+	totals := counts.responses[key]
+	totals.count += weight
+	totals.bytes += float64(entry.EdgeResponseBytes) * weight
+	counts.responses[key] = totals
+	if c.cacheStatus {
+		counts.cacheStatuses[entry.ClientRequestHost+labelValueSeparator+entry.CacheCacheStatus] += weight
+	}
+	for i, action := range entry.FirewallMatchesActions {
+		var source string
+		if i < len(entry.FirewallMatchesSources) {
+			source = entry.FirewallMatchesSources[i]
+		}
+		counts.firewallEvents[action+labelValueSeparator+source] += weight
+	}
+	// Responses served without contacting the origin, such as cache hits,
+	// have no origin response status.
+	if c.originDuration && entry.OriginResponseStatus != 0 {
+		d := counts.originDuration[entry.ClientRequestHost]
+		d.observe(time.Duration(entry.OriginResponseTime).Seconds(), weight, c.durationBuckets)
+		counts.originDuration[entry.ClientRequestHost] = d
+	}
+	// Likewise, tiered fills which didn't reach the origin were served from
+	// the upper tier's cache.
+	if entry.CacheTieredFill {
+		if entry.OriginResponseStatus == 0 {
+			counts.tieredFills["hit"] += weight
+		} else {
+			counts.tieredFills["miss"] += weight
+		}
+	}
+	if c.botScores {
+		counts.botRequests[botScoreRange(entry.BotScore)+labelValueSeparator+entry.BotScoreSrc] += weight
+	}
+	if c.endpointMethods || c.paths != nil {
+		var values []string
+		if c.endpointMethods {
+			values = append(values, entry.ClientRequestMethod)
+		}
+		if c.paths != nil {
+			values = append(values, c.paths.group(entry.ClientRequestURI))
+		}
+		counts.endpoints[strings.Join(values, labelValueSeparator)] += weight
+	}
+}
+
+func (a *responseAggregator) Emit(ch chan<- prometheus.Metric) {
+	a.c.addResponseKeys(a.zoneID, a.counts)
+
+	if !a.c.incremental {
+		a.c.collectCounts(ch, prometheus.GaugeValue, a.zoneID, a.counts)
+		return
+	}
+
+	a.c.cursors[a.zoneID].advance(a.end, a.counts)
+	a.c.recordEvent(newWindowEvent(eventCursorAdvanced, a.zoneID, a.start, a.end))
+}
+
+// topNAggregation counts the requests of every zone by a single label, such
+// as the client country, reporting the n values with the most requests and
+// folding the rest into an "other" series. Its metrics are gauges over the
+// pulled window, even in incremental mode: values enter and leave the top n
+// from one window to the next, so cumulative counts, and "other" in
+// particular, could decrease, which counters must not.
+type topNAggregation struct {
+	c         *Collector
+	desc      *prometheus.Desc
+	n         int
+	logFields []string
+	// omitEmpty leaves out entries without a value.
+	omitEmpty bool
+	label     func(entry *LogEntry) string
+}
+
+func (a topNAggregation) Fields() []string {
+	return a.logFields
+}
+
+func (a topNAggregation) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a topNAggregation) Window(w aggregator.Window) aggregator.Aggregator {
+	return &topNAggregator{a: a, zoneID: w.ZoneID, counts: make(map[string]float64)}
+}
+
+// topNAggregator is the aggregator of topNAggregation.
+type topNAggregator struct {
+	a      topNAggregation
+	zoneID string
+	counts map[string]float64
+}
+
+func (a *topNAggregator) Observe(entry aggregator.Entry, weight float64) {
+	value := a.a.label(entry.(*LogEntry))
+	if value == "" && a.a.omitEmpty {
+		return
+	}
+	a.counts[value] += weight
+}
+
+func (a *topNAggregator) Emit(ch chan<- prometheus.Metric) {
+	for value, count := range topN(a.counts, a.a.n) {
+		ch <- prometheus.MustNewConstMetric(a.a.desc, prometheus.GaugeValue, count, a.a.c.ZoneLabelValues(a.zoneID, value)...)
+	}
+}
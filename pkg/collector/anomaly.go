@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"math"
+	"sync"
+)
+
+// anomalyWarmup is the number of observations an ewmaDetector needs before it
+// starts reporting non-zero scores. Scores computed from only a handful of
+// observations are dominated by noise.
+const anomalyWarmup = 5
+
+// ewmaDetector tracks the exponentially weighted moving average and variance
+// of a series of observations, and scores each new observation by how many
+// standard deviations it lies from the average (its z-score).
+type ewmaDetector struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	n        int
+}
+
+// observe scores x against the observations seen so far, then folds x into
+// the moving average and variance.
+func (d *ewmaDetector) observe(x float64) float64 {
+	d.n++
+	if d.n == 1 {
+		d.mean = x
+		return 0
+	}
+
+	diff := x - d.mean
+
+	var score float64
+	if d.n > anomalyWarmup && d.variance > 0 {
+		score = diff / math.Sqrt(d.variance)
+	}
+
+	incr := d.alpha * diff
+	d.mean += incr
+	d.variance = (1 - d.alpha) * (d.variance + diff*incr)
+
+	return score
+}
+
+// anomalyDetectors holds one ewmaDetector per zone and signal. It is safe for
+// concurrent use.
+type anomalyDetectors struct {
+	alpha     float64
+	mu        sync.Mutex
+	detectors map[[2]string]*ewmaDetector
+}
+
+func newAnomalyDetectors(alpha float64) *anomalyDetectors {
+	return &anomalyDetectors{
+		alpha:     alpha,
+		detectors: make(map[[2]string]*ewmaDetector),
+	}
+}
+
+// observe scores x with the detector for the given zone and signal, creating
+// it if necessary.
+func (a *anomalyDetectors) observe(zoneID, signal string, x float64) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := [2]string{zoneID, signal}
+	d, ok := a.detectors[key]
+	if !ok {
+		d = &ewmaDetector{alpha: a.alpha}
+		a.detectors[key] = d
+	}
+
+	return d.observe(x)
+}
+
+// size returns the number of detectors.
+func (a *anomalyDetectors) size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.detectors)
+}
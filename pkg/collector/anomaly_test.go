@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"testing"
+)
+
+// TestEWMADetector checks that an ewmaDetector reports no anomaly for a steady
+// series, and a large positive score for a sudden spike.
+func TestEWMADetector(t *testing.T) {
+	d := &ewmaDetector{alpha: 0.1}
+
+	for i := 0; i < 20; i++ {
+		x := 100.0
+		if i%2 == 0 {
+			x = 110.0
+		}
+		if score := d.observe(x); score < -3 || score > 3 {
+			t.Errorf("observation %d: unexpected score for steady series: %f", i, score)
+		}
+	}
+
+	if score := d.observe(1000); score < 10 {
+		t.Errorf("expected a large score for a spike, got %f", score)
+	}
+}
+
+// TestEWMADetectorWarmup checks that an ewmaDetector reports zero scores until
+// it has seen enough observations.
+func TestEWMADetectorWarmup(t *testing.T) {
+	d := &ewmaDetector{alpha: 0.5}
+
+	for i, x := range []float64{1, 1000, 1, 1000, 1} {
+		if score := d.observe(x); score != 0 {
+			t.Errorf("observation %d: expected zero score during warmup, got %f", i, score)
+		}
+	}
+}
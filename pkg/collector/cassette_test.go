@@ -0,0 +1,264 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// cassetteZoneID replaces the ID of the zone used for recording in cassettes.
+const cassetteZoneID = "023e105f4ecef8ad9ca31a8372d0c353"
+
+// cassetteHeaders are the response headers kept in cassettes. Others, such as
+// cookies and request IDs, are dropped.
+var cassetteHeaders = []string{"Content-Type", "Retry-After"}
+
+// cassette is a sequence of interactions with the Cloudflare API, recorded
+// from the live API and sanitized, which is replayed in tests to lock in the
+// client's behavior against real responses.
+type cassette struct {
+	Interactions []interaction `json:"interactions"`
+}
+
+// interaction is a single request and its response. The query is not
+// recorded, since it contains the time window of the request.
+type interaction struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// cassetteTransport is an http.RoundTripper which either records interactions
+// with the live API into a cassette, or replays them in order.
+type cassetteTransport struct {
+	recording bool
+	next      http.RoundTripper
+	zoneID    string
+	cassette  cassette
+	pos       int
+}
+
+// sanitize replaces the ID of the recorded zone with cassetteZoneID.
+func (c *cassetteTransport) sanitize(s string) string {
+	return strings.Replace(s, c.zoneID, cassetteZoneID, -1)
+}
+
+func (c *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := c.sanitize(req.URL.Path)
+
+	if c.recording {
+		// Responses are recorded uncompressed, so that cassettes stay
+		// readable; the client's handling of compression is tested
+		// separately.
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+
+		resp, err := c.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		header := make(http.Header)
+		for _, name := range cassetteHeaders {
+			if v := resp.Header.Get(name); v != "" {
+				header.Set(name, v)
+			}
+		}
+
+		c.cassette.Interactions = append(c.cassette.Interactions, interaction{
+			Method: req.Method,
+			Path:   path,
+			Status: resp.StatusCode,
+			Header: header,
+			Body:   c.sanitize(string(body)),
+		})
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+
+	if c.pos >= len(c.cassette.Interactions) {
+		return nil, fmt.Errorf("unexpected request: %s %s", req.Method, path)
+	}
+
+	i := c.cassette.Interactions[c.pos]
+	c.pos++
+	if i.Method != req.Method || i.Path != path {
+		return nil, fmt.Errorf("unexpected request: %s %s, expected %s %s", req.Method, path, i.Method, i.Path)
+	}
+
+	header := i.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode: i.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(i.Body)),
+		Request:    req,
+	}, nil
+}
+
+// useCassette returns a Logpull API client and zone ID which replay the named
+// cassette from testdata/cassettes, and fails the test unless all of its
+// interactions were replayed.
+//
+// If the EXPORTER_TEST_RECORD environment variable is non-empty, the client
+// uses the live API instead, and the cassette is recorded anew. This requires
+// CLOUDFLARE_TEST_API_TOKEN and CLOUDFLARE_TEST_ZONE_NAME to be set, as for
+// TestPullLogEntriesLiveEndpoint.
+func useCassette(t *testing.T, name string) (*LogpullAPI, string) {
+	path := filepath.Join("testdata", "cassettes", name+".json")
+	transport := &cassetteTransport{zoneID: cassetteZoneID}
+
+	var token string
+	if os.Getenv("EXPORTER_TEST_RECORD") != "" {
+		token = os.Getenv("CLOUDFLARE_TEST_API_TOKEN")
+		zoneName := os.Getenv("CLOUDFLARE_TEST_ZONE_NAME")
+		if token == "" || zoneName == "" {
+			t.Fatal("CLOUDFLARE_TEST_API_TOKEN and CLOUDFLARE_TEST_ZONE_NAME must be specified")
+		}
+
+		cfapi, err := cloudflare.NewWithAPIToken(token)
+		if err != nil {
+			t.Fatalf("creating cfapi client: %s", err)
+		}
+
+		transport.zoneID, err = cfapi.ZoneIDByName(zoneName)
+		if err != nil {
+			t.Fatalf("zone id lookup: %s", err)
+		}
+
+		transport.recording = true
+		transport.next = http.DefaultTransport
+	} else {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading cassette: %s", err)
+		}
+		if err := json.Unmarshal(data, &transport.cassette); err != nil {
+			t.Fatalf("parsing cassette: %s", err)
+		}
+	}
+
+	api, err := NewLogpullAPIWithToken(token, WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if !transport.recording {
+			if transport.pos != len(transport.cassette.Interactions) {
+				t.Errorf("only %d of %d interactions replayed", transport.pos, len(transport.cassette.Interactions))
+			}
+			return
+		}
+
+		data, err := json.MarshalIndent(transport.cassette, "", "  ")
+		if err != nil {
+			t.Fatalf("encoding cassette: %s", err)
+		}
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("writing cassette: %s", err)
+		}
+	})
+
+	return api, transport.zoneID
+}
+
+// TestCassettePullLogEntries checks that log entries are pulled from a
+// recorded Logpull API response.
+func TestCassettePullLogEntries(t *testing.T) {
+	api, zoneID := useCassette(t, "pull_log_entries")
+
+	end := time.Now().Add(-1 * time.Minute)
+	entries := 0
+	err := api.pullLogEntries(zoneID, end.Add(-1*time.Minute), end, nil, func(entry LogEntry) error {
+		if entry.ClientRequestHost == "" || entry.EdgeResponseStatus == 0 {
+			t.Errorf("incomplete log entry: %+v", entry)
+		}
+		entries++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	t.Logf("pulled %d log entries", entries)
+}
+
+// TestCassettePullFields checks that the available fields are listed from a
+// recorded Logpull API response.
+func TestCassettePullFields(t *testing.T) {
+	api, zoneID := useCassette(t, "pull_fields")
+
+	fields, err := api.pullFieldsContext(context.Background(), zoneID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, name := range defaultLogFields {
+		found := false
+		for _, field := range fields {
+			found = found || field == name
+		}
+		if !found {
+			t.Errorf("field %s not listed", name)
+		}
+	}
+}
+
+// TestCassetteRetention checks that the log retention flag is read from a
+// recorded API response.
+func TestCassetteRetention(t *testing.T) {
+	api, zoneID := useCassette(t, "get_retention")
+
+	enabled, err := api.GetRetentionContext(context.Background(), zoneID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !enabled {
+		t.Error("expected log retention to be enabled")
+	}
+}
+
+// TestCassetteErrorEnvelope checks that an error response of the API, which
+// comes in the JSON error envelope, is reported as a pull error.
+func TestCassetteErrorEnvelope(t *testing.T) {
+	api, _ := useCassette(t, "error_envelope")
+
+	end := time.Now().Add(-1 * time.Minute)
+	err := api.pullLogEntries(nonexistentZoneID, end.Add(-1*time.Minute), end, nil, nopLogHandler)
+
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected statusError, got %v", err)
+	}
+	if statusErr.statusCode < 400 || statusErr.statusCode >= 500 {
+		t.Errorf("expected client error, got status %d", statusErr.statusCode)
+	}
+}
@@ -0,0 +1,2153 @@
+// Package collector is a Prometheus collector of the HTTP request logs of
+// Cloudflare zones, pulled from the Logpull API.
+//
+// On every scrape, the logs of the log period ending a minute earlier are
+// pulled and aggregated into metrics as they are decoded, unless background
+// collection is enabled with SetRefreshInterval. The collector is configured
+// with its setters before it is registered:
+//
+//	api, err := collector.NewLogpullAPIWithToken(token, collector.WithRetry(3, time.Second, 10*time.Second))
+//	c, err := collector.New(api, []string{zoneID}, time.Minute, errorHandler)
+//	c.SetCacheStatus(true)
+//	err = c.Register(prometheus.DefaultRegisterer, "")
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/aggregator"
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/cfgraphql"
+	"github.com/prometheus/client_golang/prometheus"
+	prommodel "github.com/prometheus/common/model"
+)
+
+// The Cloudflare API docs specify that 'start' must be no more than seven days
+// earlier from now, and that 'end' must be at least one minute earlier than
+// now. Thus, logPeriod must be smaller than seven days, less one minute to
+// account for the one minute offset.
+// https://developers.cloudflare.com/logs/logpull-api/requesting-logs#parameters
+const logPeriodRange = 7*24*time.Hour - time.Minute
+
+// maxIncrementalWindow bounds the length of a single pull in incremental mode,
+// so that catching up after a gap is spread over several collections.
+const maxIncrementalWindow = time.Hour
+
+// otherLabelValue is the label value used for the overflow bucket of metrics
+// whose cardinality is capped.
+const otherLabelValue = "other"
+
+// Stages of collection distinguished by the error counter.
+const (
+	stagePull   = "pull"
+	stageDecode = "decode"
+)
+
+// labelValueSeparator is used to join label values into a single map key.
+// It can't appear in label values, since those are valid UTF-8.
+const labelValueSeparator = "\xff"
+
+// responseTotals aggregates the HTTP responses sharing the same label values.
+type responseTotals struct {
+	count float64
+	bytes float64
+}
+
+// durationTotals aggregates the observations of a histogram. buckets holds the
+// cumulative count of observations for each upper bound of the histogram.
+// Counts are fractional, since sampled observations are weighted.
+type durationTotals struct {
+	count   float64
+	sum     float64
+	buckets []float64
+}
+
+// observe adds an observation of v with the given weight to d, given the
+// histogram's upper bounds.
+func (d *durationTotals) observe(v, weight float64, bounds []float64) {
+	if d.buckets == nil {
+		d.buckets = make([]float64, len(bounds))
+	}
+	for i, bound := range bounds {
+		if v <= bound {
+			d.buckets[i] += weight
+		}
+	}
+	d.count += weight
+	d.sum += v * weight
+}
+
+// windowCounts holds the counts aggregated from a window of a zone's logs,
+// keyed by joined label values.
+type windowCounts struct {
+	responses      map[string]responseTotals
+	cacheStatuses  map[string]float64
+	firewallEvents map[string]float64
+	tieredFills    map[string]float64
+	botRequests    map[string]float64
+	endpoints      map[string]float64
+	originDuration map[string]durationTotals
+}
+
+func newWindowCounts() windowCounts {
+	return windowCounts{
+		responses:      make(map[string]responseTotals),
+		cacheStatuses:  make(map[string]float64),
+		firewallEvents: make(map[string]float64),
+		tieredFills:    make(map[string]float64),
+		botRequests:    make(map[string]float64),
+		endpoints:      make(map[string]float64),
+		originDuration: make(map[string]durationTotals),
+	}
+}
+
+// add adds the counts of other to w.
+func (w windowCounts) add(other windowCounts) {
+	for key, totals := range other.responses {
+		t := w.responses[key]
+		t.count += totals.count
+		t.bytes += totals.bytes
+		w.responses[key] = t
+	}
+	for key, count := range other.cacheStatuses {
+		w.cacheStatuses[key] += count
+	}
+	for key, count := range other.firewallEvents {
+		w.firewallEvents[key] += count
+	}
+	for key, count := range other.tieredFills {
+		w.tieredFills[key] += count
+	}
+	for key, count := range other.botRequests {
+		w.botRequests[key] += count
+	}
+	for key, count := range other.endpoints {
+		w.endpoints[key] += count
+	}
+	for key, totals := range other.originDuration {
+		d := w.originDuration[key]
+		if d.buckets == nil {
+			d.buckets = make([]float64, len(totals.buckets))
+		}
+		for i, n := range totals.buckets {
+			d.buckets[i] += n
+		}
+		d.count += totals.count
+		d.sum += totals.sum
+		w.originDuration[key] = d
+	}
+}
+
+// series returns the number of distinct series held by w.
+func (w windowCounts) series() int {
+	return len(w.responses) + len(w.cacheStatuses) + len(w.firewallEvents) + len(w.tieredFills) + len(w.botRequests) + len(w.endpoints) + len(w.originDuration)
+}
+
+// zoneCursor tracks the progress of incremental collection for a zone, along
+// with the cumulative counts collected so far.
+type zoneCursor struct {
+	// pullMu serializes pulls of the zone, so that concurrent collections
+	// don't pull the same window twice.
+	pullMu sync.Mutex
+
+	mu     sync.Mutex
+	end    time.Time
+	counts windowCounts
+}
+
+// window returns the next window to pull, which starts where the previous
+// successful pull ended and ends no later than end. If logs had to be skipped
+// because the previous pull ended too long ago, the returned skipped time is
+// where that gap started; otherwise it is zero.
+func (z *zoneCursor) window(end time.Time, logPeriod time.Duration) (start, newEnd, skipped time.Time) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// Times are passed to the API with a resolution of one second, so
+	// windows are aligned to whole seconds to avoid gaps or overlaps.
+	end = end.Truncate(time.Second)
+
+	start = z.end
+	if start.IsZero() {
+		start = end.Add(-1 * logPeriod)
+	}
+
+	if earliest := end.Add(-1 * logPeriodRange); start.Before(earliest) {
+		skipped = start
+		start = earliest
+	}
+
+	if end.Sub(start) > maxIncrementalWindow {
+		end = start.Add(maxIncrementalWindow)
+	}
+
+	return start, end, skipped
+}
+
+// advance records a successful pull of the window ending at end.
+func (z *zoneCursor) advance(end time.Time, counts windowCounts) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.end = end
+	z.counts.add(counts)
+}
+
+// resetResponses discards the cumulative counts of HTTP responses, after their
+// label set has changed.
+func (z *zoneCursor) resetResponses() {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.counts.responses = make(map[string]responseTotals)
+}
+
+// snapshot returns the end of the last successful pull and a copy of the
+// cumulative counts.
+func (z *zoneCursor) snapshot() (time.Time, windowCounts) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	counts := newWindowCounts()
+	counts.add(z.counts)
+
+	return z.end, counts
+}
+
+type Collector struct {
+	// inFlight is the number of pulls in progress. scrapeDeadline is
+	// the deadline of the scrape being served, in Unix nanoseconds, or
+	// zero. They are accessed atomically, and are the first fields so
+	// that they are 64-bit aligned on 32-bit platforms.
+	inFlight       int64
+	scrapeDeadline int64
+
+	// configMu guards the configuration which may be reloaded while
+	// the collector is in use: the zones, their names, metadata and
+	// clients, including the GraphQL Analytics API clients of the zones
+	// collected through it instead of Logpull, the response label set
+	// and keys, and the descriptors and cursors derived from them.
+	configMu sync.RWMutex
+
+	api            *LogpullAPI
+	zoneAPIs       map[string]*LogpullAPI
+	graphqlZones   map[string]*cfgraphql.Client
+	zoneIDs        []string
+	zoneNames      map[string]string
+	zoneIDLabel    bool
+	metadataLabels []string
+	zoneMetadata   map[string]map[string]string
+	logPeriod      time.Duration
+	responseLabels []LabelConfig
+	responseKeys   []map[string]string
+	responseDesc   *prometheus.Desc
+	bytesDesc      *prometheus.Desc
+	cacheDesc      *prometheus.Desc
+	firewallDesc   *prometheus.Desc
+	tieredDesc     *prometheus.Desc
+	botDesc        *prometheus.Desc
+	endpointDesc   *prometheus.Desc
+	durationDesc   *prometheus.Desc
+	errorCounter   *prometheus.CounterVec
+	errorHandler   func(error)
+	retryDesc      *prometheus.Desc
+	rateLimitDesc  *prometheus.Desc
+	stallDesc      *prometheus.Desc
+	resumeDesc     *prometheus.Desc
+	fipsDesc       *prometheus.Desc
+
+	// splitStatus replaces the HTTP responses metric by one per status,
+	// labeled by that status only.
+	splitStatus        bool
+	edgeResponseDesc   *prometheus.Desc
+	originResponseDesc *prometheus.Desc
+
+	requests        *prometheus.CounterVec
+	requestBytes    prometheus.Counter
+	requestDuration prometheus.Histogram
+	oversized       *prometheus.CounterVec
+	collectTimeouts prometheus.Counter
+	outbound        *OutboundMetrics
+
+	zoneHandler    func(zoneID string, err error)
+	collectHandler func(ok bool)
+	events         *EventLog
+	logger         Logger
+	status         *statusTracker
+
+	timeout     time.Duration
+	concurrency int
+	hosts       *HostLimiter
+
+	incremental bool
+	cursors     map[string]*zoneCursor
+
+	responseBytes  bool
+	cacheStatus    bool
+	originDuration bool
+	firewallEvents bool
+	tieredCache    bool
+	botScores      bool
+
+	// endpointMethods and paths enable the request method and path group
+	// labels of the endpoint requests metric, which is only reported if
+	// either is set.
+	endpointMethods bool
+	paths           *PathGrouper
+
+	durationBuckets []float64
+
+	ja3TopN int
+	ja3Desc *prometheus.Desc
+
+	asnTopN int
+	asnDesc *prometheus.Desc
+
+	countryTopN int
+	countryDesc *prometheus.Desc
+
+	coloTopN int
+	coloDesc *prometheus.Desc
+
+	anomalies   *anomalyDetectors
+	anomalyDesc *prometheus.Desc
+
+	emptyWindowDesc *prometheus.Desc
+
+	schema            *schemaTracker
+	schemaChangesDesc *prometheus.Desc
+	missingFieldsDesc *prometheus.Desc
+
+	refreshInterval time.Duration
+	refreshDesc     *prometheus.Desc
+	snapshotMu      sync.Mutex
+	snapshot        []prometheus.Metric
+	refreshed       time.Time
+}
+
+// New creates a new Logpull collector. Returns an error if any parameters
+// are invalid.
+func New(api *LogpullAPI, zoneIDs []string, logPeriod time.Duration, errorHandler func(error)) (*Collector, error) {
+	if api == nil {
+		return nil, errors.New("invalid parameter: api must not be nil")
+	}
+
+	if len(zoneIDs) == 0 {
+		return nil, errors.New("invalid parameter: zoneIDs must not be empty")
+	}
+
+	if logPeriod >= logPeriodRange {
+		return nil, errors.New("invalid parameter: logPeriod out of acceptable range")
+	}
+
+	retryDesc := prometheus.NewDesc(
+		"cloudflare_logpull_retries_total",
+		"The number of Logpull API requests that have been retried",
+		nil,
+		nil,
+	)
+
+	rateLimitDesc := prometheus.NewDesc(
+		"cloudflare_logpull_rate_limited_total",
+		"The number of Logpull API requests that have been rejected by rate limiting",
+		nil,
+		nil,
+	)
+
+	stallDesc := prometheus.NewDesc(
+		"cloudflare_logpull_stalls_total",
+		"The number of Logpull API downloads that have been aborted because no data was received",
+		nil,
+		nil,
+	)
+
+	resumeDesc := prometheus.NewDesc(
+		"cloudflare_logpull_resumes_total",
+		"The number of interrupted Logpull API downloads that have been resumed after the last received log entry",
+		nil,
+		nil,
+	)
+
+	fipsDesc := prometheus.NewDesc(
+		"cloudflare_logpull_fips_mode",
+		"Whether the exporter performs cryptography with a FIPS 140-2 validated module (1) or not (0)",
+		nil,
+		nil,
+	)
+
+	refreshDesc := prometheus.NewDesc(
+		"cloudflare_logs_last_refresh_timestamp_seconds",
+		"Unix time of the latest background collection of metrics from Logpull API",
+		nil,
+		nil,
+	)
+
+	c := &Collector{
+		api:             api,
+		zoneIDs:         zoneIDs,
+		logPeriod:       logPeriod,
+		responseLabels:  DefaultResponseLabels,
+		durationBuckets: prometheus.DefBuckets,
+		errorHandler:    errorHandler,
+		retryDesc:       retryDesc,
+		rateLimitDesc:   rateLimitDesc,
+		stallDesc:       stallDesc,
+		resumeDesc:      resumeDesc,
+		fipsDesc:        fipsDesc,
+		refreshDesc:     refreshDesc,
+		status:          newStatusTracker(zoneIDs),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_requests_total",
+			Help: "The number of Logpull API requests, by HTTP status code, or error if no response was received",
+		}, []string{"status"}),
+		requestBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_request_bytes_total",
+			Help: "The number of bytes received in Logpull API responses, before decompression",
+		}),
+		requestDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cloudflare_logpull_request_duration_seconds",
+			Help:    "The time taken by Logpull API requests, until their response was read",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}),
+		oversized: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_oversized_downloads_total",
+			Help: "The number of Logpull API log downloads larger than the size budget, by the action taken",
+		}, []string{"action"}),
+		collectTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cloudflare_logs_collect_timeouts_total",
+			Help: "The number of collections which returned partial results because their deadline was hit",
+		}),
+	}
+	c.buildErrorCounter()
+	c.buildDescs()
+
+	return c, nil
+}
+
+// zoneLabelNames returns the names of the labels identifying the zone of
+// per-zone metrics.
+func (c *Collector) zoneLabelNames() []string {
+	names := []string{"zone"}
+	if c.zoneIDLabel {
+		names = append(names, "zone_id")
+	}
+	return append(names, c.metadataLabels...)
+}
+
+// ZoneLabelValues returns the values of the labels identifying the given zone,
+// followed by values. The zone label falls back to the zone ID if the zone's
+// name is unknown, and zone metadata labels are empty until the zone's
+// metadata is known.
+func (c *Collector) ZoneLabelValues(zoneID string, values ...string) []string {
+	name, ok := c.zoneNames[zoneID]
+	if !ok {
+		name = zoneID
+	}
+
+	labelValues := []string{name}
+	if c.zoneIDLabel {
+		labelValues = append(labelValues, zoneID)
+	}
+	for _, label := range c.metadataLabels {
+		labelValues = append(labelValues, c.zoneMetadata[zoneID][label])
+	}
+	return append(labelValues, values...)
+}
+
+// buildErrorCounter creates the error counter, with a series for every zone
+// and stage.
+func (c *Collector) buildErrorCounter() {
+	c.errorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudflare_logs_errors_total",
+		Help: "The number of errors that have occurred while collecting metrics",
+	}, append(c.zoneLabelNames(), "stage"))
+	for _, zoneID := range c.zoneIDs {
+		c.initErrorCounter(zoneID)
+	}
+}
+
+// initErrorCounter creates the error counter series of the given zone, so
+// that they are reported before any error occurs.
+func (c *Collector) initErrorCounter(zoneID string) {
+	for _, stage := range []string{stagePull, stageDecode} {
+		c.errorCounter.WithLabelValues(c.ZoneLabelValues(zoneID, stage)...)
+	}
+}
+
+// statusFields are the response status fields, which are reported as separate
+// metrics if the status is split.
+var statusFields = []string{"EdgeResponseStatus", "OriginResponseStatus"}
+
+// statusLabelNames returns the response labels of the metric of the given
+// status field when the status is split: the label of that field, if any, is
+// named status, and those of the other status fields are left out.
+func (c *Collector) statusLabelNames(field string) []string {
+	var names []string
+	for _, l := range c.responseLabels {
+		switch {
+		case l.Field == field:
+			names = append(names, "status")
+		case !isStatusField(l.Field):
+			names = append(names, l.Label)
+		}
+	}
+	return names
+}
+
+// isStatusField reports whether the given field is a response status field.
+func isStatusField(field string) bool {
+	for _, f := range statusFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// splitResponses sums the given response counts, keyed by the values of the
+// response labels, by the label values of the metric of the given status
+// field when the status is split.
+func (c *Collector) splitResponses(responses map[string]responseTotals, field string) map[string]responseTotals {
+	split := make(map[string]responseTotals, len(responses))
+	for key, totals := range responses {
+		values := strings.Split(key, labelValueSeparator)
+		kept := make([]string, 0, len(values))
+		for i, l := range c.responseLabels {
+			if l.Field == field || !isStatusField(l.Field) {
+				kept = append(kept, values[i])
+			}
+		}
+
+		k := strings.Join(kept, labelValueSeparator)
+		sum := split[k]
+		sum.count += totals.count
+		sum.bytes += totals.bytes
+		split[k] = sum
+	}
+	return split
+}
+
+// buildDescs creates the descriptors of the per-zone metrics from the
+// configured label sets and collection mode.
+func (c *Collector) buildDescs() {
+	zoneLabelNames := c.zoneLabelNames()
+	withZone := func(names ...string) []string {
+		return append(append([]string{}, zoneLabelNames...), names...)
+	}
+
+	labelNames := withZone()
+	for _, l := range c.responseLabels {
+		labelNames = append(labelNames, l.Label)
+	}
+
+	edgeLabelNames := withZone(c.statusLabelNames("EdgeResponseStatus")...)
+	originLabelNames := withZone(c.statusLabelNames("OriginResponseStatus")...)
+	if c.splitStatus {
+		// The bytes are those returned by the edge.
+		labelNames = edgeLabelNames
+	}
+
+	cacheLabelNames := withZone("client_request_host", "cache_status")
+	firewallLabelNames := withZone("action", "source")
+	tieredLabelNames := withZone("upper_tier_status")
+	botLabelNames := withZone("bot_score_range", "bot_score_source")
+	endpointLabelNames := withZone()
+	if c.endpointMethods {
+		endpointLabelNames = append(endpointLabelNames, "method")
+	}
+	if c.paths != nil {
+		endpointLabelNames = append(endpointLabelNames, "path_group")
+	}
+	durationLabelNames := withZone("client_request_host")
+
+	// In incremental mode, the windows pulled vary in length, so the
+	// metrics which are not cumulative describe the latest window rather
+	// than logPeriod.
+	var constLabels prometheus.Labels
+	if !c.incremental {
+		constLabels = prometheus.Labels{
+			"period": prommodel.Duration(c.logPeriod).String(),
+		}
+	}
+
+	c.asnDesc = prometheus.NewDesc(
+		"cloudflare_logs_client_asn_requests",
+		"Cloudflare HTTP requests by client ASN, obtained via Logpull API",
+		withZone("client_asn"),
+		constLabels,
+	)
+	c.ja3Desc = prometheus.NewDesc(
+		"cloudflare_logs_ja3_fingerprints",
+		"Cloudflare HTTP requests by JA3 TLS fingerprint, obtained via Logpull API",
+		withZone("ja3_hash"),
+		constLabels,
+	)
+	c.countryDesc = prometheus.NewDesc(
+		"cloudflare_logs_client_country_requests",
+		"Cloudflare HTTP requests by client country, obtained via Logpull API",
+		withZone("client_country"),
+		constLabels,
+	)
+	c.coloDesc = prometheus.NewDesc(
+		"cloudflare_logs_edge_colo_requests",
+		"Cloudflare HTTP requests by edge data center, obtained via Logpull API",
+		withZone("edge_colo"),
+		constLabels,
+	)
+	c.anomalyDesc = prometheus.NewDesc(
+		"cloudflare_logs_anomaly_score",
+		"Number of standard deviations the latest rate lies from its moving average",
+		withZone("signal"),
+		constLabels,
+	)
+	c.emptyWindowDesc = prometheus.NewDesc(
+		"cloudflare_logs_window_empty_total",
+		"The number of windows pulled without any log entries",
+		zoneLabelNames,
+		nil,
+	)
+	c.schemaChangesDesc = prometheus.NewDesc(
+		"cloudflare_logpull_schema_changes_total",
+		"The number of times the fields available via Logpull API have changed",
+		zoneLabelNames,
+		nil,
+	)
+	c.missingFieldsDesc = prometheus.NewDesc(
+		"cloudflare_logpull_missing_fields",
+		"The number of requested fields which are no longer available via Logpull API",
+		zoneLabelNames,
+		nil,
+	)
+
+	if c.incremental {
+		c.responseDesc = prometheus.NewDesc(
+			"cloudflare_logs_http_responses_total",
+			"Cloudflare HTTP responses since the exporter started, obtained via Logpull API",
+			labelNames,
+			nil,
+		)
+		c.edgeResponseDesc = prometheus.NewDesc(
+			"cloudflare_logs_edge_responses_total",
+			"Cloudflare HTTP responses by edge response status since the exporter started, obtained via Logpull API",
+			edgeLabelNames,
+			nil,
+		)
+		c.originResponseDesc = prometheus.NewDesc(
+			"cloudflare_logs_origin_responses_total",
+			"Cloudflare HTTP responses by origin response status since the exporter started, obtained via Logpull API",
+			originLabelNames,
+			nil,
+		)
+		c.bytesDesc = prometheus.NewDesc(
+			"cloudflare_logs_http_response_bytes_total",
+			"Bytes returned to clients by Cloudflare since the exporter started, obtained via Logpull API",
+			labelNames,
+			nil,
+		)
+		c.cacheDesc = prometheus.NewDesc(
+			"cloudflare_logs_cache_status_total",
+			"Cloudflare HTTP requests by cache status since the exporter started, obtained via Logpull API",
+			cacheLabelNames,
+			nil,
+		)
+		c.firewallDesc = prometheus.NewDesc(
+			"cloudflare_logs_firewall_events_total",
+			"Cloudflare firewall rule matches by action and source since the exporter started, obtained via Logpull API",
+			firewallLabelNames,
+			nil,
+		)
+		c.tieredDesc = prometheus.NewDesc(
+			"cloudflare_logs_tiered_cache_fills_total",
+			"Cloudflare HTTP requests filled from an upper tier data center since the exporter started, obtained via Logpull API",
+			tieredLabelNames,
+			nil,
+		)
+		c.botDesc = prometheus.NewDesc(
+			"cloudflare_logs_bot_requests_total",
+			"Cloudflare HTTP requests by bot score range and source since the exporter started, obtained via Logpull API",
+			botLabelNames,
+			nil,
+		)
+		c.endpointDesc = prometheus.NewDesc(
+			"cloudflare_logs_endpoint_requests_total",
+			"Cloudflare HTTP requests by request method and path group since the exporter started, obtained via Logpull API",
+			endpointLabelNames,
+			nil,
+		)
+		c.durationDesc = prometheus.NewDesc(
+			"cloudflare_logs_origin_response_duration_seconds",
+			"Time taken by origins to respond to Cloudflare since the exporter started, obtained via Logpull API",
+			durationLabelNames,
+			nil,
+		)
+		return
+	}
+
+	c.responseDesc = prometheus.NewDesc(
+		"cloudflare_logs_http_responses",
+		"Cloudflare HTTP responses, obtained via Logpull API",
+		labelNames,
+		constLabels,
+	)
+	c.edgeResponseDesc = prometheus.NewDesc(
+		"cloudflare_logs_edge_responses",
+		"Cloudflare HTTP responses by edge response status, obtained via Logpull API",
+		edgeLabelNames,
+		constLabels,
+	)
+	c.originResponseDesc = prometheus.NewDesc(
+		"cloudflare_logs_origin_responses",
+		"Cloudflare HTTP responses by origin response status, obtained via Logpull API",
+		originLabelNames,
+		constLabels,
+	)
+	c.bytesDesc = prometheus.NewDesc(
+		"cloudflare_logs_http_response_bytes",
+		"Bytes returned to clients by Cloudflare, obtained via Logpull API",
+		labelNames,
+		constLabels,
+	)
+	c.cacheDesc = prometheus.NewDesc(
+		"cloudflare_logs_cache_status",
+		"Cloudflare HTTP requests by cache status, obtained via Logpull API",
+		cacheLabelNames,
+		constLabels,
+	)
+	c.firewallDesc = prometheus.NewDesc(
+		"cloudflare_logs_firewall_events",
+		"Cloudflare firewall rule matches by action and source, obtained via Logpull API",
+		firewallLabelNames,
+		constLabels,
+	)
+	c.tieredDesc = prometheus.NewDesc(
+		"cloudflare_logs_tiered_cache_fills",
+		"Cloudflare HTTP requests filled from an upper tier data center, obtained via Logpull API",
+		tieredLabelNames,
+		constLabels,
+	)
+	c.botDesc = prometheus.NewDesc(
+		"cloudflare_logs_bot_requests",
+		"Cloudflare HTTP requests by bot score range and source, obtained via Logpull API",
+		botLabelNames,
+		constLabels,
+	)
+	c.endpointDesc = prometheus.NewDesc(
+		"cloudflare_logs_endpoint_requests",
+		"Cloudflare HTTP requests by request method and path group, obtained via Logpull API",
+		endpointLabelNames,
+		constLabels,
+	)
+	c.durationDesc = prometheus.NewDesc(
+		"cloudflare_logs_origin_response_duration_seconds",
+		"Time taken by origins to respond to Cloudflare, obtained via Logpull API",
+		durationLabelNames,
+		constLabels,
+	)
+}
+
+// SetResponseLabels replaces the label set of the HTTP responses metric. Each
+// label takes its value from the given Logpull field, which must be supported
+// by LogEntry.
+func (c *Collector) SetResponseLabels(labels []LabelConfig) error {
+	if err := ValidateResponseLabels(labels); err != nil {
+		return err
+	}
+
+	c.responseLabels = labels
+	c.buildDescs()
+	return nil
+}
+
+// LabelConfig maps a Logpull field to a Prometheus label.
+type LabelConfig struct {
+	Field string `yaml:"field"`
+	Label string `yaml:"label"`
+}
+
+// DefaultResponseLabels are the labels of the cloudflare_logs_http_responses
+// metric, unless overridden by the configuration file.
+var DefaultResponseLabels = []LabelConfig{
+	{Field: "ClientRequestHost", Label: "client_request_host"},
+	{Field: "EdgeResponseStatus", Label: "edge_response_status"},
+	{Field: "OriginResponseStatus", Label: "origin_response_status"},
+}
+
+// ValidateResponseLabels checks that labels is a valid label set for the HTTP
+// responses metric.
+func ValidateResponseLabels(labels []LabelConfig) error {
+	if len(labels) == 0 {
+		return errors.New("invalid parameter: labels must not be empty")
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range labels {
+		if !isLogEntryField(l.Field) {
+			return fmt.Errorf("invalid parameter: unsupported field %q", l.Field)
+		}
+		if !prommodel.LabelName(l.Label).IsValid() {
+			return fmt.Errorf("invalid parameter: invalid label name %q", l.Label)
+		}
+		if l.Label == "period" || l.Label == "zone" || l.Label == "zone_id" || ZoneMetadataLabels[l.Label] != nil || seen[l.Label] {
+			return fmt.Errorf("invalid parameter: duplicate label name %q", l.Label)
+		}
+		seen[l.Label] = true
+	}
+
+	return nil
+}
+
+// SetResponseKeys sets label sets of the HTTP responses metric which are
+// reported for every window, with zero values if no response had them, so
+// that a lack of traffic can be told apart from a broken exporter. Each label
+// set gives a value to every response label, and applies to every zone
+// unless it names one in the zone label.
+func (c *Collector) SetResponseKeys(keys []map[string]string) error {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	if err := ValidateResponseKeys(keys, c.responseLabels); err != nil {
+		return err
+	}
+
+	c.responseKeys = keys
+	return nil
+}
+
+// ValidateResponseKeys checks that keys are valid label sets of the HTTP
+// responses metric with the given labels.
+func ValidateResponseKeys(keys []map[string]string, labels []LabelConfig) error {
+	isLabel := map[string]bool{"zone": true}
+	for _, l := range labels {
+		isLabel[l.Label] = true
+	}
+
+	for i, key := range keys {
+		for name := range key {
+			if !isLabel[name] {
+				return fmt.Errorf("invalid parameter: key %d has unknown label %q", i, name)
+			}
+		}
+		for _, l := range labels {
+			if _, ok := key[l.Label]; !ok {
+				return fmt.Errorf("invalid parameter: key %d has no value for label %q", i, l.Label)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addResponseKeys adds the response key label sets of the given zone to
+// counts, with zero values unless they were seen. Label sets which don't
+// match the response labels, while both are being reloaded, are left out.
+// The caller must hold c.configMu.
+func (c *Collector) addResponseKeys(zoneID string, counts windowCounts) {
+	zone := c.ZoneLabelValues(zoneID)[0]
+
+keys:
+	for _, key := range c.responseKeys {
+		if name, ok := key["zone"]; ok && name != zone {
+			continue
+		}
+
+		values := make([]string, len(c.responseLabels))
+		for i, l := range c.responseLabels {
+			value, ok := key[l.Label]
+			if !ok {
+				continue keys
+			}
+			values[i] = value
+		}
+
+		k := strings.Join(values, labelValueSeparator)
+		if _, ok := counts.responses[k]; !ok {
+			counts.responses[k] = responseTotals{}
+		}
+	}
+}
+
+// ReloadConfig is the configuration of the collector which is replaced on
+// reload.
+type ReloadConfig struct {
+	ZoneIDs   []string
+	ZoneNames map[string]string
+
+	// ZoneAPIs and GraphQLZones are the clients of the zones, as set by
+	// SetZoneAPIs and SetGraphQLZones.
+	ZoneAPIs     map[string]*LogpullAPI
+	GraphQLZones map[string]*cfgraphql.Client
+
+	ResponseLabels []LabelConfig
+	ResponseKeys   []map[string]string
+}
+
+// Reload replaces the zones, their names and clients, and the label set and
+// keys of the HTTP responses metric while the collector is in use, waiting
+// for any collection in progress to finish. The configuration is validated
+// before any of it is applied, so that a failed reload leaves the collector
+// unchanged. Error counters, and the cursors of zones which are kept, are
+// preserved. The cumulative response counts of incremental mode are reset if
+// the label set changes, since they can't be converted.
+func (c *Collector) Reload(cfg ReloadConfig) error {
+	zoneIDs, labels := cfg.ZoneIDs, cfg.ResponseLabels
+	if len(zoneIDs) == 0 {
+		return errors.New("invalid parameter: zoneIDs must not be empty")
+	}
+
+	if err := ValidateResponseLabels(labels); err != nil {
+		return err
+	}
+	if err := ValidateResponseKeys(cfg.ResponseKeys, labels); err != nil {
+		return err
+	}
+
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	kept := make(map[string]bool, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		kept[zoneID] = true
+	}
+	for _, zoneID := range c.zoneIDs {
+		if !kept[zoneID] {
+			for _, stage := range []string{stagePull, stageDecode} {
+				c.errorCounter.DeleteLabelValues(c.ZoneLabelValues(zoneID, stage)...)
+			}
+		}
+	}
+
+	labelsChanged := !reflect.DeepEqual(labels, c.responseLabels)
+
+	c.zoneIDs = zoneIDs
+	c.zoneNames = cfg.ZoneNames
+	c.zoneAPIs = cfg.ZoneAPIs
+	c.graphqlZones = cfg.GraphQLZones
+	c.responseLabels = labels
+	c.responseKeys = cfg.ResponseKeys
+
+	if c.incremental {
+		cursors := make(map[string]*zoneCursor, len(zoneIDs))
+		for _, zoneID := range zoneIDs {
+			cursor, ok := c.cursors[zoneID]
+			if !ok {
+				cursor = &zoneCursor{counts: newWindowCounts()}
+			} else if labelsChanged {
+				cursor.resetResponses()
+			}
+			cursors[zoneID] = cursor
+		}
+		c.cursors = cursors
+	}
+
+	for _, zoneID := range zoneIDs {
+		c.initErrorCounter(zoneID)
+	}
+	c.status.setZones(zoneIDs)
+	c.buildDescs()
+
+	return nil
+}
+
+// Zones returns the IDs of the zones currently collected.
+func (c *Collector) Zones() []string {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	return append([]string(nil), c.zoneIDs...)
+}
+
+// SetZoneNames sets the names of the zones, which are used as the value of the
+// zone label of per-zone metrics. Zones without a name are labeled with their
+// ID.
+func (c *Collector) SetZoneNames(names map[string]string) {
+	c.zoneNames = names
+	c.buildErrorCounter()
+	c.buildDescs()
+}
+
+// SetZoneIDLabel enables or disables the zone_id label of per-zone metrics,
+// which is disabled by default.
+func (c *Collector) SetZoneIDLabel(enabled bool) {
+	c.zoneIDLabel = enabled
+	c.buildErrorCounter()
+	c.buildDescs()
+}
+
+// SetZoneMetadataLabels adds the given zone metadata labels, such as
+// zone_account, to per-zone metrics. Their values are set by SetZoneMetadata.
+// No metadata labels are added by default.
+func (c *Collector) SetZoneMetadataLabels(labels []string) error {
+	seen := make(map[string]bool)
+	for _, label := range labels {
+		if ZoneMetadataLabels[label] == nil {
+			return fmt.Errorf("invalid parameter: unsupported zone metadata label %q", label)
+		}
+		if seen[label] {
+			return fmt.Errorf("invalid parameter: duplicate zone metadata label %q", label)
+		}
+		seen[label] = true
+	}
+
+	c.metadataLabels = labels
+	c.buildErrorCounter()
+	c.buildDescs()
+	return nil
+}
+
+// SetZoneMetadata sets the values of the zone metadata labels, keyed by zone
+// ID and label name, while the collector is in use. The error counters of
+// zones whose metadata changed restart from zero under the new label values.
+func (c *Collector) SetZoneMetadata(metadata map[string]map[string]string) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	for _, zoneID := range c.zoneIDs {
+		if reflect.DeepEqual(metadata[zoneID], c.zoneMetadata[zoneID]) {
+			continue
+		}
+		for _, stage := range []string{stagePull, stageDecode} {
+			c.errorCounter.DeleteLabelValues(c.ZoneLabelValues(zoneID, stage)...)
+		}
+	}
+
+	old := c.zoneMetadata
+	c.zoneMetadata = metadata
+
+	for _, zoneID := range c.zoneIDs {
+		if !reflect.DeepEqual(metadata[zoneID], old[zoneID]) {
+			c.initErrorCounter(zoneID)
+		}
+	}
+}
+
+// SetIncremental enables or disables incremental collection. In incremental
+// mode, each zone's logs are only pulled from where the previous successful
+// pull ended, and the HTTP responses metric is reported as a cumulative
+// counter rather than a gauge over the last logPeriod. The first pull of each
+// zone covers logPeriod.
+func (c *Collector) SetIncremental(incremental bool) {
+	c.incremental = incremental
+	c.cursors = nil
+	if incremental {
+		c.cursors = make(map[string]*zoneCursor, len(c.zoneIDs))
+		for _, zoneID := range c.zoneIDs {
+			c.cursors[zoneID] = &zoneCursor{counts: newWindowCounts()}
+		}
+	}
+	c.buildDescs()
+}
+
+// SetZoneHandler sets a function which is called with the outcome of every
+// attempt to collect a zone; err is nil if the attempt succeeded. It may be
+// called concurrently for different zones. It is called while the
+// configuration is read-locked, so that it may use ZoneLabelValues.
+func (c *Collector) SetZoneHandler(handler func(zoneID string, err error)) {
+	c.zoneHandler = handler
+}
+
+// ZoneLag returns the time elapsed since the end of the latest window
+// collected for the given zone, and whether one was collected at all.
+func (c *Collector) ZoneLag(zoneID string) (time.Duration, bool) {
+	return c.status.lag(zoneID)
+}
+
+// SetCollectHandler sets a function which is called at the end of every call
+// to Collect; ok is true if every zone was collected successfully.
+func (c *Collector) SetCollectHandler(handler func(ok bool)) {
+	c.collectHandler = handler
+}
+
+// SetEventLog sets the event log to which the collector records its pulls.
+func (c *Collector) SetEventLog(events *EventLog) {
+	c.events = events
+}
+
+// ObserveRequest records an API request in the request metrics. It is meant
+// to be set as the request hook of the collector's API client.
+func (c *Collector) ObserveRequest(status string, duration time.Duration, size int64) {
+	c.requests.WithLabelValues(status).Inc()
+	c.requestBytes.Add(float64(size))
+	c.requestDuration.Observe(duration.Seconds())
+}
+
+// ObserveOversize counts and logs a log download larger than the size budget.
+// It is meant to be set as the oversize hook of the collector's API client.
+func (c *Collector) ObserveOversize(zoneID string, start, end time.Time, size int64, action string) {
+	c.oversized.WithLabelValues(action).Inc()
+
+	if c.logger != nil {
+		zone := c.zoneNames[zoneID]
+		if zone == "" {
+			zone = zoneID
+		}
+		c.logger.Warn("Log download exceeds size budget", "zone", zone, "zone_id", zoneID, "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "bytes", size, "action", action)
+	}
+}
+
+// SetOutboundMetrics makes the collector expose the given metrics of the
+// exporter's outbound requests. It must be called before the collector is
+// registered.
+func (c *Collector) SetOutboundMetrics(m *OutboundMetrics) {
+	c.outbound = m
+}
+
+// Logger is the logger to which the collector writes its log lines, given as
+// a message followed by alternating keys and values.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+}
+
+// SetLogger sets the logger to which the collector writes a debug line for
+// every event, whether or not an event log is set.
+func (c *Collector) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+// recordEvent records the given event if an event log is set, and logs it if
+// a logger is set.
+func (c *Collector) recordEvent(e event) {
+	if c.events != nil {
+		c.events.record(e)
+	}
+
+	if c.logger != nil {
+		keyvals := []interface{}{"zone", c.zoneNames[e.ZoneID], "zone_id", e.ZoneID}
+		if c.zoneNames[e.ZoneID] == "" {
+			keyvals[1] = e.ZoneID
+		}
+		if e.Start != "" {
+			keyvals = append(keyvals, "start", e.Start, "end", e.End)
+		}
+		if e.Type == eventPullSucceeded || e.Type == eventPullFailed {
+			keyvals = append(keyvals, "duration", time.Duration(e.Duration*float64(time.Second)))
+		}
+		if e.Type == eventPullSucceeded {
+			keyvals = append(keyvals, "entries", e.Entries, "response_bytes", e.ResponseBytes)
+		}
+		if e.Error != "" {
+			keyvals = append(keyvals, "error", e.Error)
+		}
+		if len(e.Fields) > 0 {
+			keyvals = append(keyvals, "fields", strings.Join(e.Fields, ","))
+		}
+		c.logger.Debug(e.Type, keyvals...)
+	}
+}
+
+// Register registers the collector with the given registerer, returning an
+// error rather than panicking if its metrics conflict with those already
+// registered, so that the collector can be embedded in larger programs. If
+// namespace is non-empty, it is prepended to the names of all metrics, so that
+// several collectors can share a registry.
+func (c *Collector) Register(reg prometheus.Registerer, namespace string) error {
+	if namespace != "" {
+		if !prommodel.IsValidMetricName(prommodel.LabelValue(namespace)) {
+			return fmt.Errorf("invalid parameter: invalid namespace %q", namespace)
+		}
+		reg = prometheus.WrapRegistererWithPrefix(namespace+"_", reg)
+	}
+
+	if err := reg.Register(c); err != nil {
+		var registeredErr prometheus.AlreadyRegisteredError
+		if errors.As(err, &registeredErr) {
+			return fmt.Errorf("metrics already registered: %w", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// maxScrapeTimeoutMargin is the maximum time by which collections end before
+// the scrape timeout announced by Prometheus, to leave time for the response
+// to be sent.
+const maxScrapeTimeoutMargin = time.Second
+
+// ScrapeHandler wraps the given metrics handler, so that collections end
+// before the timeout which Prometheus announces in the
+// X-Prometheus-Scrape-Timeout-Seconds header of the scrape: slightly earlier,
+// by a tenth of the timeout up to maxScrapeTimeoutMargin. Pulls still in
+// progress then are aborted, and the metrics collected so far are returned.
+// As Collect has no access to the scrape, the deadline applies to any
+// collection of Collect during the scrape; if several scrapes are served at
+// the same time, the latest deadline applies. Background refreshes are not
+// affected.
+func (c *Collector) ScrapeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+		if err != nil || !(seconds > 0) || seconds > math.MaxInt64/float64(time.Second) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout := time.Duration(seconds * float64(time.Second))
+		margin := timeout / 10
+		if margin > maxScrapeTimeoutMargin {
+			margin = maxScrapeTimeoutMargin
+		}
+
+		deadline := time.Now().Add(timeout - margin).UnixNano()
+		atomic.StoreInt64(&c.scrapeDeadline, deadline)
+		defer atomic.CompareAndSwapInt64(&c.scrapeDeadline, deadline, 0)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StatusHandler returns an HTTP handler serving the latest aggregates of every
+// zone as JSON.
+func (c *Collector) StatusHandler() http.Handler {
+	return c.status
+}
+
+// DebugVarsHandler returns an HTTP handler serving the collector's internal
+// state in the format of expvar, as the collector variable. The other
+// variables of expvar are left out, since cmdline reveals the command-line
+// flags, which may include secrets.
+func (c *Collector) DebugVarsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(struct {
+			Collector interface{} `json:"collector"`
+		}{c.debugVars()}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// debugVars returns a snapshot of the collector's internal state, for
+// DebugVarsHandler.
+func (c *Collector) debugVars() interface{} {
+	type zoneVars struct {
+		Cursor *time.Time `json:"cursor,omitempty"`
+		Series int        `json:"cumulative_series,omitempty"`
+	}
+
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	zones := make(map[string]zoneVars, len(c.zoneIDs))
+	for _, zoneID := range c.zoneIDs {
+		var vars zoneVars
+		if cursor, ok := c.cursors[zoneID]; ok {
+			end, counts := cursor.snapshot()
+			if !end.IsZero() {
+				vars.Cursor = &end
+			}
+			vars.Series = counts.series()
+		}
+		zones[zoneID] = vars
+	}
+
+	var anomalyDetectors int
+	if c.anomalies != nil {
+		anomalyDetectors = c.anomalies.size()
+	}
+
+	return struct {
+		InFlightPulls    int64               `json:"in_flight_pulls"`
+		Retries          uint64              `json:"retries"`
+		Incremental      bool                `json:"incremental"`
+		Fields           []string            `json:"fields"`
+		AnomalyDetectors int                 `json:"anomaly_detectors"`
+		Zones            map[string]zoneVars `json:"zones"`
+	}{
+		InFlightPulls:    atomic.LoadInt64(&c.inFlight),
+		Retries:          c.apiCount((*LogpullAPI).retryCount),
+		Incremental:      c.incremental,
+		Fields:           c.fields(),
+		AnomalyDetectors: anomalyDetectors,
+		Zones:            zones,
+	}
+}
+
+// SetTimeout limits how long a single call to Collect may spend pulling logs.
+// Pulls still in progress when the timeout expires are aborted and counted as
+// errors. A value of zero disables the timeout, which is the default.
+func (c *Collector) SetTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return errors.New("invalid parameter: timeout must not be negative")
+	}
+
+	c.timeout = timeout
+	return nil
+}
+
+// SetConcurrency limits the number of zones collected at the same time, and
+// thus the number of simultaneous Logpull API downloads. A value of zero
+// collects all zones at once, which is the default.
+func (c *Collector) SetConcurrency(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	c.concurrency = n
+	return nil
+}
+
+// SetGraphQLZones makes the collector collect the given zones, such as those
+// without log retention, through the GraphQL Analytics API with the given
+// clients, keyed by zone ID, instead of Logpull. Only the HTTP response and
+// firewall event metrics are reported for them.
+func (c *Collector) SetGraphQLZones(clients map[string]*cfgraphql.Client) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	c.graphqlZones = clients
+}
+
+// SetZoneAPIs makes the collector pull the logs of the given zones with the
+// given Logpull API clients, keyed by zone ID, such as those of other
+// accounts' credentials, while the collector is in use. Other zones are
+// pulled with the collector's client.
+func (c *Collector) SetZoneAPIs(apis map[string]*LogpullAPI) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
+	c.zoneAPIs = apis
+}
+
+// zoneAPI returns the Logpull API client of the given zone. The caller must
+// hold c.configMu.
+func (c *Collector) zoneAPI(zoneID string) *LogpullAPI {
+	if api, ok := c.zoneAPIs[zoneID]; ok {
+		return api
+	}
+	return c.api
+}
+
+// apiCount returns the sum of the given counter over the collector's Logpull
+// API clients. The caller must hold c.configMu.
+func (c *Collector) apiCount(count func(api *LogpullAPI) uint64) uint64 {
+	seen := map[*LogpullAPI]bool{c.api: true}
+	total := count(c.api)
+	for _, api := range c.zoneAPIs {
+		if !seen[api] {
+			seen[api] = true
+			total += count(api)
+		}
+	}
+	return total
+}
+
+// SetStatusErrors sets the number of recent errors of every zone served by the
+// status API, which is 10 by default. A value of zero disables them.
+func (c *Collector) SetStatusErrors(n int) error {
+	return c.status.setMaxErrors(n)
+}
+
+// SetHostLimiter bounds the client_request_host label values of every zone
+// with the given HostLimiter, folding the remaining hosts into an "other"
+// series. A nil limiter reports all hosts, which is the default.
+func (c *Collector) SetHostLimiter(l *HostLimiter) {
+	c.hosts = l
+}
+
+// SetResponseBytes enables or disables the HTTP response bytes metric, which
+// sums the bytes returned to clients with the responses, labeled like the
+// HTTP responses metric. It is disabled by default, since it needs the
+// EdgeResponseBytes field and doubles the number of response series.
+func (c *Collector) SetResponseBytes(enabled bool) {
+	c.responseBytes = enabled
+}
+
+// SetCacheStatus enables or disables cache status metrics, which count the
+// requests in each zone by host and cache status. They are disabled by
+// default, since they need the CacheCacheStatus field and a series for
+// every host and status.
+func (c *Collector) SetCacheStatus(enabled bool) {
+	c.cacheStatus = enabled
+}
+
+// SetFirewallEvents enables or disables firewall event metrics, which count
+// the firewall rules matched by requests in each zone by action and source.
+// They are disabled by default.
+func (c *Collector) SetFirewallEvents(enabled bool) {
+	c.firewallEvents = enabled
+}
+
+// SetTieredCache enables or disables tiered cache metrics, which count the
+// requests filled from an upper tier data center by whether the upper tier
+// had the content cached. They are disabled by default.
+func (c *Collector) SetTieredCache(enabled bool) {
+	c.tieredCache = enabled
+}
+
+// SetBotScores enables or disables bot score metrics, which count the requests
+// in each zone by bot score range and the source of the score. They are
+// disabled by default.
+func (c *Collector) SetBotScores(enabled bool) {
+	c.botScores = enabled
+}
+
+// SetSplitStatus makes the collector report the HTTP responses by edge and by
+// origin response status as separate metrics, each labeled by its status
+// only, instead of one labeled by both. Since the statuses are not combined,
+// this halves the number of series or more. The response bytes are labeled
+// like the edge responses. It is disabled by default.
+func (c *Collector) SetSplitStatus(enabled bool) {
+	c.splitStatus = enabled
+	c.buildDescs()
+}
+
+// SetEndpoints enables endpoint metrics, which count the requests in each zone
+// by request method, if methods is set, and by the group of the request path,
+// if paths is not nil. They are disabled by default.
+func (c *Collector) SetEndpoints(methods bool, paths *PathGrouper) {
+	c.endpointMethods = methods
+	c.paths = paths
+	c.buildDescs()
+}
+
+// SetOriginDuration enables or disables the origin response duration
+// histogram, which observes the time taken by origins to respond to the
+// requests of each zone by host. It is disabled by default, since it needs
+// the OriginResponseTime field and a series for every host and bucket.
+func (c *Collector) SetOriginDuration(enabled bool) {
+	c.originDuration = enabled
+}
+
+// SetOriginDurationBuckets sets the upper bounds, in seconds, of the buckets
+// of the origin response duration histogram. They must be positive and in
+// increasing order. The default is prometheus.DefBuckets.
+func (c *Collector) SetOriginDurationBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return errors.New("invalid parameter: buckets must not be empty")
+	}
+
+	for i, bound := range buckets {
+		if bound <= 0 {
+			return errors.New("invalid parameter: buckets must be positive")
+		}
+		if i > 0 && bound <= buckets[i-1] {
+			return errors.New("invalid parameter: buckets must be in increasing order")
+		}
+	}
+
+	c.durationBuckets = buckets
+	return nil
+}
+
+// SetJA3TopN enables JA3 fingerprint metrics, reporting the n most frequent
+// fingerprints of every zone and folding the rest into an "other" series.
+// A value of zero disables them, which is the default. JA3 fingerprints are
+// only available for zones with Bot Management enabled.
+func (c *Collector) SetJA3TopN(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	c.ja3TopN = n
+	return nil
+}
+
+// SetASNTopN enables per-ASN metrics, reporting the n client ASNs with the
+// most requests for each zone and folding the rest into an "other" series. A
+// value of zero disables them, which is the default.
+func (c *Collector) SetASNTopN(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	c.asnTopN = n
+	return nil
+}
+
+// SetCountryTopN enables per-country metrics, reporting the n client
+// countries with the most requests for each zone and folding the rest into an
+// "other" series. A value of zero disables them, which is the default.
+func (c *Collector) SetCountryTopN(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	c.countryTopN = n
+	return nil
+}
+
+// SetColoTopN enables per-colo metrics, reporting the n edge data centers
+// which served the most requests for each zone and folding the rest into an
+// "other" series. A value of zero disables them, which is the default.
+func (c *Collector) SetColoTopN(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	c.coloTopN = n
+	return nil
+}
+
+// SetAnomalyAlpha enables anomaly scores for the per-zone request and error
+// rates, using alpha as the smoothing factor of their moving averages. Smaller
+// values make the averages adapt more slowly. A value of zero disables anomaly
+// scores, which is the default.
+func (c *Collector) SetAnomalyAlpha(alpha float64) error {
+	if alpha < 0 || alpha > 1 {
+		return errors.New("invalid parameter: alpha must be between 0 and 1")
+	}
+
+	if alpha == 0 {
+		c.anomalies = nil
+	} else {
+		c.anomalies = newAnomalyDetectors(alpha)
+	}
+	return nil
+}
+
+// SetSchemaCheck enables or disables periodic checks of the fields available
+// in each zone's logs, which detect fields being added, removed or renamed by
+// Cloudflare. They are disabled by default.
+func (c *Collector) SetSchemaCheck(enabled bool) {
+	c.schema = nil
+	if enabled {
+		c.schema = newSchemaTracker()
+	}
+}
+
+// fields returns the Logpull fields needed by the enabled metrics.
+func (c *Collector) fields() []string {
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(names ...string) {
+		for _, name := range names {
+			if !seen[name] {
+				fields = append(fields, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	for _, a := range c.aggregations() {
+		for _, field := range a.Fields() {
+			add(logpullField(field))
+		}
+	}
+	if c.anomalies != nil {
+		add("EdgeResponseStatus")
+	}
+	return fields
+}
+
+// Describe is a required method of the prometheus.Collector interface. It is
+// used to validate that there are no metric collisions when the collector is
+// registered.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	for _, a := range c.aggregations() {
+		a.Describe(ch)
+	}
+	ch <- c.anomalyDesc
+	ch <- c.emptyWindowDesc
+	ch <- c.schemaChangesDesc
+	ch <- c.missingFieldsDesc
+	ch <- c.retryDesc
+	ch <- c.rateLimitDesc
+	ch <- c.stallDesc
+	ch <- c.resumeDesc
+	ch <- c.fipsDesc
+	ch <- c.refreshDesc
+	c.errorCounter.Describe(ch)
+	c.requests.Describe(ch)
+	c.requestBytes.Describe(ch)
+	c.requestDuration.Describe(ch)
+	c.oversized.Describe(ch)
+	c.collectTimeouts.Describe(ch)
+	if c.outbound != nil {
+		c.outbound.Describe(ch)
+	}
+}
+
+// Collect is a required method of the prometheus.Collector interface. It is
+// called by the Prometheus registry whenever a new set of metrics are to be
+// collected. If a refresh interval is set, it serves the metrics of the latest
+// background refresh; otherwise, it pulls logs from the API.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.refreshInterval == 0 {
+		ctx, cancel := c.scrapeContext()
+		defer cancel()
+
+		c.collect(ctx, ch)
+		return
+	}
+
+	c.snapshotMu.Lock()
+	metrics, refreshed := c.snapshot, c.refreshed
+	c.snapshotMu.Unlock()
+
+	for _, m := range metrics {
+		ch <- m
+	}
+
+	var timestamp float64
+	if !refreshed.IsZero() {
+		timestamp = float64(refreshed.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(c.refreshDesc, prometheus.GaugeValue, timestamp)
+}
+
+// SetRefreshInterval enables background collection, so that scrapes don't
+// wait for the Logpull API. Logs are then pulled every interval by Run, and
+// Collect serves the metrics of the latest refresh. A value of zero disables
+// background collection, which is the default.
+func (c *Collector) SetRefreshInterval(interval time.Duration) error {
+	if interval < 0 {
+		return errors.New("invalid parameter: interval must not be negative")
+	}
+
+	c.refreshInterval = interval
+	return nil
+}
+
+// scrapeContext returns the context of a collection during a scrape, which
+// ends after the timeout, or at the deadline of the scrape being served,
+// whichever is earlier.
+func (c *Collector) scrapeContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := c.timeoutContext(context.Background())
+	if deadline := atomic.LoadInt64(&c.scrapeDeadline); deadline != 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, time.Unix(0, deadline))
+		return ctx, func() {
+			cancelDeadline()
+			cancel()
+		}
+	}
+	return ctx, cancel
+}
+
+// timeoutContext returns a context derived from parent which ends after the
+// timeout, if one is set.
+func (c *Collector) timeoutContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(parent, c.timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// Run refreshes the metrics every refresh interval until ctx is done, starting
+// immediately. It returns at once if background collection is disabled.
+func (c *Collector) Run(ctx context.Context) {
+	if c.refreshInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		c.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh pulls logs from the API and stores the resulting metrics. The pulls
+// end when ctx is done or after the timeout; scrapes served in the meantime
+// don't cut them short.
+func (c *Collector) refresh(ctx context.Context) {
+	ctx, cancel := c.timeoutContext(ctx)
+	defer cancel()
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+
+	c.collect(ctx, ch)
+	close(ch)
+	<-done
+
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	c.snapshot = metrics
+	c.refreshed = time.Now()
+}
+
+// collect pulls logs from the API until ctx is done, and sends the resulting
+// metrics to ch.
+func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	// The Cloudflare API docs specify that 'end' must be at least one
+	// minute earlier than now.
+	// https://developers.cloudflare.com/logs/logpull-api/requesting-logs#parameters,
+	end := time.Now().Add(-1 * time.Minute)
+
+	fields := c.fields()
+
+	var mu sync.Mutex
+	failed := false
+
+	collectZone := func(zoneID string) {
+		atomic.AddInt64(&c.inFlight, 1)
+		schemaErr := c.checkSchema(ctx, ch, zoneID, fields)
+		err := c.collectZone(ctx, ch, zoneID, fields, end)
+		atomic.AddInt64(&c.inFlight, -1)
+
+		if c.zoneHandler != nil {
+			c.zoneHandler(zoneID, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if schemaErr != nil {
+			c.errorHandler(schemaErr)
+		}
+
+		if err != nil {
+			failed = true
+			c.errorCounter.WithLabelValues(c.ZoneLabelValues(zoneID, errorStage(err))...).Inc()
+			c.errorHandler(err)
+		}
+	}
+
+	// Zones are collected by a bounded number of workers, so that many
+	// zones don't open as many simultaneous downloads.
+	workers := c.concurrency
+	if workers == 0 || workers > len(c.zoneIDs) {
+		workers = len(c.zoneIDs)
+	}
+
+	zoneIDs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for zoneID := range zoneIDs {
+				collectZone(zoneID)
+			}
+		}()
+	}
+	for _, zoneID := range c.zoneIDs {
+		zoneIDs <- zoneID
+	}
+	close(zoneIDs)
+	wg.Wait()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.collectTimeouts.Inc()
+	}
+
+	c.errorCounter.Collect(ch)
+	emptyWindows := c.status.emptyWindows()
+	for _, zoneID := range c.zoneIDs {
+		ch <- prometheus.MustNewConstMetric(c.emptyWindowDesc, prometheus.CounterValue, emptyWindows[zoneID], c.ZoneLabelValues(zoneID)...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.retryDesc, prometheus.CounterValue, float64(c.apiCount((*LogpullAPI).retryCount)))
+	ch <- prometheus.MustNewConstMetric(c.rateLimitDesc, prometheus.CounterValue, float64(c.apiCount((*LogpullAPI).rateLimitedCount)))
+	ch <- prometheus.MustNewConstMetric(c.stallDesc, prometheus.CounterValue, float64(c.apiCount((*LogpullAPI).stallCount)))
+	ch <- prometheus.MustNewConstMetric(c.resumeDesc, prometheus.CounterValue, float64(c.apiCount((*LogpullAPI).resumeCount)))
+
+	fips := 0.0
+	if FIPSEnabled() {
+		fips = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.fipsDesc, prometheus.GaugeValue, fips)
+	c.requests.Collect(ch)
+	c.requestBytes.Collect(ch)
+	c.requestDuration.Collect(ch)
+	c.oversized.Collect(ch)
+	c.collectTimeouts.Collect(ch)
+	if c.outbound != nil {
+		c.outbound.Collect(ch)
+	}
+
+	if c.collectHandler != nil {
+		c.collectHandler(!failed)
+	}
+}
+
+// UnknownFieldsError is returned by CheckFields when requested fields are
+// not available in Logpull. Fields lists them.
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "fields not available in Logpull: " + strings.Join(e.Fields, ", ")
+}
+
+// CheckFields verifies that every field requested by the collector is
+// available in the logs of its first zone collected through Logpull, as the
+// fields are the same for all zones. It returns an UnknownFieldsError listing
+// the fields which aren't, and other errors if the fields could not be listed,
+// after retrying according to the retry policy of the zone's API client.
+func (c *Collector) CheckFields(ctx context.Context) error {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	var zoneID string
+	for _, id := range c.zoneIDs {
+		if c.graphqlZones[id] == nil {
+			zoneID = id
+			break
+		}
+	}
+	if zoneID == "" {
+		return nil
+	}
+
+	available, err := c.zoneAPI(zoneID).pullFieldsContext(ctx, zoneID)
+	if err != nil {
+		return fmt.Errorf("listing fields of zone %s: %w", zoneID, err)
+	}
+
+	isAvailable := make(map[string]bool, len(available))
+	for _, name := range available {
+		isAvailable[name] = true
+	}
+
+	var unknown []string
+	for _, name := range c.fields() {
+		if !isAvailable[name] {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return &UnknownFieldsError{Fields: unknown}
+	}
+
+	return nil
+}
+
+// checkSchema compares the fields available in the given zone's logs against
+// those seen previously, if the schema check is enabled and due, and sends the
+// resulting metrics to ch. Failing to check the fields does not prevent the
+// zone from being collected.
+func (c *Collector) checkSchema(ctx context.Context, ch chan<- prometheus.Metric, zoneID string, fields []string) error {
+	if c.schema == nil || c.graphqlZones[zoneID] != nil {
+		return nil
+	}
+
+	var err error
+	if c.schema.due(zoneID) {
+		var available []string
+		available, err = c.zoneAPI(zoneID).pullFieldsContext(ctx, zoneID)
+		if err != nil {
+			err = fmt.Errorf("checking fields of zone %s: %w", zoneID, err)
+		} else if added, removed := c.schema.update(zoneID, available, fields); len(added) > 0 || len(removed) > 0 {
+			e := event{Type: eventSchemaChanged, ZoneID: zoneID}
+			for _, name := range added {
+				e.Fields = append(e.Fields, "+"+name)
+			}
+			for _, name := range removed {
+				e.Fields = append(e.Fields, "-"+name)
+			}
+			c.recordEvent(e)
+		}
+	}
+
+	changes, missing := c.schema.snapshot(zoneID)
+	ch <- prometheus.MustNewConstMetric(c.schemaChangesDesc, prometheus.CounterValue, changes, c.ZoneLabelValues(zoneID)...)
+	ch <- prometheus.MustNewConstMetric(c.missingFieldsDesc, prometheus.GaugeValue, missing, c.ZoneLabelValues(zoneID)...)
+
+	return err
+}
+
+// collectZone pulls the logs of a single zone up to the given end time and
+// sends the resulting per-zone metrics to ch.
+func (c *Collector) collectZone(ctx context.Context, ch chan<- prometheus.Metric, zoneID string, fields []string, end time.Time) error {
+	start := end.Add(-1 * c.logPeriod)
+
+	var cursor *zoneCursor
+	if c.incremental {
+		cursor = c.cursors[zoneID]
+		cursor.pullMu.Lock()
+		defer cursor.pullMu.Unlock()
+
+		var skipped time.Time
+		start, end, skipped = cursor.window(end, c.logPeriod)
+		if !skipped.IsZero() {
+			c.recordEvent(newWindowEvent(eventWindowSkipped, zoneID, skipped, start))
+		}
+		defer func() {
+			_, counts := cursor.snapshot()
+			c.collectCounts(ch, prometheus.CounterValue, zoneID, counts)
+		}()
+
+		if !start.Before(end) {
+			return nil
+		}
+	}
+
+	if client := c.graphqlZones[zoneID]; client != nil {
+		return c.collectZoneGraphQL(ctx, ch, client, zoneID, start, end)
+	}
+
+	window := aggregator.Window{ZoneID: zoneID, Zone: c.ZoneLabelValues(zoneID)[0], Start: start, End: end}
+	var aggregators []aggregator.Aggregator
+	for _, a := range c.aggregations() {
+		aggregators = append(aggregators, a.Window(window))
+	}
+	var entries int
+	var requests, serverErrors, responseBytes float64
+
+	pullStart := time.Now()
+	err := c.zoneAPI(zoneID).pullLogEntriesContext(ctx, zoneID, start, end, fields, func(entry LogEntry) error {
+		// When logs are sampled, every entry stands for 1/rate requests.
+		weight := entry.weight()
+
+		if c.hosts != nil {
+			entry.ClientRequestHost = c.hosts.label(zoneID, entry.ClientRequestHost)
+		}
+
+		for _, a := range aggregators {
+			a.Observe(&entry, weight)
+		}
+		entries++
+		requests += weight
+		responseBytes += float64(entry.EdgeResponseBytes) * weight
+		if entry.EdgeResponseStatus >= 500 {
+			serverErrors += weight
+		}
+		return nil
+	})
+
+	if err != nil {
+		e := newWindowEvent(eventPullFailed, zoneID, start, end)
+		e.Duration = time.Since(pullStart).Seconds()
+		e.Error = err.Error()
+		c.recordEvent(e)
+		c.status.recordFailure(zoneID, err)
+
+		// Partial windows are discarded, since they would look like a
+		// sudden drop in traffic, and would be counted twice once the
+		// window is pulled again in incremental mode.
+		return err
+	}
+
+	e := newWindowEvent(eventPullSucceeded, zoneID, start, end)
+	e.Entries = entries
+	e.Duration = time.Since(pullStart).Seconds()
+	e.ResponseBytes = int64(responseBytes)
+	c.recordEvent(e)
+	c.status.recordSuccess(zoneID, start, end, requests, serverErrors)
+
+	for _, a := range aggregators {
+		a.Emit(ch)
+	}
+
+	if c.anomalies != nil {
+		seconds := end.Sub(start).Seconds()
+		for signal, rate := range map[string]float64{
+			"requests": requests / seconds,
+			"errors":   serverErrors / seconds,
+		} {
+			score := c.anomalies.observe(zoneID, signal, rate)
+			ch <- prometheus.MustNewConstMetric(c.anomalyDesc, prometheus.GaugeValue, score, c.ZoneLabelValues(zoneID, signal)...)
+		}
+	}
+
+	return nil
+}
+
+// collectZoneGraphQL collects the given window of a zone through the GraphQL
+// Analytics API with the given client, reporting the HTTP response metrics,
+// and the firewall event metrics if enabled, only, since the other metrics
+// need fields which the API does not provide.
+func (c *Collector) collectZoneGraphQL(ctx context.Context, ch chan<- prometheus.Metric, client *cfgraphql.Client, zoneID string, start, end time.Time) error {
+	fields := []string{"EdgeResponseStatus"}
+	for _, l := range c.responseLabels {
+		fields = append(fields, logpullField(l.Field))
+	}
+
+	pullStart := time.Now()
+	groups, err := pullRequestGroupsContext(ctx, client, zoneID, start, end, fields)
+	var firewallEvents map[string]float64
+	if err == nil && c.firewallEvents {
+		firewallEvents, err = pullFirewallEventsContext(ctx, client, zoneID, start, end)
+	}
+	if err != nil {
+		e := newWindowEvent(eventPullFailed, zoneID, start, end)
+		e.Duration = time.Since(pullStart).Seconds()
+		e.Error = err.Error()
+		c.recordEvent(e)
+		c.status.recordFailure(zoneID, err)
+		return err
+	}
+
+	a := responseAggregation{c}.Window(aggregator.Window{ZoneID: zoneID, Zone: c.ZoneLabelValues(zoneID)[0], Start: start, End: end}).(*responseAggregator)
+	var requests, serverErrors, responseBytes float64
+	for _, g := range groups {
+		if c.hosts != nil {
+			if host, ok := g.Fields["ClientRequestHost"]; ok {
+				g.Fields["ClientRequestHost"] = c.hosts.label(zoneID, host)
+			}
+		}
+
+		values := make([]string, len(c.responseLabels))
+		for i, l := range c.responseLabels {
+			values[i] = g.Fields[l.Field]
+		}
+		key := strings.Join(values, labelValueSeparator)
+		totals := a.counts.responses[key]
+		totals.count += g.Count
+		totals.bytes += g.ResponseBytes
+		a.counts.responses[key] = totals
+
+		requests += g.Count
+		responseBytes += g.ResponseBytes
+		if status, _ := strconv.Atoi(g.Fields["EdgeResponseStatus"]); status >= 500 {
+			serverErrors += g.Count
+		}
+	}
+	for key, count := range firewallEvents {
+		a.counts.firewallEvents[key] += count
+	}
+
+	e := newWindowEvent(eventPullSucceeded, zoneID, start, end)
+	e.Duration = time.Since(pullStart).Seconds()
+	if c.responseBytes {
+		e.ResponseBytes = int64(responseBytes)
+	}
+	c.recordEvent(e)
+	c.status.recordSuccess(zoneID, start, end, requests, serverErrors)
+
+	a.Emit(ch)
+	return nil
+}
+
+// collectCounts sends the metrics aggregated from the given counts of a zone
+// to ch.
+func (c *Collector) collectCounts(ch chan<- prometheus.Metric, valueType prometheus.ValueType, zoneID string, counts windowCounts) {
+	if c.splitStatus {
+		for key, totals := range c.splitResponses(counts.responses, "EdgeResponseStatus") {
+			labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+			ch <- prometheus.MustNewConstMetric(c.edgeResponseDesc, valueType, totals.count, labelValues...)
+			if c.responseBytes {
+				ch <- prometheus.MustNewConstMetric(c.bytesDesc, valueType, totals.bytes, labelValues...)
+			}
+		}
+		for key, totals := range c.splitResponses(counts.responses, "OriginResponseStatus") {
+			labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+			ch <- prometheus.MustNewConstMetric(c.originResponseDesc, valueType, totals.count, labelValues...)
+		}
+	} else {
+		for key, totals := range counts.responses {
+			labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+			ch <- prometheus.MustNewConstMetric(c.responseDesc, valueType, totals.count, labelValues...)
+			if c.responseBytes {
+				ch <- prometheus.MustNewConstMetric(c.bytesDesc, valueType, totals.bytes, labelValues...)
+			}
+		}
+	}
+
+	for key, count := range counts.cacheStatuses {
+		labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+		ch <- prometheus.MustNewConstMetric(c.cacheDesc, valueType, count, labelValues...)
+	}
+
+	for key, count := range counts.firewallEvents {
+		labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+		ch <- prometheus.MustNewConstMetric(c.firewallDesc, valueType, count, labelValues...)
+	}
+
+	for status, count := range counts.tieredFills {
+		ch <- prometheus.MustNewConstMetric(c.tieredDesc, valueType, count, c.ZoneLabelValues(zoneID, status)...)
+	}
+
+	for key, count := range counts.botRequests {
+		labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+		ch <- prometheus.MustNewConstMetric(c.botDesc, valueType, count, labelValues...)
+	}
+
+	for key, count := range counts.endpoints {
+		labelValues := c.ZoneLabelValues(zoneID, strings.Split(key, labelValueSeparator)...)
+		ch <- prometheus.MustNewConstMetric(c.endpointDesc, valueType, count, labelValues...)
+	}
+
+	for host, totals := range counts.originDuration {
+		buckets := make(map[float64]uint64, len(c.durationBuckets))
+		for i, bound := range c.durationBuckets {
+			buckets[bound] = uint64(math.Round(totals.buckets[i]))
+		}
+		ch <- prometheus.MustNewConstHistogram(c.durationDesc, uint64(math.Round(totals.count)), totals.sum, buckets, c.ZoneLabelValues(zoneID, host)...)
+	}
+}
+
+// botScoreRange returns the range of the given bot score, as reported in the
+// bot_score_range label: 1 for requests certainly automated, 2-29 for likely
+// automated ones, 30-99 for likely human ones, and 0 for requests which were
+// not scored.
+func botScoreRange(score int) string {
+	switch {
+	case score <= 0:
+		return "0"
+	case score == 1:
+		return "1"
+	case score < 30:
+		return "2-29"
+	default:
+		return "30-99"
+	}
+}
+
+// errorStage returns the stage of collection at which the given error
+// occurred.
+func errorStage(err error) string {
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		return stageDecode
+	}
+	return stagePull
+}
+
+// topN returns the n entries of counts with the largest values. The remaining
+// entries, if any, are summed into a single entry keyed by otherLabelValue.
+// Ties are broken by key so that the result is deterministic.
+func topN(counts map[string]float64, n int) map[string]float64 {
+	if len(counts) <= n {
+		return counts
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := make(map[string]float64, n+1)
+	for i, k := range keys {
+		if i < n {
+			result[k] = counts[k]
+		} else {
+			result[otherLabelValue] += counts[k]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,1726 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestCollectorHTTPResponses checks that the collector emits correct
+// `cloudflare_logs_http_responses` metrics.
+func TestCollectorHTTPResponses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorHTTPResponseBytes checks that the collector emits correct
+// `cloudflare_logs_http_response_bytes` metrics once enabled.
+func TestCollectorHTTPResponseBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "EdgeResponseBytes": 1024}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "EdgeResponseBytes": 2048}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 404, "OriginResponseStatus": 404, "EdgeResponseBytes": 512}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if n := testutil.CollectAndCount(c, "cloudflare_logs_http_response_bytes"); n != 0 {
+		t.Errorf("expected no metrics unless enabled, got %d", n)
+	}
+
+	c.SetResponseBytes(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_response_bytes Bytes returned to clients by Cloudflare, obtained via Logpull API
+		# TYPE cloudflare_logs_http_response_bytes gauge
+		cloudflare_logs_http_response_bytes{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 3072
+		cloudflare_logs_http_response_bytes{client_request_host="example.org",edge_response_status="404",origin_response_status="404",period="1m",zone="zone-a"} 512
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_response_bytes"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorCacheStatus checks that the collector emits correct
+// `cloudflare_logs_cache_status` metrics once enabled, and doesn't request
+// the cache status before.
+func TestCollectorCacheStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "CacheCacheStatus": "hit"}
+{"ClientRequestHost": "example.org", "CacheCacheStatus": "hit"}
+{"ClientRequestHost": "example.org", "CacheCacheStatus": "miss"}
+{"ClientRequestHost": "www.example.org", "CacheCacheStatus": "dynamic"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if n := testutil.CollectAndCount(c, "cloudflare_logs_cache_status"); n != 0 {
+		t.Errorf("expected no metrics unless enabled, got %d", n)
+	}
+	for _, field := range (responseAggregation{c}).Fields() {
+		if field == "CacheCacheStatus" {
+			t.Error("expected CacheCacheStatus not to be requested unless enabled")
+		}
+	}
+
+	c.SetCacheStatus(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_cache_status Cloudflare HTTP requests by cache status, obtained via Logpull API
+		# TYPE cloudflare_logs_cache_status gauge
+		cloudflare_logs_cache_status{cache_status="dynamic",client_request_host="www.example.org",period="1m",zone="zone-a"} 1
+		cloudflare_logs_cache_status{cache_status="hit",client_request_host="example.org",period="1m",zone="zone-a"} 2
+		cloudflare_logs_cache_status{cache_status="miss",client_request_host="example.org",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_cache_status"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorErrors checks that the collector emits the
+// `cloudflare_logs_errors_total` metric when errors are returned from
+// LogpullAPI.pullLogEntries.
+func TestCollectorErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte("the server's on fire")); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_errors_total The number of errors that have occurred while collecting metrics
+		# TYPE cloudflare_logs_errors_total counter
+		cloudflare_logs_errors_total{stage="decode",zone="zone-a"} 0
+		cloudflare_logs_errors_total{stage="pull",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorDecodeErrors checks that the collector attributes malformed log
+// entries to the decode stage in the `cloudflare_logs_errors_total` metric.
+func TestCollectorDecodeErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte("{garbage")); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_errors_total The number of errors that have occurred while collecting metrics
+		# TYPE cloudflare_logs_errors_total counter
+		cloudflare_logs_errors_total{stage="decode",zone="zone-a"} 1
+		cloudflare_logs_errors_total{stage="pull",zone="zone-a"} 0
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorJA3Fingerprints checks that the collector requests the JA3Hash
+// field and emits capped, per-zone `cloudflare_logs_ja3_fingerprints` metrics
+// when enabled, leaving out entries without a fingerprint.
+func TestCollectorJA3Fingerprints(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "JA3Hash") {
+			t.Error("expected JA3Hash to be requested")
+		}
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "JA3Hash": "aaa"}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "JA3Hash": "aaa"}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "JA3Hash": "bbb"}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "JA3Hash": "ccc"}`)
+		if strings.Contains(r.URL.Path, "zone-b") {
+			jsonBody = []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "JA3Hash": "bbb"}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)
+		}
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.SetJA3TopN(1); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_ja3_fingerprints Cloudflare HTTP requests by JA3 TLS fingerprint, obtained via Logpull API
+		# TYPE cloudflare_logs_ja3_fingerprints gauge
+		cloudflare_logs_ja3_fingerprints{ja3_hash="aaa",period="1m",zone="zone-a"} 2
+		cloudflare_logs_ja3_fingerprints{ja3_hash="other",period="1m",zone="zone-a"} 2
+		cloudflare_logs_ja3_fingerprints{ja3_hash="bbb",period="1m",zone="zone-b"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_ja3_fingerprints"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestTopN checks that topN keeps the largest entries and folds the remainder
+// into the overflow bucket.
+func TestTopN(t *testing.T) {
+	counts := map[string]float64{"a": 3, "b": 1, "c": 2, "d": 1}
+
+	got := topN(counts, 2)
+	want := map[string]float64{"a": 3, "c": 2, otherLabelValue: 2}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := topN(counts, 10); !reflect.DeepEqual(got, counts) {
+		t.Errorf("got %v, want %v", got, counts)
+	}
+}
+
+// TestCollectorClientASNs checks that the collector emits capped, per-zone
+// `cloudflare_logs_client_asn_requests` metrics when enabled.
+func TestCollectorClientASNs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 13335}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 13335}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 15169}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 16509}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.SetASNTopN(1); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_client_asn_requests Cloudflare HTTP requests by client ASN, obtained via Logpull API
+		# TYPE cloudflare_logs_client_asn_requests gauge
+		cloudflare_logs_client_asn_requests{client_asn="13335",period="1m",zone="zone-a"} 2
+		cloudflare_logs_client_asn_requests{client_asn="other",period="1m",zone="zone-a"} 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_client_asn_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorCountriesAndColos checks that the collector emits capped,
+// per-zone `cloudflare_logs_client_country_requests` and
+// `cloudflare_logs_edge_colo_requests` metrics when enabled.
+func TestCollectorCountriesAndColos(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fields := r.URL.Query().Get("fields"); !strings.Contains(fields, "ClientCountry") || !strings.Contains(fields, "EdgeColoCode") {
+			t.Errorf("expected ClientCountry and EdgeColoCode to be requested, got %s", fields)
+		}
+		jsonBody := []byte(`{"ClientCountry": "us", "EdgeColoCode": "SJC"}
+{"ClientCountry": "us", "EdgeColoCode": "SJC"}
+{"ClientCountry": "de", "EdgeColoCode": "FRA"}
+{"ClientCountry": "fr", "EdgeColoCode": "CDG"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := c.SetCountryTopN(1); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := c.SetColoTopN(2); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_client_country_requests Cloudflare HTTP requests by client country, obtained via Logpull API
+		# TYPE cloudflare_logs_client_country_requests gauge
+		cloudflare_logs_client_country_requests{client_country="other",period="1m",zone="zone-a"} 2
+		cloudflare_logs_client_country_requests{client_country="us",period="1m",zone="zone-a"} 2
+		# HELP cloudflare_logs_edge_colo_requests Cloudflare HTTP requests by edge data center, obtained via Logpull API
+		# TYPE cloudflare_logs_edge_colo_requests gauge
+		cloudflare_logs_edge_colo_requests{edge_colo="CDG",period="1m",zone="zone-a"} 1
+		cloudflare_logs_edge_colo_requests{edge_colo="SJC",period="1m",zone="zone-a"} 2
+		cloudflare_logs_edge_colo_requests{edge_colo="other",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_client_country_requests", "cloudflare_logs_edge_colo_requests"); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.SetCountryTopN(-1); err == nil {
+		t.Error("expected error when called with negative n")
+	}
+}
+
+// TestCollectorResponseLabels checks that the collector requests the
+// configured fields and emits `cloudflare_logs_http_responses` metrics with
+// the configured labels.
+func TestCollectorResponseLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fields := r.URL.Query().Get("fields"); fields != "CacheCacheStatus,ClientCountry,EdgeEndTimestamp,RayID" {
+			t.Errorf("unexpected fields requested: %s", fields)
+		}
+		jsonBody := []byte(`{"CacheCacheStatus": "hit", "ClientCountry": "us"}
+{"CacheCacheStatus": "hit", "ClientCountry": "us"}
+{"CacheCacheStatus": "miss", "ClientCountry": "de"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.SetResponseLabels([]LabelConfig{
+		{Field: "CacheCacheStatus", Label: "cache_status"},
+		{Field: "ClientCountry", Label: "client_country"},
+	}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{cache_status="hit",client_country="us",period="1m",zone="zone-a"} 2
+		cloudflare_logs_http_responses{cache_status="miss",client_country="de",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorResponseLabelsErrors checks that SetResponseLabels rejects
+// invalid label sets.
+func TestCollectorResponseLabelsErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		labels    []LabelConfig
+	}{
+		{"with no labels", nil},
+		{"with unsupported field", []LabelConfig{{Field: "Garbage", Label: "garbage"}}},
+		{"with invalid label name", []LabelConfig{{Field: "ClientCountry", Label: "client-country"}}},
+		{"with duplicate label name", []LabelConfig{{Field: "ClientCountry", Label: "a"}, {Field: "EdgeColoCode", Label: "a"}}},
+		{"with reserved label name", []LabelConfig{{Field: "ClientCountry", Label: "period"}}},
+	}
+
+	api, err := NewLogpullAPI("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.condition, func(t *testing.T) {
+			if err := c.SetResponseLabels(tc.labels); err == nil {
+				t.Errorf("expected error when called %s", tc.condition)
+			}
+		})
+	}
+}
+
+// TestCollectorHandlers checks that the zone and collect handlers are called
+// with the outcome of each collection.
+func TestCollectorHandlers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "bad-zone") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"good-zone", "bad-zone"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]bool)
+	c.SetZoneHandler(func(zoneID string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[zoneID] = err == nil
+	})
+
+	collected := false
+	c.SetCollectHandler(func(ok bool) {
+		collected = true
+		if ok {
+			t.Error("expected collection to be reported as failed")
+		}
+	})
+
+	testutil.CollectAndCount(c)
+
+	if !collected {
+		t.Error("expected collect handler to be called")
+	}
+
+	if expected := map[string]bool{"good-zone": true, "bad-zone": false}; !reflect.DeepEqual(results, expected) {
+		t.Errorf("got %v, want %v", results, expected)
+	}
+}
+
+// TestCollectorIncremental checks that, in incremental mode, each collection
+// only pulls logs newer than the previous one, and that the collector emits
+// cumulative `cloudflare_logs_http_responses_total` counters.
+func TestCollectorIncremental(t *testing.T) {
+	var starts, ends []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		starts = append(starts, r.URL.Query().Get("start"))
+		ends = append(ends, r.URL.Query().Get("end"))
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetIncremental(true)
+
+	testutil.CollectAndCount(c)
+	time.Sleep(time.Second)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses_total Cloudflare HTTP responses since the exporter started, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses_total counter
+		cloudflare_logs_http_responses_total{client_request_host="example.org",edge_response_status="200",origin_response_status="200",zone="zone-a"} 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses_total"); err != nil {
+		t.Error(err)
+	}
+
+	if len(starts) != 2 || starts[1] != ends[0] {
+		t.Errorf("expected second window to start where the first ended, got starts %v and ends %v", starts, ends)
+	}
+}
+
+// TestCollectorIncrementalTopN checks that, in incremental mode, the top-N
+// metrics remain gauges over the latest pulled window, rather than counters.
+func TestCollectorIncrementalTopN(t *testing.T) {
+	bodies := []string{
+		`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 13335}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 13335}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 15169}`,
+		`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "ClientASN": 15169}`,
+	}
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := bodies[len(bodies)-1]
+		if requests < len(bodies) {
+			body = bodies[requests]
+		}
+		requests++
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.SetIncremental(true)
+	if err := c.SetASNTopN(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testutil.CollectAndCount(c)
+	time.Sleep(time.Second)
+
+	// Only the second window counts, and the "other" series of the first
+	// window is gone. The window is not logPeriod, so there is no period
+	// label.
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_client_asn_requests Cloudflare HTTP requests by client ASN, obtained via Logpull API
+		# TYPE cloudflare_logs_client_asn_requests gauge
+		cloudflare_logs_client_asn_requests{client_asn="15169",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_client_asn_requests"); err != nil {
+		t.Error(err)
+	}
+
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
+
+// TestCollectorDebugVars checks that the collector's debug variables reflect
+// the position of its cursors, and that no other variables are served.
+func TestCollectorDebugVars(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.SetIncremental(true)
+	testutil.CollectAndCount(c)
+
+	rec := httptest.NewRecorder()
+	c.DebugVarsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(body) != 1 {
+		t.Errorf("expected only the collector variable, got %s", rec.Body)
+	}
+	data := body["collector"]
+
+	var vars struct {
+		Incremental bool `json:"incremental"`
+		Zones       map[string]struct {
+			Cursor *time.Time `json:"cursor"`
+			Series int        `json:"cumulative_series"`
+		} `json:"zones"`
+	}
+	if err := json.Unmarshal(data, &vars); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if z := vars.Zones["zone-a"]; !vars.Incremental || z.Cursor == nil || z.Series != 1 {
+		t.Errorf("unexpected debug vars: %s", data)
+	}
+}
+
+// TestCollectorFirewallEvents checks that the collector emits correct
+// `cloudflare_logs_firewall_events` metrics when enabled.
+func TestCollectorFirewallEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "FirewallMatchesActions") {
+			t.Error("expected FirewallMatchesActions to be requested")
+		}
+		jsonBody := []byte(`{"FirewallMatchesActions": ["block"], "FirewallMatchesSources": ["waf"]}
+{"FirewallMatchesActions": ["log", "block"], "FirewallMatchesSources": ["firewallrules", "waf"]}
+{"FirewallMatchesActions": [], "FirewallMatchesSources": []}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetFirewallEvents(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_firewall_events Cloudflare firewall rule matches by action and source, obtained via Logpull API
+		# TYPE cloudflare_logs_firewall_events gauge
+		cloudflare_logs_firewall_events{action="block",period="1m",source="waf",zone="zone-a"} 2
+		cloudflare_logs_firewall_events{action="log",period="1m",source="firewallrules",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_firewall_events"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorOriginDuration checks that the collector emits a correct
+// `cloudflare_logs_origin_response_duration_seconds` histogram once enabled,
+// skipping responses served without contacting the origin.
+func TestCollectorOriginDuration(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "OriginResponseStatus": 200, "OriginResponseTime": 50000000}
+{"ClientRequestHost": "example.org", "OriginResponseStatus": 200, "OriginResponseTime": 300000000}
+{"ClientRequestHost": "example.org", "OriginResponseStatus": 502, "OriginResponseTime": 2000000000}
+{"ClientRequestHost": "example.org", "OriginResponseStatus": 0, "OriginResponseTime": 0}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if n := testutil.CollectAndCount(c, "cloudflare_logs_origin_response_duration_seconds"); n != 0 {
+		t.Errorf("expected no histogram unless enabled, got %d", n)
+	}
+
+	c.SetOriginDuration(true)
+	if err := c.SetOriginDurationBuckets([]float64{0.1, 1}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_origin_response_duration_seconds Time taken by origins to respond to Cloudflare, obtained via Logpull API
+		# TYPE cloudflare_logs_origin_response_duration_seconds histogram
+		cloudflare_logs_origin_response_duration_seconds_bucket{client_request_host="example.org",period="1m",zone="zone-a",le="0.1"} 1
+		cloudflare_logs_origin_response_duration_seconds_bucket{client_request_host="example.org",period="1m",zone="zone-a",le="1"} 2
+		cloudflare_logs_origin_response_duration_seconds_bucket{client_request_host="example.org",period="1m",zone="zone-a",le="+Inf"} 3
+		cloudflare_logs_origin_response_duration_seconds_sum{client_request_host="example.org",period="1m",zone="zone-a"} 2.35
+		cloudflare_logs_origin_response_duration_seconds_count{client_request_host="example.org",period="1m",zone="zone-a"} 3
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_origin_response_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+
+	for _, buckets := range [][]float64{nil, {0}, {1, 0.5}} {
+		if err := c.SetOriginDurationBuckets(buckets); err == nil {
+			t.Errorf("expected error when called with %v", buckets)
+		}
+	}
+}
+
+// TestCollectorZoneLabels checks that per-zone metrics are labeled with the
+// zone's name, and optionally its ID.
+func TestCollectorZoneLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetZoneNames(map[string]string{"zone-a": "example.org"})
+	c.SetZoneIDLabel(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="example.org",zone_id="zone-a"} 1
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-b",zone_id="zone-b"} 1
+		# HELP cloudflare_logs_errors_total The number of errors that have occurred while collecting metrics
+		# TYPE cloudflare_logs_errors_total counter
+		cloudflare_logs_errors_total{stage="decode",zone="example.org",zone_id="zone-a"} 0
+		cloudflare_logs_errors_total{stage="decode",zone="zone-b",zone_id="zone-b"} 0
+		cloudflare_logs_errors_total{stage="pull",zone="example.org",zone_id="zone-a"} 0
+		cloudflare_logs_errors_total{stage="pull",zone="zone-b",zone_id="zone-b"} 0
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses", "cloudflare_logs_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorSchemaCheck checks that the collector reports requested fields
+// which are not listed by the Logpull fields endpoint.
+func TestCollectorSchemaCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := logEntryJSON
+		if strings.HasSuffix(r.URL.Path, "/fields") {
+			body = []byte(`{"ClientRequestHost": "Host requested by the client", "EdgeResponseStatus": "HTTP status code returned by Cloudflare to the client"}`)
+		}
+		if _, err := w.Write(body); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetSchemaCheck(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logpull_missing_fields The number of requested fields which are no longer available via Logpull API
+		# TYPE cloudflare_logpull_missing_fields gauge
+		cloudflare_logpull_missing_fields{zone="zone-a"} 1
+		# HELP cloudflare_logpull_schema_changes_total The number of times the fields available via Logpull API have changed
+		# TYPE cloudflare_logpull_schema_changes_total counter
+		cloudflare_logpull_schema_changes_total{zone="zone-a"} 0
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logpull_missing_fields", "cloudflare_logpull_schema_changes_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorTieredCache checks that the collector emits correct
+// `cloudflare_logs_tiered_cache_fills` metrics when enabled.
+func TestCollectorTieredCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "CacheTieredFill") {
+			t.Error("expected CacheTieredFill to be requested")
+		}
+		jsonBody := []byte(`{"CacheTieredFill": true, "OriginResponseStatus": 0}
+{"CacheTieredFill": true, "OriginResponseStatus": 0}
+{"CacheTieredFill": true, "OriginResponseStatus": 200}
+{"CacheTieredFill": false, "OriginResponseStatus": 200}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetTieredCache(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_tiered_cache_fills Cloudflare HTTP requests filled from an upper tier data center, obtained via Logpull API
+		# TYPE cloudflare_logs_tiered_cache_fills gauge
+		cloudflare_logs_tiered_cache_fills{period="1m",upper_tier_status="hit",zone="zone-a"} 2
+		cloudflare_logs_tiered_cache_fills{period="1m",upper_tier_status="miss",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_tiered_cache_fills"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorRefresh checks that with a refresh interval, scrapes serve the
+// metrics of the latest background refresh without pulling logs.
+func TestCollectorRefresh(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.SetRefreshInterval(time.Minute); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if n := testutil.CollectAndCount(c, "cloudflare_logs_http_responses"); n != 0 || requests != 0 {
+		t.Errorf("expected no metrics and no requests before the first refresh, got %d and %d", n, requests)
+	}
+
+	c.refresh(context.Background())
+
+	for i := 0; i < 2; i++ {
+		if n := testutil.CollectAndCount(c, "cloudflare_logs_http_responses"); n != 1 {
+			t.Errorf("expected 1 metric after a refresh, got %d", n)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+
+	if err := c.SetRefreshInterval(-1 * time.Second); err == nil {
+		t.Error("expected error when called with negative interval")
+	}
+}
+
+// TestCollectorReload checks that reloading the collector keeps the error
+// counters of retained zones, drops those of removed zones, and applies the
+// new label set.
+func TestCollectorReload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	testutil.CollectAndCount(c)
+
+	labels := []LabelConfig{{Field: "ClientCountry", Label: "country"}}
+	if err := c.Reload(ReloadConfig{ZoneIDs: []string{"zone-a", "zone-c"}, ZoneNames: map[string]string{}, ResponseLabels: labels}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_errors_total The number of errors that have occurred while collecting metrics
+		# TYPE cloudflare_logs_errors_total counter
+		cloudflare_logs_errors_total{stage="decode",zone="zone-a"} 0
+		cloudflare_logs_errors_total{stage="decode",zone="zone-c"} 0
+		cloudflare_logs_errors_total{stage="pull",zone="zone-a"} 2
+		cloudflare_logs_errors_total{stage="pull",zone="zone-c"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(c.responseLabels, labels) {
+		t.Errorf("expected labels %v, got %v", labels, c.responseLabels)
+	}
+
+	if err := c.Reload(ReloadConfig{ResponseLabels: labels}); err == nil {
+		t.Error("expected error when called with no zones")
+	}
+	if err := c.Reload(ReloadConfig{ZoneIDs: []string{"zone-a"}, ResponseLabels: []LabelConfig{{Field: "Unknown", Label: "unknown"}}}); err == nil {
+		t.Error("expected error when called with invalid labels")
+	}
+	if err := c.Reload(ReloadConfig{ZoneIDs: []string{"zone-b"}, ResponseLabels: labels, ResponseKeys: []map[string]string{{"zone": "zone-b"}}}); err == nil {
+		t.Error("expected error when called with invalid keys")
+	}
+	if !reflect.DeepEqual(c.Zones(), []string{"zone-a", "zone-c"}) {
+		t.Errorf("expected failed reloads to leave zones unchanged, got %v", c.Zones())
+	}
+}
+
+// TestCollectorCheckFields checks that CheckFields reports the requested
+// fields which are not available via Logpull API.
+func TestCollectorCheckFields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"CacheCacheStatus": "", "ClientRequestHost": "", "EdgeResponseBytes": "", "EdgeResponseStatus": "", "OriginResponseStatus": "", "OriginResponseTime": ""}`
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.CheckFields(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.SetFirewallEvents(true)
+
+	err = c.CheckFields(context.Background())
+	var unknownErr *UnknownFieldsError
+	if !errors.As(err, &unknownErr) || !strings.Contains(err.Error(), "FirewallMatchesActions, FirewallMatchesSources") {
+		t.Errorf("expected error listing the firewall fields, got %v", err)
+	}
+}
+
+// TestCollectorCheckFieldsRetry checks that CheckFields retries failures to
+// list the fields, and does not report them as unknown fields.
+func TestCollectorCheckFieldsRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(2, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	err = c.CheckFields(context.Background())
+	var unknownErr *UnknownFieldsError
+	if err == nil || errors.As(err, &unknownErr) {
+		t.Errorf("expected error other than unknown fields, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+// TestCollectorSampling checks that sampled logs are requested with the sample
+// rate, and that counts are scaled back up accordingly.
+func TestCollectorSampling(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sample := r.URL.Query().Get("sample"); sample != "0.1" {
+			t.Errorf("unexpected sample rate: %q", sample)
+		}
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "EdgeResponseBytes": 100}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithSample(0.1))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	c.SetResponseBytes(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_response_bytes Bytes returned to clients by Cloudflare, obtained via Logpull API
+		# TYPE cloudflare_logs_http_response_bytes gauge
+		cloudflare_logs_http_response_bytes{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 1000
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 10
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses", "cloudflare_logs_http_response_bytes"); err != nil {
+		t.Error(err)
+	}
+
+	for _, rate := range []float64{0, 1.5} {
+		if _, err := NewLogpullAPI("", "", WithSample(rate)); err == nil {
+			t.Errorf("expected error when called with rate %g", rate)
+		}
+	}
+}
+
+// TestCollectorRegister checks that conflicting registrations are reported as
+// errors, and that namespaces prefix the names of all metrics.
+func TestCollectorRegister(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	newTestCollector := func() *Collector {
+		c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+			t.Errorf("unexpected error: %s", err)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return c
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := newTestCollector().Register(reg, ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := newTestCollector().Register(reg, ""); err == nil {
+		t.Error("expected error when registering conflicting metrics")
+	}
+	if err := newTestCollector().Register(reg, "edge"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := newTestCollector().Register(reg, "edge-2"); err == nil {
+		t.Error("expected error when called with invalid namespace")
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var found bool
+	for _, family := range families {
+		found = found || family.GetName() == "edge_cloudflare_logs_http_responses"
+	}
+	if !found {
+		t.Error("expected namespaced edge_cloudflare_logs_http_responses metric")
+	}
+}
+
+func TestCollectorRequestMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	api.SetRequestHook(c.ObserveRequest)
+
+	expected := fmt.Sprintf(`
+		# HELP cloudflare_logpull_request_bytes_total The number of bytes received in Logpull API responses, before decompression
+		# TYPE cloudflare_logpull_request_bytes_total counter
+		cloudflare_logpull_request_bytes_total %d
+		# HELP cloudflare_logpull_requests_total The number of Logpull API requests, by HTTP status code, or error if no response was received
+		# TYPE cloudflare_logpull_requests_total counter
+		cloudflare_logpull_requests_total{status="200"} 2
+	`, 2*len(logEntryJSON))
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "cloudflare_logpull_request_bytes_total", "cloudflare_logpull_requests_total"); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+
+	if count := testutil.CollectAndCount(c, "cloudflare_logpull_request_duration_seconds"); count != 1 {
+		t.Errorf("expected request duration histogram, got %d metrics", count)
+	}
+}
+
+func TestCollectorFIPSMode(t *testing.T) {
+	api, err := NewLogpullAPI("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	value := 0
+	if FIPSEnabled() {
+		value = 1
+	}
+
+	expected := fmt.Sprintf(`
+		# HELP cloudflare_logpull_fips_mode Whether the exporter performs cryptography with a FIPS 140-2 validated module (1) or not (0)
+		# TYPE cloudflare_logpull_fips_mode gauge
+		cloudflare_logpull_fips_mode %d
+	`, value)
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "cloudflare_logpull_fips_mode"); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
+
+// TestCollectorConcurrency checks that no more zones are pulled at the same
+// time than the configured concurrency, and that all zones are pulled.
+func TestCollectorConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive, requests := 0, 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		requests++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	zoneIDs := []string{"zone-a", "zone-b", "zone-c", "zone-d", "zone-e"}
+	c, err := New(api, zoneIDs, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.SetConcurrency(2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count := testutil.CollectAndCount(c, "cloudflare_logs_http_responses"); count != len(zoneIDs) {
+		t.Errorf("expected %d series, got %d", len(zoneIDs), count)
+	}
+
+	if requests != len(zoneIDs) || maxActive != 2 {
+		t.Errorf("expected %d requests, at most 2 at a time, got %d, %d at a time", len(zoneIDs), requests, maxActive)
+	}
+
+	if err := c.SetConcurrency(-1); err == nil {
+		t.Error("expected error when called with negative concurrency")
+	}
+}
+
+// TestCollectorScrapeTimeout checks that collections end before the scrape
+// timeout announced by Prometheus, with the metrics of the zones collected in
+// time.
+func TestCollectorScrapeTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "zone-b") {
+			<-r.Context().Done()
+			return
+		}
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	handler := c.ScrapeHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected scrape to end within the timeout, took %s", elapsed)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"}`) {
+		t.Errorf("expected metrics of zone-a, got:\n%s", body)
+	}
+	if !strings.Contains(body, "cloudflare_logs_collect_timeouts_total 1") {
+		t.Errorf("expected a collect timeout, got:\n%s", body)
+	}
+	if deadline := atomic.LoadInt64(&c.scrapeDeadline); deadline != 0 {
+		t.Errorf("expected deadline to be cleared after the scrape, got %d", deadline)
+	}
+}
+
+// TestCollectorRefreshIgnoresScrapeDeadline checks that background refreshes
+// are not cut short by the deadline of a scrape served meanwhile.
+func TestCollectorRefreshIgnoresScrapeDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.SetRefreshInterval(time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A scrape whose deadline has passed is still being served.
+	atomic.StoreInt64(&c.scrapeDeadline, time.Now().Add(-time.Second).UnixNano())
+	c.refresh(context.Background())
+
+	if n := testutil.CollectAndCount(c, "cloudflare_logs_http_responses"); n != 1 {
+		t.Errorf("expected 1 metric after a refresh, got %d", n)
+	}
+	if n := testutil.ToFloat64(c.collectTimeouts); n != 0 {
+		t.Errorf("expected no collect timeouts, got %v", n)
+	}
+}
+
+// TestCollectorHostLimiter checks that hosts beyond the limit are reported as
+// other.
+func TestCollectorHostLimiter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "a.example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}
+{"ClientRequestHost": "b.example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}
+{"ClientRequestHost": "c.example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	hosts, err := NewHostLimiter(1, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetHostLimiter(hosts)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="a.example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 1
+		cloudflare_logs_http_responses{client_request_host="other",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorBotScores checks that the collector emits correct
+// `cloudflare_logs_bot_requests` metrics when enabled.
+func TestCollectorBotScores(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "BotScore,BotScoreSrc") {
+			t.Error("expected BotScore and BotScoreSrc to be requested")
+		}
+		jsonBody := []byte(`{"BotScore": 1, "BotScoreSrc": "Heuristics"}
+{"BotScore": 12, "BotScoreSrc": "Machine Learning"}
+{"BotScore": 29, "BotScoreSrc": "Machine Learning"}
+{"BotScore": 30, "BotScoreSrc": "Machine Learning"}
+{"BotScore": 0, "BotScoreSrc": "Not Computed"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.SetBotScores(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_bot_requests Cloudflare HTTP requests by bot score range and source, obtained via Logpull API
+		# TYPE cloudflare_logs_bot_requests gauge
+		cloudflare_logs_bot_requests{bot_score_range="0",bot_score_source="Not Computed",period="1m",zone="zone-a"} 1
+		cloudflare_logs_bot_requests{bot_score_range="1",bot_score_source="Heuristics",period="1m",zone="zone-a"} 1
+		cloudflare_logs_bot_requests{bot_score_range="2-29",bot_score_source="Machine Learning",period="1m",zone="zone-a"} 2
+		cloudflare_logs_bot_requests{bot_score_range="30-99",bot_score_source="Machine Learning",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_bot_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorEndpoints checks that the collector emits correct
+// `cloudflare_logs_endpoint_requests` metrics when enabled.
+func TestCollectorEndpoints(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "ClientRequestMethod,ClientRequestURI") {
+			t.Error("expected ClientRequestMethod and ClientRequestURI to be requested")
+		}
+		jsonBody := []byte(`{"ClientRequestMethod": "GET", "ClientRequestURI": "/api/users/1"}
+{"ClientRequestMethod": "GET", "ClientRequestURI": "/api/users/2?fields=name"}
+{"ClientRequestMethod": "DELETE", "ClientRequestURI": "/api/users/2"}
+{"ClientRequestMethod": "GET", "ClientRequestURI": "/favicon.ico"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	paths, err := NewPathGrouper([]PathGroupConfig{{Regex: `^/api/users/[^/]+$`, Group: "/api/users/:id"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetEndpoints(true, paths)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_endpoint_requests Cloudflare HTTP requests by request method and path group, obtained via Logpull API
+		# TYPE cloudflare_logs_endpoint_requests gauge
+		cloudflare_logs_endpoint_requests{method="DELETE",path_group="/api/users/:id",period="1m",zone="zone-a"} 1
+		cloudflare_logs_endpoint_requests{method="GET",path_group="/api/users/:id",period="1m",zone="zone-a"} 2
+		cloudflare_logs_endpoint_requests{method="GET",path_group="other",period="1m",zone="zone-a"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_endpoint_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorZoneMetadata checks that zone metadata labels are added to
+// per-zone metrics, and follow changes of the metadata.
+func TestCollectorZoneMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := c.SetZoneMetadataLabels([]string{"zone_account"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, account := range []string{"Team A", "Team B"} {
+		c.SetZoneMetadata(map[string]map[string]string{"zone-a": {"zone_account": account}})
+
+		expected := strings.NewReader(fmt.Sprintf(`
+			# HELP cloudflare_logs_errors_total The number of errors that have occurred while collecting metrics
+			# TYPE cloudflare_logs_errors_total counter
+			cloudflare_logs_errors_total{stage="decode",zone="zone-a",zone_account=%[1]q} 0
+			cloudflare_logs_errors_total{stage="pull",zone="zone-a",zone_account=%[1]q} 0
+			# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+			# TYPE cloudflare_logs_http_responses gauge
+			cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a",zone_account=%[1]q} 1
+		`, account))
+
+		if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_errors_total", "cloudflare_logs_http_responses"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if err := c.SetZoneMetadataLabels([]string{"zone_owner"}); err == nil {
+		t.Error("expected error when called with an unsupported label")
+	}
+	if err := c.SetResponseLabels([]LabelConfig{{Field: "ClientCountry", Label: "zone_plan"}}); err == nil {
+		t.Error("expected error when called with a reserved label")
+	}
+}
+
+// TestCollectorZoneAPIs checks that zones are pulled with their own Logpull
+// API clients, and that the request counters of all clients are reported.
+func TestCollectorZoneAPIs(t *testing.T) {
+	var requests int64
+	newServer := func(zoneID string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.URL.Path, "/"+zoneID+"/") {
+				t.Errorf("unexpected zone: %s", r.URL.Path)
+			}
+			if atomic.AddInt64(&requests, 1)%2 == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if _, err := w.Write([]byte(`{"ClientRequestHost": "` + zoneID + `.example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}))
+	}
+	tsA, tsB := newServer("zone-a"), newServer("zone-b")
+	defer tsA.Close()
+	defer tsB.Close()
+
+	apiA, err := NewLogpullAPI("", "", WithBaseURL(tsA.URL), WithHTTPClient(tsA.Client()), WithRetry(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	apiB, err := NewLogpullAPI("", "", WithBaseURL(tsB.URL), WithHTTPClient(tsB.Client()), WithRetry(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(apiA, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.SetConcurrency(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetZoneAPIs(map[string]*LogpullAPI{"zone-b": apiB})
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="zone-a.example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 1
+		cloudflare_logs_http_responses{client_request_host="zone-b.example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-b"} 1
+		# HELP cloudflare_logpull_retries_total The number of Logpull API requests that have been retried
+		# TYPE cloudflare_logpull_retries_total counter
+		cloudflare_logpull_retries_total 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses", "cloudflare_logpull_retries_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorResponseKeys checks that windows without log entries are
+// counted, and that the response key label sets are reported with zero
+// values for the zones they apply to.
+func TestCollectorResponseKeys(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a", "zone-b"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = c.SetResponseKeys([]map[string]string{
+		{"zone": "zone-a", "client_request_host": "example.org", "edge_response_status": "200", "origin_response_status": "200"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="zone-a"} 0
+		# HELP cloudflare_logs_window_empty_total The number of windows pulled without any log entries
+		# TYPE cloudflare_logs_window_empty_total counter
+		cloudflare_logs_window_empty_total{zone="zone-a"} 1
+		cloudflare_logs_window_empty_total{zone="zone-b"} 1
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses", "cloudflare_logs_window_empty_total"); err != nil {
+		t.Error(err)
+	}
+
+	testCases := []struct {
+		condition string
+		keys      []map[string]string
+	}{
+		{"with missing label", []map[string]string{{"client_request_host": "example.org"}}},
+		{"with unknown label", []map[string]string{{"client_request_host": "example.org", "edge_response_status": "200", "origin_response_status": "200", "method": "GET"}}},
+	}
+
+	for _, tc := range testCases {
+		if err := c.SetResponseKeys(tc.keys); err == nil {
+			t.Errorf("expected error when called %s", tc.condition)
+		}
+	}
+}
+
+// TestCollectorSplitStatus checks that the responses are reported by edge and
+// by origin response status separately when the status is split.
+func TestCollectorSplitStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200, "EdgeResponseBytes": 100}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 304, "EdgeResponseBytes": 10}
+{"ClientRequestHost": "example.org", "EdgeResponseStatus": 502, "OriginResponseStatus": 0, "EdgeResponseBytes": 1}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetSplitStatus(true)
+	c.SetResponseBytes(true)
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_edge_responses Cloudflare HTTP responses by edge response status, obtained via Logpull API
+		# TYPE cloudflare_logs_edge_responses gauge
+		cloudflare_logs_edge_responses{client_request_host="example.org",period="1m",status="200",zone="zone-a"} 2
+		cloudflare_logs_edge_responses{client_request_host="example.org",period="1m",status="502",zone="zone-a"} 1
+		# HELP cloudflare_logs_http_response_bytes Bytes returned to clients by Cloudflare, obtained via Logpull API
+		# TYPE cloudflare_logs_http_response_bytes gauge
+		cloudflare_logs_http_response_bytes{client_request_host="example.org",period="1m",status="200",zone="zone-a"} 110
+		cloudflare_logs_http_response_bytes{client_request_host="example.org",period="1m",status="502",zone="zone-a"} 1
+		# HELP cloudflare_logs_origin_responses Cloudflare HTTP responses by origin response status, obtained via Logpull API
+		# TYPE cloudflare_logs_origin_responses gauge
+		cloudflare_logs_origin_responses{client_request_host="example.org",period="1m",status="0",zone="zone-a"} 1
+		cloudflare_logs_origin_responses{client_request_host="example.org",period="1m",status="200",zone="zone-a"} 1
+		cloudflare_logs_origin_responses{client_request_host="example.org",period="1m",status="304",zone="zone-a"} 1
+	`)
+
+	metrics := []string{"cloudflare_logs_edge_responses", "cloudflare_logs_origin_responses", "cloudflare_logs_http_response_bytes", "cloudflare_logs_http_responses"}
+	if err := testutil.CollectAndCompare(c, expected, metrics...); err != nil {
+		t.Error(err)
+	}
+}
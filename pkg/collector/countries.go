@@ -0,0 +1,284 @@
+package collector
+
+import "strings"
+
+// unknownCountry is the country code of requests whose country is unknown,
+// as reported by Cloudflare.
+const unknownCountry = "xx"
+
+// countryCode returns the lowercase ISO 3166-1 alpha-2 code of the given
+// country, as the ClientCountry field of Logpull reports it. The GraphQL
+// Analytics API reports uppercase codes, such as US, but may report names,
+// such as United States, which are looked up in countryCodes. Two-letter
+// values are kept as they are, since Cloudflare reports codes of its own,
+// such as T1 for Tor, and unknown names are reported as unknownCountry.
+func countryCode(country string) string {
+	if len(country) == 2 {
+		return strings.ToLower(country)
+	}
+	if code, ok := countryCodes[strings.ToLower(country)]; ok {
+		return code
+	}
+	return unknownCountry
+}
+
+// countryCodes maps the lowercase English names of countries to their ISO
+// 3166-1 alpha-2 codes.
+var countryCodes = map[string]string{
+	"afghanistan":                            "af",
+	"albania":                                "al",
+	"algeria":                                "dz",
+	"american samoa":                         "as",
+	"andorra":                                "ad",
+	"angola":                                 "ao",
+	"anguilla":                               "ai",
+	"antarctica":                             "aq",
+	"antigua & barbuda":                      "ag",
+	"argentina":                              "ar",
+	"armenia":                                "am",
+	"aruba":                                  "aw",
+	"ascension island":                       "ac",
+	"australia":                              "au",
+	"austria":                                "at",
+	"azerbaijan":                             "az",
+	"bahamas":                                "bs",
+	"bahrain":                                "bh",
+	"bangladesh":                             "bd",
+	"barbados":                               "bb",
+	"belarus":                                "by",
+	"belgium":                                "be",
+	"belize":                                 "bz",
+	"benin":                                  "bj",
+	"bermuda":                                "bm",
+	"bhutan":                                 "bt",
+	"bolivia":                                "bo",
+	"bosnia & herzegovina":                   "ba",
+	"botswana":                               "bw",
+	"bouvet island":                          "bv",
+	"brazil":                                 "br",
+	"british indian ocean territory":         "io",
+	"british virgin islands":                 "vg",
+	"brunei":                                 "bn",
+	"bulgaria":                               "bg",
+	"burkina faso":                           "bf",
+	"burundi":                                "bi",
+	"cambodia":                               "kh",
+	"cameroon":                               "cm",
+	"canada":                                 "ca",
+	"canary islands":                         "ic",
+	"cape verde":                             "cv",
+	"caribbean netherlands":                  "bq",
+	"cayman islands":                         "ky",
+	"central african republic":               "cf",
+	"ceuta & melilla":                        "ea",
+	"chad":                                   "td",
+	"chile":                                  "cl",
+	"china":                                  "cn",
+	"christmas island":                       "cx",
+	"clipperton island":                      "cp",
+	"cocos (keeling) islands":                "cc",
+	"colombia":                               "co",
+	"comoros":                                "km",
+	"congo - brazzaville":                    "cg",
+	"congo - kinshasa":                       "cd",
+	"cook islands":                           "ck",
+	"costa rica":                             "cr",
+	"croatia":                                "hr",
+	"cuba":                                   "cu",
+	"curaçao":                                "cw",
+	"cyprus":                                 "cy",
+	"czechia":                                "cz",
+	"côte d’ivoire":                          "ci",
+	"denmark":                                "dk",
+	"diego garcia":                           "dg",
+	"djibouti":                               "dj",
+	"dominica":                               "dm",
+	"dominican republic":                     "do",
+	"ecuador":                                "ec",
+	"egypt":                                  "eg",
+	"el salvador":                            "sv",
+	"equatorial guinea":                      "gq",
+	"eritrea":                                "er",
+	"estonia":                                "ee",
+	"ethiopia":                               "et",
+	"falkland islands":                       "fk",
+	"faroe islands":                          "fo",
+	"fiji":                                   "fj",
+	"finland":                                "fi",
+	"france":                                 "fr",
+	"french guiana":                          "gf",
+	"french polynesia":                       "pf",
+	"french southern territories":            "tf",
+	"gabon":                                  "ga",
+	"gambia":                                 "gm",
+	"georgia":                                "ge",
+	"germany":                                "de",
+	"ghana":                                  "gh",
+	"gibraltar":                              "gi",
+	"greece":                                 "gr",
+	"greenland":                              "gl",
+	"grenada":                                "gd",
+	"guadeloupe":                             "gp",
+	"guam":                                   "gu",
+	"guatemala":                              "gt",
+	"guernsey":                               "gg",
+	"guinea":                                 "gn",
+	"guinea-bissau":                          "gw",
+	"guyana":                                 "gy",
+	"haiti":                                  "ht",
+	"heard & mcdonald islands":               "hm",
+	"honduras":                               "hn",
+	"hong kong sar china":                    "hk",
+	"hungary":                                "hu",
+	"iceland":                                "is",
+	"india":                                  "in",
+	"indonesia":                              "id",
+	"iran":                                   "ir",
+	"iraq":                                   "iq",
+	"ireland":                                "ie",
+	"isle of man":                            "im",
+	"israel":                                 "il",
+	"italy":                                  "it",
+	"jamaica":                                "jm",
+	"japan":                                  "jp",
+	"jersey":                                 "je",
+	"jordan":                                 "jo",
+	"kazakhstan":                             "kz",
+	"kenya":                                  "ke",
+	"kiribati":                               "ki",
+	"kosovo":                                 "xk",
+	"kuwait":                                 "kw",
+	"kyrgyzstan":                             "kg",
+	"laos":                                   "la",
+	"latvia":                                 "lv",
+	"lebanon":                                "lb",
+	"lesotho":                                "ls",
+	"liberia":                                "lr",
+	"libya":                                  "ly",
+	"liechtenstein":                          "li",
+	"lithuania":                              "lt",
+	"luxembourg":                             "lu",
+	"macau sar china":                        "mo",
+	"macedonia":                              "mk",
+	"madagascar":                             "mg",
+	"malawi":                                 "mw",
+	"malaysia":                               "my",
+	"maldives":                               "mv",
+	"mali":                                   "ml",
+	"malta":                                  "mt",
+	"marshall islands":                       "mh",
+	"martinique":                             "mq",
+	"mauritania":                             "mr",
+	"mauritius":                              "mu",
+	"mayotte":                                "yt",
+	"mexico":                                 "mx",
+	"micronesia":                             "fm",
+	"moldova":                                "md",
+	"monaco":                                 "mc",
+	"mongolia":                               "mn",
+	"montenegro":                             "me",
+	"montserrat":                             "ms",
+	"morocco":                                "ma",
+	"mozambique":                             "mz",
+	"myanmar (burma)":                        "mm",
+	"namibia":                                "na",
+	"nauru":                                  "nr",
+	"nepal":                                  "np",
+	"netherlands":                            "nl",
+	"new caledonia":                          "nc",
+	"new zealand":                            "nz",
+	"nicaragua":                              "ni",
+	"niger":                                  "ne",
+	"nigeria":                                "ng",
+	"niue":                                   "nu",
+	"norfolk island":                         "nf",
+	"north korea":                            "kp",
+	"northern mariana islands":               "mp",
+	"norway":                                 "no",
+	"oman":                                   "om",
+	"pakistan":                               "pk",
+	"palau":                                  "pw",
+	"palestinian territories":                "ps",
+	"panama":                                 "pa",
+	"papua new guinea":                       "pg",
+	"paraguay":                               "py",
+	"peru":                                   "pe",
+	"philippines":                            "ph",
+	"pitcairn islands":                       "pn",
+	"poland":                                 "pl",
+	"portugal":                               "pt",
+	"puerto rico":                            "pr",
+	"qatar":                                  "qa",
+	"romania":                                "ro",
+	"russia":                                 "ru",
+	"rwanda":                                 "rw",
+	"réunion":                                "re",
+	"samoa":                                  "ws",
+	"san marino":                             "sm",
+	"saudi arabia":                           "sa",
+	"senegal":                                "sn",
+	"serbia":                                 "rs",
+	"seychelles":                             "sc",
+	"sierra leone":                           "sl",
+	"singapore":                              "sg",
+	"sint maarten":                           "sx",
+	"slovakia":                               "sk",
+	"slovenia":                               "si",
+	"solomon islands":                        "sb",
+	"somalia":                                "so",
+	"south africa":                           "za",
+	"south georgia & south sandwich islands": "gs",
+	"south korea":                            "kr",
+	"south sudan":                            "ss",
+	"spain":                                  "es",
+	"sri lanka":                              "lk",
+	"st. barthélemy":                         "bl",
+	"st. helena":                             "sh",
+	"st. kitts & nevis":                      "kn",
+	"st. lucia":                              "lc",
+	"st. martin":                             "mf",
+	"st. pierre & miquelon":                  "pm",
+	"st. vincent & grenadines":               "vc",
+	"sudan":                                  "sd",
+	"suriname":                               "sr",
+	"svalbard & jan mayen":                   "sj",
+	"swaziland":                              "sz",
+	"sweden":                                 "se",
+	"switzerland":                            "ch",
+	"syria":                                  "sy",
+	"são tomé & príncipe":                    "st",
+	"taiwan":                                 "tw",
+	"tajikistan":                             "tj",
+	"tanzania":                               "tz",
+	"thailand":                               "th",
+	"timor-leste":                            "tl",
+	"togo":                                   "tg",
+	"tokelau":                                "tk",
+	"tonga":                                  "to",
+	"trinidad & tobago":                      "tt",
+	"tristan da cunha":                       "ta",
+	"tunisia":                                "tn",
+	"turkey":                                 "tr",
+	"turkmenistan":                           "tm",
+	"turks & caicos islands":                 "tc",
+	"tuvalu":                                 "tv",
+	"u.s. outlying islands":                  "um",
+	"u.s. virgin islands":                    "vi",
+	"uganda":                                 "ug",
+	"ukraine":                                "ua",
+	"united arab emirates":                   "ae",
+	"united kingdom":                         "gb",
+	"united states":                          "us",
+	"uruguay":                                "uy",
+	"uzbekistan":                             "uz",
+	"vanuatu":                                "vu",
+	"vatican city":                           "va",
+	"venezuela":                              "ve",
+	"vietnam":                                "vn",
+	"wallis & futuna":                        "wf",
+	"western sahara":                         "eh",
+	"yemen":                                  "ye",
+	"zambia":                                 "zm",
+	"zimbabwe":                               "zw",
+	"åland islands":                          "ax",
+}
@@ -0,0 +1,30 @@
+package collector
+
+import "testing"
+
+// TestCountryCode checks that countries are reported as lowercase ISO codes,
+// as by the ClientCountry field of Logpull.
+func TestCountryCode(t *testing.T) {
+	testCases := []struct {
+		condition string
+		country   string
+		expected  string
+	}{
+		{"with uppercase code", "US", "us"},
+		{"with lowercase code", "de", "de"},
+		{"with code of Tor", "T1", "t1"},
+		{"with name", "United States", "us"},
+		{"with name of other case", "south korea", "kr"},
+		{"with non-ASCII name", "Côte d’Ivoire", "ci"},
+		{"with unknown name", "Atlantis", unknownCountry},
+		{"with empty value", "", unknownCountry},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			if code := countryCode(c.country); code != c.expected {
+				t.Errorf("got %q, want %q", code, c.expected)
+			}
+		})
+	}
+}
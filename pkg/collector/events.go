@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types recorded in the event log.
+const (
+	eventPullSucceeded  = "pull_succeeded"
+	eventPullFailed     = "pull_failed"
+	eventCursorAdvanced = "cursor_advanced"
+	eventWindowSkipped  = "window_skipped"
+	eventSchemaChanged  = "schema_changed"
+)
+
+// event is a single entry of the event log. Times are formatted as RFC 3339
+// strings so that unset ones can be omitted.
+type event struct {
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	ZoneID  string `json:"zone_id,omitempty"`
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	Entries int    `json:"entries,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Duration is the time taken by a pull, in seconds, and ResponseBytes
+	// the number of bytes returned to clients by the requests it covered.
+	Duration      float64 `json:"duration_seconds,omitempty"`
+	ResponseBytes int64   `json:"response_bytes,omitempty"`
+
+	// Fields lists the fields added to, prefixed with "+", or removed
+	// from, prefixed with "-", the logs of a zone.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// newWindowEvent creates an event of the given type for a window of a zone's
+// logs.
+func newWindowEvent(typ, zoneID string, start, end time.Time) event {
+	return event{
+		Type:   typ,
+		ZoneID: zoneID,
+		Start:  start.UTC().Format(time.RFC3339),
+		End:    end.UTC().Format(time.RFC3339),
+	}
+}
+
+// EventLog writes a machine-readable record of what the exporter did as
+// newline-delimited JSON, so that operators can reconstruct its behavior
+// during an incident. It is safe for concurrent use.
+type EventLog struct {
+	mu           sync.Mutex
+	w            io.Writer
+	errorHandler func(error)
+}
+
+// NewEventLog creates a new EventLog writing to w. Errors writing events are
+// passed to errorHandler.
+func NewEventLog(w io.Writer, errorHandler func(error)) *EventLog {
+	return &EventLog{
+		w:            w,
+		errorHandler: errorHandler,
+	}
+}
+
+// record writes the given event, setting its time to now.
+func (l *EventLog) record(e event) {
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		l.errorHandler(fmt.Errorf("encoding event: %w", err))
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		l.errorHandler(fmt.Errorf("writing event: %w", err))
+	}
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestEventLog checks that the collector records its pulls to the event log
+// as newline-delimited JSON.
+func TestEventLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "bad-zone") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"good-zone", "bad-zone"}, time.Minute, func(error) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	c.SetEventLog(NewEventLog(&buf, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	}))
+	c.SetIncremental(true)
+
+	testutil.CollectAndCount(c)
+
+	types := make(map[string]event)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		types[e.ZoneID+" "+e.Type] = e
+	}
+
+	if e, ok := types["good-zone "+eventPullSucceeded]; !ok || e.Entries != 1 {
+		t.Errorf("expected %s event with one entry, got %v", eventPullSucceeded, types)
+	}
+
+	if _, ok := types["good-zone "+eventCursorAdvanced]; !ok {
+		t.Errorf("expected %s event, got %v", eventCursorAdvanced, types)
+	}
+
+	if e, ok := types["bad-zone "+eventPullFailed]; !ok || e.Error == "" {
+		t.Errorf("expected %s event with error, got %v", eventPullFailed, types)
+	}
+}
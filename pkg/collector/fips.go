@@ -0,0 +1,11 @@
+//go:build !boringcrypto && !goexperiment.boringcrypto
+// +build !boringcrypto,!goexperiment.boringcrypto
+
+package collector
+
+// FIPSEnabled reports whether cryptography is performed by a FIPS 140-2
+// validated module. It is always false, unless the exporter is built with a
+// BoringCrypto toolchain; see fips_boring.go.
+func FIPSEnabled() bool {
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build boringcrypto || goexperiment.boringcrypto
+// +build boringcrypto goexperiment.boringcrypto
+
+package collector
+
+import "crypto/boring"
+
+// FIPSEnabled reports whether cryptography is performed by a FIPS 140-2
+// validated module, which is the case when the exporter is built with a
+// BoringCrypto toolchain on a supported platform. BoringCrypto runs its
+// self-tests when the process starts, and aborts it if they fail.
+func FIPSEnabled() bool {
+	return boring.Enabled()
+}
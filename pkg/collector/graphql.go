@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/cfgraphql"
+)
+
+// graphqlDimensions maps the Logpull fields available from the GraphQL
+// Analytics API to the dimensions of its httpRequestsAdaptiveGroups dataset.
+var graphqlDimensions = map[string]string{
+	"CacheCacheStatus":      "cacheStatus",
+	"ClientASN":             "clientAsn",
+	"ClientCountry":         "clientCountryName",
+	"ClientDeviceType":      "clientDeviceType",
+	"ClientRequestHost":     "clientRequestHTTPHost",
+	"ClientRequestMethod":   "clientRequestHTTPMethodName",
+	"ClientRequestProtocol": "clientRequestHTTPProtocol",
+	"ClientSSLProtocol":     "clientSSLProtocol",
+	"EdgeColoCode":          "coloCode",
+	"EdgeResponseStatus":    "edgeResponseStatus",
+	"OriginResponseStatus":  "originResponseStatus",
+}
+
+// requestGroup is a group of HTTP requests of a zone, as aggregated by the
+// GraphQL Analytics API. Its fields hold the values of the requested Logpull
+// fields shared by the requests, formatted as by LogEntry.Field.
+type requestGroup struct {
+	Count         float64
+	ResponseBytes float64
+	Fields        map[string]string
+}
+
+// pullRequestGroupsContext returns the HTTP requests of the given zone from
+// start to end, grouped by the given Logpull fields, using the given GraphQL
+// Analytics API client. Fields without an equivalent dimension are left out
+// of the groups, and countries are reported as ISO codes, as by Logpull.
+func pullRequestGroupsContext(ctx context.Context, client *cfgraphql.Client, zoneID string, start, end time.Time, fields []string) ([]requestGroup, error) {
+	var dimensions []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		if dimension, ok := graphqlDimensions[field]; ok && !seen[dimension] {
+			dimensions = append(dimensions, dimension)
+			seen[dimension] = true
+		}
+	}
+
+	groups, err := client.RequestGroups(ctx, zoneID, start, end, dimensions)
+	if err != nil {
+		return nil, graphqlError(err)
+	}
+
+	requestGroups := make([]requestGroup, len(groups))
+	for i, g := range groups {
+		requestGroups[i] = requestGroup{
+			Count:         g.Count,
+			ResponseBytes: g.ResponseBytes,
+			Fields:        make(map[string]string, len(dimensions)),
+		}
+		for _, field := range fields {
+			if v, ok := g.Dimensions[graphqlDimensions[field]]; ok {
+				if field == "ClientCountry" {
+					v = countryCode(v)
+				}
+				requestGroups[i].Fields[field] = v
+			}
+		}
+	}
+	return requestGroups, nil
+}
+
+// pullFirewallEventsContext returns the firewall events of the given zone from
+// start to end, using the given GraphQL Analytics API client, counted by
+// action and source as by the firewall event metrics of Logpull. The sources
+// are lowercased, such as firewallrules rather than firewallRules, to match
+// those of Logpull.
+func pullFirewallEventsContext(ctx context.Context, client *cfgraphql.Client, zoneID string, start, end time.Time) (map[string]float64, error) {
+	groups, err := client.FirewallEventGroups(ctx, zoneID, start, end, []string{"action", "source"})
+	if err != nil {
+		return nil, graphqlError(err)
+	}
+
+	events := make(map[string]float64)
+	for _, g := range groups {
+		events[g.Dimensions["action"]+labelValueSeparator+strings.ToLower(g.Dimensions["source"])] += g.Count
+	}
+	return events, nil
+}
+
+// graphqlError returns the given error of the GraphQL Analytics API client,
+// with malformed responses reported as decode errors.
+func graphqlError(err error) error {
+	var decodeErr *cfgraphql.DecodeError
+	if errors.As(err, &decodeErr) {
+		return &decodeError{err}
+	}
+	return err
+}
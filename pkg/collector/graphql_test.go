@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/cfgraphql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// graphqlResponse is a GraphQL Analytics API response with two groups of
+// requests of goodZoneID.
+const graphqlResponse = `{"data":{"viewer":{"zones":[{"httpRequestsAdaptiveGroups":[
+{"count":120,"sum":{"edgeResponseBytes":4800},"dimensions":{"clientRequestHTTPHost":"example.org","edgeResponseStatus":200,"originResponseStatus":200}},
+{"count":3,"sum":{"edgeResponseBytes":90},"dimensions":{"clientRequestHTTPHost":"example.org","edgeResponseStatus":502,"originResponseStatus":0}}
+]}]}},"errors":null}`
+
+// graphqlFirewallResponse is a GraphQL Analytics API response with two groups
+// of firewall events of goodZoneID.
+const graphqlFirewallResponse = `{"data":{"viewer":{"zones":[{"firewallEventsAdaptiveGroups":[
+{"count":7,"dimensions":{"action":"block","source":"waf"}},
+{"count":2,"dimensions":{"action":"log","source":"firewallRules"}}
+]}]}},"errors":null}`
+
+// graphqlHandler returns a handler serving the given GraphQL Analytics API
+// response to queries of HTTP requests, and graphqlFirewallResponse to those
+// of firewall events, after checking the request.
+func graphqlHandler(t *testing.T, response string) http.Handler {
+	return mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost || r.URL.Path != "/graphql" {
+			return fmt.Errorf("called unexpected endpoint: %s %s", r.Method, r.URL.Path)
+		}
+
+		var body struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return err
+		}
+		if body.Variables["zoneTag"] != goodZoneID {
+			return fmt.Errorf("unexpected zone: %v", body.Variables["zoneTag"])
+		}
+		if strings.Contains(body.Query, "firewallEventsAdaptiveGroups") {
+			_, err := w.Write([]byte(graphqlFirewallResponse))
+			return err
+		}
+		if !strings.Contains(body.Query, "dimensions { edgeResponseStatus clientRequestHTTPHost originResponseStatus }") {
+			return fmt.Errorf("unexpected query: %s", body.Query)
+		}
+
+		_, err := w.Write([]byte(response))
+		return err
+	})
+}
+
+// newTestGraphQLClient returns a GraphQL Analytics API client of the given
+// server.
+func newTestGraphQLClient(t *testing.T, ts *httptest.Server) *cfgraphql.Client {
+	client, err := cfgraphql.New(cfgraphql.Credentials{APIKey: goodKey, APIEmail: goodEmail}, cfgraphql.WithBaseURL(ts.URL), cfgraphql.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return client
+}
+
+// TestPullRequestGroups checks that pullRequestGroupsContext returns the
+// groups of requests keyed by Logpull field, and the errors of the API, with
+// malformed responses failing at the decode stage.
+func TestPullRequestGroups(t *testing.T) {
+	fields := []string{"EdgeResponseStatus", "ClientRequestHost", "OriginResponseStatus", "OriginResponseTime"}
+
+	ts := httptest.NewServer(graphqlHandler(t, graphqlResponse))
+	defer ts.Close()
+
+	groups, err := pullRequestGroupsContext(context.Background(), newTestGraphQLClient(t, ts), goodZoneID, goodStart, goodEnd, fields)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []requestGroup{
+		{Count: 120, ResponseBytes: 4800, Fields: map[string]string{"ClientRequestHost": "example.org", "EdgeResponseStatus": "200", "OriginResponseStatus": "200"}},
+		{Count: 3, ResponseBytes: 90, Fields: map[string]string{"ClientRequestHost": "example.org", "EdgeResponseStatus": "502", "OriginResponseStatus": "0"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %+v, want %+v", groups, expected)
+	}
+
+	errorServer := httptest.NewServer(graphqlHandler(t, `{"data":null,"errors":[{"message":"zone does not have access to the path"}]}`))
+	defer errorServer.Close()
+
+	_, err = pullRequestGroupsContext(context.Background(), newTestGraphQLClient(t, errorServer), goodZoneID, goodStart, goodEnd, fields)
+	if err == nil || !strings.Contains(err.Error(), "zone does not have access to the path") {
+		t.Errorf("expected error of the API, got %v", err)
+	}
+
+	malformedServer := httptest.NewServer(graphqlHandler(t, `{"data":`))
+	defer malformedServer.Close()
+
+	_, err = pullRequestGroupsContext(context.Background(), newTestGraphQLClient(t, malformedServer), goodZoneID, goodStart, goodEnd, fields)
+	if errorStage(err) != stageDecode {
+		t.Errorf("expected decode error, got %v", err)
+	}
+}
+
+// TestCollectorGraphQLZones checks that zones without log retention can be
+// collected through the GraphQL Analytics API.
+func TestCollectorGraphQLZones(t *testing.T) {
+	ts := httptest.NewServer(graphqlHandler(t, graphqlResponse))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{goodZoneID}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.SetGraphQLZones(map[string]*cfgraphql.Client{goodZoneID: newTestGraphQLClient(t, ts)})
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_http_responses Cloudflare HTTP responses, obtained via Logpull API
+		# TYPE cloudflare_logs_http_responses gauge
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="200",origin_response_status="200",period="1m",zone="` + goodZoneID + `"} 120
+		cloudflare_logs_http_responses{client_request_host="example.org",edge_response_status="502",origin_response_status="0",period="1m",zone="` + goodZoneID + `"} 3
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_http_responses"); err != nil {
+		t.Error(err)
+	}
+
+	status := c.status.snapshot()[0]
+	if status.Requests != 123 || status.ServerErrors != 3 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+// TestCollectorGraphQLFirewallEvents checks that the firewall events of zones
+// collected through the GraphQL Analytics API are reported as those of
+// Logpull, when enabled.
+func TestCollectorGraphQLFirewallEvents(t *testing.T) {
+	ts := httptest.NewServer(graphqlHandler(t, graphqlResponse))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{goodZoneID}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.SetFirewallEvents(true)
+	c.SetGraphQLZones(map[string]*cfgraphql.Client{goodZoneID: newTestGraphQLClient(t, ts)})
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_firewall_events Cloudflare firewall rule matches by action and source, obtained via Logpull API
+		# TYPE cloudflare_logs_firewall_events gauge
+		cloudflare_logs_firewall_events{action="block",period="1m",source="waf",zone="` + goodZoneID + `"} 7
+		cloudflare_logs_firewall_events{action="log",period="1m",source="firewallrules",zone="` + goodZoneID + `"} 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_firewall_events"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// HostLimiter bounds the number of distinct client_request_host label values
+// of every zone, since zones accepting arbitrary Host headers would otherwise
+// create a series for every host ever requested. Hosts which are excluded, or
+// exceed the limit, are reported as otherLabelValue instead.
+type HostLimiter struct {
+	include  *regexp.Regexp
+	exclude  *regexp.Regexp
+	maxHosts int
+
+	// hosts holds the hosts admitted so far by zone ID. Hosts are
+	// admitted in the order they are first seen and never evicted, so
+	// that the series of a host don't come and go between scrapes.
+	mu    sync.Mutex
+	hosts map[string]map[string]bool
+}
+
+// NewHostLimiter creates a new HostLimiter which reports at most maxHosts
+// hosts per zone, or any number if zero. A host is only reported if it
+// matches the regular expression include, if set, and does not match exclude,
+// if set.
+func NewHostLimiter(maxHosts int, include, exclude string) (*HostLimiter, error) {
+	if maxHosts < 0 {
+		return nil, errors.New("invalid parameter: maxHosts must not be negative")
+	}
+
+	l := &HostLimiter{maxHosts: maxHosts, hosts: make(map[string]map[string]bool)}
+	var err error
+
+	if include != "" {
+		if l.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("compiling include pattern: %w", err)
+		}
+	}
+
+	if exclude != "" {
+		if l.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("compiling exclude pattern: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// label returns the label value reporting the given host of the given zone:
+// either the host itself or otherLabelValue. Empty hosts, of log entries
+// without the ClientRequestHost field, are returned as is.
+func (l *HostLimiter) label(zoneID, host string) string {
+	if host == "" {
+		return host
+	}
+
+	if l.include != nil && !l.include.MatchString(host) || l.exclude != nil && l.exclude.MatchString(host) {
+		return otherLabelValue
+	}
+
+	if l.maxHosts == 0 {
+		return host
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hosts := l.hosts[zoneID]
+	if hosts == nil {
+		hosts = make(map[string]bool)
+		l.hosts[zoneID] = hosts
+	}
+
+	if !hosts[host] {
+		if len(hosts) >= l.maxHosts {
+			return otherLabelValue
+		}
+		hosts[host] = true
+	}
+
+	return host
+}
@@ -0,0 +1,48 @@
+package collector
+
+import (
+	"testing"
+)
+
+// TestHostLimiter checks that hosts are reported according to the patterns
+// and the limit, and that the limit applies to every zone separately.
+func TestHostLimiter(t *testing.T) {
+	l, err := NewHostLimiter(2, `\.org$`, `^staging\.`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		zoneID   string
+		host     string
+		expected string
+	}{
+		{"zone-a", "", ""},
+		{"zone-a", "example.com", otherLabelValue},
+		{"zone-a", "staging.example.org", otherLabelValue},
+		{"zone-a", "a.example.org", "a.example.org"},
+		{"zone-a", "b.example.org", "b.example.org"},
+		{"zone-a", "c.example.org", otherLabelValue},
+		{"zone-a", "a.example.org", "a.example.org"},
+		{"zone-b", "c.example.org", "c.example.org"},
+	}
+
+	for _, c := range testCases {
+		if got := l.label(c.zoneID, c.host); got != c.expected {
+			t.Errorf("label(%q, %q) = %q, want %q", c.zoneID, c.host, got, c.expected)
+		}
+	}
+}
+
+// TestHostLimiterErrors checks that invalid parameters are rejected.
+func TestHostLimiterErrors(t *testing.T) {
+	if _, err := NewHostLimiter(-1, "", ""); err == nil {
+		t.Error("expected error when called with negative limit")
+	}
+	if _, err := NewHostLimiter(0, "(", ""); err == nil {
+		t.Error("expected error when called with invalid include pattern")
+	}
+	if _, err := NewHostLimiter(0, "", "("); err == nil {
+		t.Error("expected error when called with invalid exclude pattern")
+	}
+}
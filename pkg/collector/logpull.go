@@ -0,0 +1,1499 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"golang.org/x/time/rate"
+)
+
+// defaultBaseURL is the base URL for all API calls, unless explicitly
+// overridden by the client.
+const defaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// defaultUserAgent is the User-Agent header of all API calls, unless
+// explicitly overridden by the client.
+const defaultUserAgent = "cloudflare-logpull-exporter"
+
+// maxLogLineSize is the maximum length of a single log entry in a Logpull API
+// response. Entries with the fields requested by the exporter are a few
+// hundred bytes long.
+const maxLogLineSize = 64 * 1024
+
+// ErrLogRetentionDisabled is returned by pullLogEntries when log retention is
+// not enabled for the requested zone.
+var ErrLogRetentionDisabled = errors.New("log retention is disabled")
+
+// errLogEntryNotFound is returned by pullLogEntryByRayIDContext when there is
+// no log entry for the requested Ray ID.
+var errLogEntryNotFound = errors.New("log entry not found")
+
+// authType represents the various Cloudflare API authentication schemes
+type authType int
+
+const (
+	// authKeyEmail specifies that we should authenticate with API key and email address
+	authKeyEmail authType = iota
+	// authUserService specifies that we should authenticate with a User-Service key
+	authUserService
+	// authToken specifies that we should authenticate with an API token
+	authToken
+)
+
+// LogEntry contains all of the fields we care about from Cloudflare Logpull
+// API response data. It is the target type of JSON unmarshaling. Fields which
+// were not requested are left at their zero value.
+type LogEntry struct {
+	ClientRequestHost     string       `json:"ClientRequestHost"`
+	EdgeResponseStatus    int          `json:"EdgeResponseStatus"`
+	OriginResponseStatus  int          `json:"OriginResponseStatus"`
+	JA3Hash               string       `json:"JA3Hash"`
+	ClientASN             int          `json:"ClientASN"`
+	CacheCacheStatus      string       `json:"CacheCacheStatus"`
+	ClientCountry         string       `json:"ClientCountry"`
+	ClientDeviceType      string       `json:"ClientDeviceType"`
+	ClientRequestMethod   string       `json:"ClientRequestMethod"`
+	ClientRequestProtocol string       `json:"ClientRequestProtocol"`
+	ClientRequestURI      string       `json:"ClientRequestURI"`
+	ClientSSLProtocol     string       `json:"ClientSSLProtocol"`
+	EdgeColoCode          string       `json:"EdgeColoCode"`
+	EdgeResponseBytes     int          `json:"EdgeResponseBytes"`
+	OriginResponseTime    int64        `json:"OriginResponseTime"`
+	CacheTieredFill       bool         `json:"CacheTieredFill"`
+	EdgeEndTimestamp      logTimestamp `json:"EdgeEndTimestamp"`
+	RayID                 string       `json:"RayID"`
+	BotScore              int          `json:"BotScore"`
+	BotScoreSrc           string       `json:"BotScoreSrc"`
+
+	// The firewall fields are parallel arrays, with one element per
+	// firewall rule that matched the request.
+	FirewallMatchesActions []string `json:"FirewallMatchesActions"`
+	FirewallMatchesSources []string `json:"FirewallMatchesSources"`
+	FirewallMatchesRuleIDs []string `json:"FirewallMatchesRuleIDs"`
+
+	// The custom fields are objects holding the request headers, response
+	// headers and cookies configured to be logged for the zone, keyed by
+	// name.
+	RequestHeaders  map[string]string `json:"RequestHeaders"`
+	ResponseHeaders map[string]string `json:"ResponseHeaders"`
+	Cookies         map[string]string `json:"Cookies"`
+
+	// sampleRate is the fraction of log entries returned by the pull
+	// which returned the entry, if sampled, which may be lower than the
+	// configured rate for oversized windows.
+	sampleRate float64
+}
+
+// weight returns the number of requests the log entry stands for, which is
+// more than one for sampled entries.
+func (e LogEntry) weight() float64 {
+	if e.sampleRate == 0 {
+		return 1
+	}
+	return 1 / e.sampleRate
+}
+
+// logTimestamp is a timestamp field of a log entry, such as EdgeEndTimestamp,
+// in Unix nanoseconds once the entry has been passed to a logHandler. Logpull
+// returns timestamps as RFC 3339 strings, or as numbers of Unix seconds or
+// nanoseconds, depending on the timestamps parameter of the request; strings
+// are converted when decoded, and seconds by the client which requested them.
+type logTimestamp int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *logTimestamp) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return err
+		}
+		*t = logTimestamp(parsed.UnixNano())
+		return nil
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*t = logTimestamp(n)
+	return nil
+}
+
+// logEntryFieldIndex maps the Logpull field names supported by LogEntry to
+// the index of the corresponding struct field.
+var logEntryFieldIndex = func() map[string]int {
+	t := reflect.TypeOf(LogEntry{})
+	index := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			index[t.Field(i).Tag.Get("json")] = i
+		}
+	}
+	return index
+}()
+
+// splitField splits the name of a value nested in an object field, such as
+// "RequestHeaders.user-agent", into the name of the Logpull field and the key
+// of the value. The key is empty for other fields.
+func splitField(name string) (field, key string) {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// isLogEntryField reports whether the named Logpull field is supported by
+// LogEntry. Object fields are only supported along with the key of a value,
+// as in "RequestHeaders.user-agent".
+func isLogEntryField(name string) bool {
+	field, key := splitField(name)
+	i, ok := logEntryFieldIndex[field]
+	if !ok {
+		return false
+	}
+
+	isObject := reflect.TypeOf(LogEntry{}).Field(i).Type.Kind() == reflect.Map
+	return isObject == (key != "")
+}
+
+// logpullField returns the name of the Logpull field to request for the named
+// field, which may be a value nested in an object field.
+func logpullField(name string) string {
+	field, _ := splitField(name)
+	return field
+}
+
+// Field returns the value of the named Logpull field, or of the value nested
+// in an object field, formatted for use as a Prometheus label value. It
+// returns an empty string if the field is not supported by LogEntry, or the
+// nested value is missing. It implements aggregator.Entry.
+func (e LogEntry) Field(name string) string {
+	field, key := splitField(name)
+	i, ok := logEntryFieldIndex[field]
+	if !ok {
+		return ""
+	}
+
+	switch v := reflect.ValueOf(e).Field(i); v.Kind() {
+	case reflect.Map:
+		if key == "" {
+			return ""
+		}
+		if value := v.MapIndex(reflect.ValueOf(key)); value.IsValid() {
+			return value.String()
+		}
+		return ""
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Slice:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = v.Index(i).String()
+		}
+		return strings.Join(values, ",")
+	default:
+		return v.String()
+	}
+}
+
+// The API will only return the requested fields; thus, if we add or remove
+// fields from the LogEntry struct definition, we'll also want to make sure we
+// update these lists to ask the API for the same.
+var (
+	// defaultLogFields are the fields requested when none are specified.
+	defaultLogFields = []string{
+		"ClientRequestHost",
+		"EdgeResponseStatus",
+		"OriginResponseStatus",
+	}
+
+	// ja3LogFields are the fields needed for JA3 fingerprint metrics. They
+	// are only populated for zones with Bot Management enabled.
+	ja3LogFields = []string{
+		"JA3Hash",
+	}
+
+	// bytesLogFields are the fields needed for response bytes metrics.
+	bytesLogFields = []string{
+		"EdgeResponseBytes",
+	}
+
+	// cacheLogFields are the fields needed for cache status metrics.
+	cacheLogFields = []string{
+		"ClientRequestHost",
+		"CacheCacheStatus",
+	}
+
+	// originDurationLogFields are the fields needed for origin response
+	// duration metrics.
+	originDurationLogFields = []string{
+		"ClientRequestHost",
+		"OriginResponseStatus",
+		"OriginResponseTime",
+	}
+
+	// tieredCacheLogFields are the fields needed for tiered cache metrics.
+	tieredCacheLogFields = []string{
+		"CacheTieredFill",
+		"OriginResponseStatus",
+	}
+
+	// firewallLogFields are the fields needed for firewall event metrics.
+	firewallLogFields = []string{
+		"FirewallMatchesActions",
+		"FirewallMatchesSources",
+	}
+
+	// botLogFields are the fields needed for bot score metrics. They are
+	// only populated for zones with Bot Management enabled.
+	botLogFields = []string{
+		"BotScore",
+		"BotScoreSrc",
+	}
+
+	// methodLogFields are the fields needed for the method label of
+	// endpoint metrics.
+	methodLogFields = []string{
+		"ClientRequestMethod",
+	}
+
+	// pathLogFields are the fields needed for the path group label of
+	// endpoint metrics.
+	pathLogFields = []string{
+		"ClientRequestURI",
+	}
+
+	// asnLogFields are the fields needed for per-ASN metrics.
+	asnLogFields = []string{
+		"ClientASN",
+	}
+
+	// countryLogFields are the fields needed for per-country metrics.
+	countryLogFields = []string{
+		"ClientCountry",
+	}
+
+	// coloLogFields are the fields needed for per-colo metrics.
+	coloLogFields = []string{
+		"EdgeColoCode",
+	}
+
+	// resumeLogFields are the fields requested on every pull, so that
+	// interrupted downloads can be resumed at the timestamp of the last
+	// received entry, skipping the entries of that timestamp which were
+	// already received by their Ray ID. Logpull returns EdgeEndTimestamp
+	// in the format set by WithTimestamps.
+	resumeLogFields = []string{
+		"EdgeEndTimestamp",
+		"RayID",
+	}
+)
+
+// LogpullAPI is a minimal Cloudflare API client to handle Cloudflare's Logpull
+// API endpoint. This is needed because the official Cloudflare API client does
+// not support this endpoint yet.
+type LogpullAPI struct {
+	// The counters are accessed atomically, and are the first fields so
+	// that they are 64-bit aligned on 32-bit platforms.
+	retries     uint64
+	rateLimited uint64
+	stalls      uint64
+	resumes     uint64
+
+	// pausedUntil is the time, in Unix nanoseconds, until which no
+	// requests are sent after the API responded with HTTP 429. It is
+	// accessed atomically.
+	pausedUntil int64
+
+	// authMu guards the credentials, which may be replaced while the
+	// client is in use.
+	authMu         sync.RWMutex
+	authType       authType
+	apiKey         string
+	apiEmail       string
+	apiToken       string
+	apiUserService string
+
+	httpClient   *http.Client
+	baseURL      string
+	userAgent    string
+	maxRetries   int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	limiter      *rate.Limiter
+	sample       float64
+	stallTimeout time.Duration
+	maxBytes     int64
+	oversize     string
+	timestamps   string
+	requestHook  requestHook
+	oversizeHook oversizeHook
+
+	// clients holds the HTTP clients of the groups of zones isolated from
+	// each other, by group, if newClient is set.
+	newClient   func() *http.Client
+	clientGroup func(zoneID string) string
+	clientsMu   sync.Mutex
+	clients     map[string]*http.Client
+}
+
+// requestHook is a function which is called once for every attempted API
+// request, when its response body has been closed. status is the HTTP status
+// code of the response, or "error" if none was received, and size is the
+// size of the response body as transferred, before decompression.
+type requestHook func(status string, duration time.Duration, size int64)
+
+// oversizeHook is a function which is called when the log download of the
+// given window of a zone is larger than the size budget, before the given
+// action is taken.
+type oversizeHook func(zoneID string, start, end time.Time, size int64, action string)
+
+// The actions taken on log downloads larger than the size budget.
+const (
+	OversizeSkip   = "skip"
+	OversizeSplit  = "split"
+	OversizeSample = "sample"
+)
+
+// The formats of the timestamps of log entries, as requested by the
+// timestamps parameter of Logpull.
+const (
+	TimestampsRFC3339  = "rfc3339"
+	TimestampsUnix     = "unix"
+	TimestampsUnixNano = "unixnano"
+)
+
+// LogpullOption configures a Logpull API client on creation.
+type LogpullOption func(*LogpullAPI) error
+
+// NewLogpullAPI creates a new Logpull API client from an API key and email
+// address. Returns an error if any option is invalid.
+func NewLogpullAPI(key, email string, opts ...LogpullOption) (*LogpullAPI, error) {
+	return newLogpullAPIWithAuth(&LogpullAPI{
+		authType: authKeyEmail,
+		apiKey:   key,
+		apiEmail: email,
+	}, opts)
+}
+
+// NewLogpullAPIWithToken creates a new Logpull API client from an API token.
+// Returns an error if any option is invalid.
+func NewLogpullAPIWithToken(token string, opts ...LogpullOption) (*LogpullAPI, error) {
+	return newLogpullAPIWithAuth(&LogpullAPI{
+		authType: authToken,
+		apiToken: token,
+	}, opts)
+}
+
+// newLogpullAPIWithUserServiceKey creates a new Logpull API client from a
+// User-Service key. Returns an error if any option is invalid.
+func newLogpullAPIWithUserServiceKey(key string, opts ...LogpullOption) (*LogpullAPI, error) {
+	return newLogpullAPIWithAuth(&LogpullAPI{
+		authType:       authUserService,
+		apiUserService: key,
+	}, opts)
+}
+
+// NewLogpullAPIFromCloudflare creates a new Logpull API client sharing the
+// credentials and base URL of the given Cloudflare API client, so that both
+// are always configured alike. The retry policy and HTTP client of a
+// cloudflare.API can't be read, and must be given as options. Returns an
+// error if cfapi has no credentials or any option is invalid.
+func NewLogpullAPIFromCloudflare(cfapi *cloudflare.API, opts ...LogpullOption) (*LogpullAPI, error) {
+	if cfapi == nil {
+		return nil, errors.New("invalid parameter: cfapi must not be nil")
+	}
+
+	var api *LogpullAPI
+	var err error
+	switch {
+	case cfapi.APIToken != "":
+		api, err = NewLogpullAPIWithToken(cfapi.APIToken, opts...)
+	case cfapi.APIKey != "":
+		api, err = NewLogpullAPI(cfapi.APIKey, cfapi.APIEmail, opts...)
+	case cfapi.APIUserServiceKey != "":
+		api, err = newLogpullAPIWithUserServiceKey(cfapi.APIUserServiceKey, opts...)
+	default:
+		return nil, errors.New("invalid parameter: cfapi has no credentials")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfapi.BaseURL != "" {
+		api.baseURL = cfapi.BaseURL
+	}
+	return api, nil
+}
+
+// SetCredentials replaces the credentials of the client with those of the
+// given Cloudflare API client while the client is in use, e.g. once they were
+// rotated. Returns an error if cfapi has no credentials.
+func (api *LogpullAPI) SetCredentials(cfapi *cloudflare.API) error {
+	if cfapi == nil {
+		return errors.New("invalid parameter: cfapi must not be nil")
+	}
+
+	var t authType
+	switch {
+	case cfapi.APIToken != "":
+		t = authToken
+	case cfapi.APIKey != "":
+		t = authKeyEmail
+	case cfapi.APIUserServiceKey != "":
+		t = authUserService
+	default:
+		return errors.New("invalid parameter: cfapi has no credentials")
+	}
+
+	api.authMu.Lock()
+	defer api.authMu.Unlock()
+
+	api.authType = t
+	api.apiToken = cfapi.APIToken
+	api.apiKey = cfapi.APIKey
+	api.apiEmail = cfapi.APIEmail
+	api.apiUserService = cfapi.APIUserServiceKey
+	return nil
+}
+
+// newLogpullAPIWithAuth sets the defaults of the given client, which only has
+// its credentials set, and applies the given options to it.
+func newLogpullAPIWithAuth(api *LogpullAPI, opts []LogpullOption) (*LogpullAPI, error) {
+	api.httpClient = http.DefaultClient
+	api.baseURL = defaultBaseURL
+	api.userAgent = defaultUserAgent
+
+	for _, opt := range opts {
+		if err := opt(api); err != nil {
+			return nil, err
+		}
+	}
+
+	return api, nil
+}
+
+// WithHTTPClient makes the client send requests with the given HTTP client,
+// instead of http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if httpClient == nil {
+			return errors.New("invalid parameter: httpClient must not be nil")
+		}
+
+		api.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithClientIsolation makes the client send the requests concerning every
+// group of zones with its own HTTP client, created by newClient, so that a
+// group exhausting the connections of its client, e.g. through a misbehaving
+// proxy, doesn't hold up the requests of the others. group returns the group
+// of a zone, such as the zone itself or its account. Requests concerning no
+// zone are sent with the default HTTP client.
+func WithClientIsolation(newClient func() *http.Client, group func(zoneID string) string) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if newClient == nil || group == nil {
+			return errors.New("invalid parameter: newClient and group must not be nil")
+		}
+
+		api.newClient = newClient
+		api.clientGroup = group
+		api.clients = make(map[string]*http.Client)
+		return nil
+	}
+}
+
+// WithBaseURL makes the client send requests to a nonstandard base URL,
+// instead of defaultBaseURL.
+func WithBaseURL(baseURL string) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if baseURL == "" {
+			return errors.New("invalid parameter: baseURL must not be empty")
+		}
+
+		api.baseURL = baseURL
+		return nil
+	}
+}
+
+// withUserAgent sets the User-Agent header of requests, instead of
+// defaultUserAgent.
+func withUserAgent(userAgent string) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if userAgent == "" {
+			return errors.New("invalid parameter: userAgent must not be empty")
+		}
+
+		api.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithRetry configures how many times failed API requests are retried, and
+// the minimum and maximum delay between attempts. Only network errors, rate
+// limiting and server errors are retried, and only before any log entries
+// have been passed to the caller. By default, requests are not retried.
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if maxRetries < 0 {
+			return errors.New("invalid parameter: maxRetries must not be negative")
+		}
+
+		if minBackoff <= 0 || maxBackoff < minBackoff {
+			return errors.New("invalid parameter: backoff must be positive, with minBackoff no greater than maxBackoff")
+		}
+
+		api.maxRetries = maxRetries
+		api.minBackoff = minBackoff
+		api.maxBackoff = maxBackoff
+		return nil
+	}
+}
+
+// WithRateLimit limits the rate of API requests to rps requests per second,
+// with bursts of up to burst requests, across all concurrent pulls. A rate of
+// zero disables the limit, which is the default.
+func WithRateLimit(rps float64, burst int) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if rps < 0 {
+			return errors.New("invalid parameter: rps must not be negative")
+		}
+
+		if burst < 1 {
+			return errors.New("invalid parameter: burst must be at least 1")
+		}
+
+		api.limiter = nil
+		if rps > 0 {
+			api.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		}
+		return nil
+	}
+}
+
+// WithSample makes pulls return only the given fraction of log entries,
+// chosen at random by Cloudflare, to reduce the amount of data transferred
+// for busy zones. A rate of 1 returns all log entries, which is the default.
+func WithSample(rate float64) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if rate < 0.001 || rate > 1 {
+			return errors.New("invalid parameter: rate must be between 0.001 and 1")
+		}
+
+		api.sample = rate
+		return nil
+	}
+}
+
+// WithStallTimeout aborts log downloads when no data has been received for
+// the given duration, instead of waiting for TCP timeouts. Stalled downloads
+// are retried according to the retry policy if no log entries have been
+// passed to the caller yet. A timeout of zero disables the check, which is
+// the default.
+func WithStallTimeout(timeout time.Duration) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if timeout < 0 {
+			return errors.New("invalid parameter: timeout must not be negative")
+		}
+
+		api.stallTimeout = timeout
+		return nil
+	}
+}
+
+// WithSizeBudget makes pulls compare the size of log downloads, when the API
+// provides a Content-Length, against maxBytes before reading them. Larger
+// downloads are skipped, failing the pull; split into halves of the window,
+// down to windows of one second; or sampled at a rate at which they are
+// expected to fit, down to the minimum rate of 0.001, depending on action.
+// Downloads which can't be split or sampled further are skipped. A budget of
+// zero disables the check, which is the default.
+func WithSizeBudget(maxBytes int64, action string) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if maxBytes < 0 {
+			return errors.New("invalid parameter: maxBytes must not be negative")
+		}
+
+		if action != OversizeSkip && action != OversizeSplit && action != OversizeSample {
+			return fmt.Errorf("invalid parameter: action must be %q, %q or %q", OversizeSkip, OversizeSplit, OversizeSample)
+		}
+
+		api.maxBytes = maxBytes
+		api.oversize = action
+		return nil
+	}
+}
+
+// WithTimestamps makes pulls request the timestamps of log entries in the
+// given format: rfc3339, unix or unixnano, the default of Logpull. Whatever
+// the format, they are passed to handlers in Unix nanoseconds, but are only
+// as precise as the format: rfc3339 and unix timestamps are truncated to the
+// second, with which interrupted downloads are still resumed correctly.
+func WithTimestamps(format string) LogpullOption {
+	return func(api *LogpullAPI) error {
+		if format != TimestampsRFC3339 && format != TimestampsUnix && format != TimestampsUnixNano {
+			return fmt.Errorf("invalid parameter: format must be %q, %q or %q", TimestampsRFC3339, TimestampsUnix, TimestampsUnixNano)
+		}
+
+		api.timestamps = format
+		return nil
+	}
+}
+
+// normalizeTimestamps converts the timestamps of the given log entry, as
+// returned by Logpull in the format requested by the client, to Unix
+// nanoseconds.
+func (api *LogpullAPI) normalizeTimestamps(entry *LogEntry) {
+	if api.timestamps == TimestampsUnix {
+		entry.EdgeEndTimestamp *= logTimestamp(time.Second)
+	}
+}
+
+// client returns the HTTP client of requests concerning the given zone.
+func (api *LogpullAPI) client(zoneID string) *http.Client {
+	if api.newClient == nil || zoneID == "" {
+		return api.httpClient
+	}
+
+	group := api.clientGroup(zoneID)
+
+	api.clientsMu.Lock()
+	defer api.clientsMu.Unlock()
+
+	client, ok := api.clients[group]
+	if !ok {
+		client = api.newClient()
+		api.clients[group] = client
+	}
+	return client
+}
+
+// sampleRate returns the fraction of log entries returned by pulls.
+func (api *LogpullAPI) sampleRate() float64 {
+	if api.sample == 0 {
+		return 1
+	}
+	return api.sample
+}
+
+// SetRequestHook sets a function to be called for every attempted API
+// request, e.g. to instrument the client. A nil hook disables it, which is
+// the default.
+func (api *LogpullAPI) SetRequestHook(hook requestHook) {
+	api.requestHook = hook
+}
+
+// SetOversizeHook sets a function to be called for every log download larger
+// than the size budget. A nil hook disables it, which is the default.
+func (api *LogpullAPI) SetOversizeHook(hook oversizeHook) {
+	api.oversizeHook = hook
+}
+
+// retryCount returns the number of API requests retried so far.
+func (api *LogpullAPI) retryCount() uint64 {
+	return atomic.LoadUint64(&api.retries)
+}
+
+// stallCount returns the number of log downloads aborted because they
+// stalled so far.
+func (api *LogpullAPI) stallCount() uint64 {
+	return atomic.LoadUint64(&api.stalls)
+}
+
+// resumeCount returns the number of interrupted log downloads resumed after
+// the last received log entry so far.
+func (api *LogpullAPI) resumeCount() uint64 {
+	return atomic.LoadUint64(&api.resumes)
+}
+
+// rateLimitedCount returns the number of API requests rejected with HTTP 429
+// so far.
+func (api *LogpullAPI) rateLimitedCount() uint64 {
+	return atomic.LoadUint64(&api.rateLimited)
+}
+
+// wait blocks until a request may be sent according to the rate limit and
+// any pause requested by the API, or until ctx is done.
+func (api *LogpullAPI) wait(ctx context.Context) error {
+	if d := time.Until(time.Unix(0, atomic.LoadInt64(&api.pausedUntil))); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+
+	if api.limiter != nil {
+		return api.limiter.Wait(ctx)
+	}
+	return nil
+}
+
+// pause delays all requests until the given time, unless they are already
+// delayed for longer.
+func (api *LogpullAPI) pause(until time.Time) {
+	for {
+		current := atomic.LoadInt64(&api.pausedUntil)
+		if until.UnixNano() <= current || atomic.CompareAndSwapInt64(&api.pausedUntil, current, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+// logHandler is a function which is called by pullLogEntries for each parsed
+// log entry.
+type logHandler func(LogEntry) error
+
+// pullLogEntries makes a request to Cloudflare's Logpull API, requesting the
+// given fields of log entries for the given zoneID between the given start and
+// end time. If fields is empty, defaultLogFields is used. Each entry is parsed
+// into a LogEntry struct and passed to the given logHandler.
+func (api *LogpullAPI) pullLogEntries(zoneID string, start, end time.Time, fields []string, handler logHandler) error {
+	return api.pullLogEntriesContext(context.Background(), zoneID, start, end, fields, handler)
+}
+
+// pullLogEntriesContext is like pullLogEntries, but aborts the request when
+// the given context is done.
+func (api *LogpullAPI) pullLogEntriesContext(ctx context.Context, zoneID string, start, end time.Time, fields []string, handler logHandler) error {
+	if len(fields) == 0 {
+		fields = defaultLogFields
+	}
+	for _, field := range resumeLogFields {
+		if !containsField(fields, field) {
+			fields = append(fields[:len(fields):len(fields)], field)
+		}
+	}
+
+	// The API takes times with second precision, except when resuming.
+	start, end = start.Truncate(time.Second), end.Truncate(time.Second)
+	return api.pullWindow(ctx, zoneID, start, end, fields, api.sampleRate(), handler)
+}
+
+// pullWindow pulls the given fields of the log entries of the given window,
+// sampled at the given rate, for pullLogEntriesContext.
+func (api *LogpullAPI) pullWindow(ctx context.Context, zoneID string, start, end time.Time, fields []string, rate float64, handler logHandler) error {
+	query := "&end=" + formatLogpullTime(end)
+	query += "&fields=" + strings.Join(fields, ",")
+	if api.timestamps != "" {
+		query += "&timestamps=" + api.timestamps
+	}
+	if rate < 1 {
+		query += "&sample=" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+
+	url := api.baseURL + "/zones/" + zoneID + "/logs/received"
+	from := start
+
+	// Interrupted and stalled downloads are retried like failed requests.
+	// Logpull returns the entries of a window ordered by EdgeEndTimestamp,
+	// but several entries may share a timestamp, and the download may have
+	// been cut short between them. Once log entries have been passed to the
+	// handler, the retry therefore resumes at the timestamp of the last one
+	// received, and skips the entries of that timestamp whose Ray ID was
+	// received before, so that no entry is lost or counted twice.
+	var last logTimestamp
+	received := make(map[string]bool)
+	for attempt := 0; ; attempt++ {
+		entries := 0
+		err := api.pullLogEntriesOnce(ctx, zoneID, url+"?start="+formatLogpullTime(from)+query, func(entry LogEntry) error {
+			entries++
+			api.normalizeTimestamps(&entry)
+			if entry.EdgeEndTimestamp > last {
+				last = entry.EdgeEndTimestamp
+				received = make(map[string]bool)
+			}
+			if entry.EdgeEndTimestamp == last && entry.RayID != "" {
+				if received[entry.RayID] {
+					return nil
+				}
+				received[entry.RayID] = true
+			}
+			if rate < 1 {
+				entry.sampleRate = rate
+			}
+			return handler(entry)
+		})
+
+		var oversizeErr *oversizeError
+		if errors.As(err, &oversizeErr) {
+			return api.pullOversizedWindow(ctx, zoneID, from, end, fields, rate, handler, oversizeErr)
+		}
+
+		var stallErr *stallError
+		var readErr *readError
+		if !errors.As(err, &stallErr) && !errors.As(err, &readErr) || attempt >= api.maxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		if entries > 0 {
+			if last == 0 {
+				return err
+			}
+			from = time.Unix(0, int64(last))
+			if !from.Before(end) {
+				return nil
+			}
+			atomic.AddUint64(&api.resumes, 1)
+		}
+
+		atomic.AddUint64(&api.retries, 1)
+	}
+}
+
+// pullOversizedWindow handles the log download of the given window, which was
+// rejected with the given oversizeError, according to the size budget.
+func (api *LogpullAPI) pullOversizedWindow(ctx context.Context, zoneID string, start, end time.Time, fields []string, rate float64, handler logHandler, err *oversizeError) error {
+	action := api.oversize
+
+	// Windows are split on whole seconds, so that both halves end up in
+	// the second-precision form of formatLogpullTime.
+	half := (end.Sub(start) / 2).Truncate(time.Second)
+	if action == OversizeSplit && half < time.Second {
+		action = OversizeSkip
+	}
+
+	// Rates are rounded down to the precision of the minimum rate.
+	smaller := math.Floor(rate*float64(api.maxBytes)/float64(err.size)*1000) / 1000
+	if action == OversizeSample && smaller < 0.001 {
+		action = OversizeSkip
+	}
+
+	if api.oversizeHook != nil {
+		api.oversizeHook(zoneID, start, end, err.size, action)
+	}
+
+	switch action {
+	case OversizeSplit:
+		mid := start.Add(half)
+		if err := api.pullWindow(ctx, zoneID, start, mid, fields, rate, handler); err != nil {
+			return err
+		}
+		return api.pullWindow(ctx, zoneID, mid, end, fields, rate, handler)
+	case OversizeSample:
+		return api.pullWindow(ctx, zoneID, start, end, fields, smaller, handler)
+	default:
+		return err
+	}
+}
+
+// formatLogpullTime formats the given time as a start or end parameter of the
+// Logpull API: in RFC 3339 form with second precision, or in Unix
+// nanoseconds if it has a fractional second.
+func formatLogpullTime(t time.Time) string {
+	if t.Nanosecond() != 0 {
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// containsField reports whether fields contains the given field.
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// pullLogEntriesOnce performs a single attempt of pullLogEntriesContext. If a
+// stall timeout is set, the download is aborted with a stallError when no
+// bytes are received for that long.
+func (api *LogpullAPI) pullLogEntriesOnce(ctx context.Context, zoneID, url string, handler logHandler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := api.get(ctx, zoneID, url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if size := transferSize(resp); api.maxBytes > 0 && size > api.maxBytes {
+		return &oversizeError{size, fmt.Errorf("log download of %d bytes exceeds the size budget of %d bytes", size, api.maxBytes)}
+	}
+
+	if api.stallTimeout == 0 {
+		return DecodeLogEntries(resp.Body, handler)
+	}
+
+	body := newStallReader(resp.Body, api.stallTimeout, cancel)
+	defer body.stop()
+
+	err = DecodeLogEntries(body, handler)
+	if body.stalled() {
+		atomic.AddUint64(&api.stalls, 1)
+		return &stallError{fmt.Errorf("no data received for %s: %w", api.stallTimeout, err)}
+	}
+	return err
+}
+
+// stallReader is a reader which calls abort when no data has been read from
+// the underlying reader for the given timeout.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+// newStallReader creates a new stallReader, whose timeout starts immediately.
+func newStallReader(r io.Reader, timeout time.Duration, abort func()) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.fired, 1)
+		abort()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 && !s.stalled() {
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// stalled reports whether the timeout has expired.
+func (s *stallReader) stalled() bool {
+	return atomic.LoadInt32(&s.fired) == 1
+}
+
+// stop stops the timeout.
+func (s *stallReader) stop() {
+	s.timer.Stop()
+}
+
+// DecodeLogEntries parses newline-delimited JSON log entries from r, and
+// passes each to the given logHandler. Since the input comes from outside the
+// exporter, it is treated as untrusted: lines longer than maxLogLineSize are
+// rejected rather than buffered, numbers which don't fit their field are
+// rejected, and invalid UTF-8 in strings is replaced by U+FFFD, so that
+// entries always yield valid label values. Malformed input results in a
+// decodeError. A last line cut short by a failed read, as when the connection
+// drops in the middle of a line, results in a readError instead, so that the
+// download is resumed after the last complete entry.
+func DecodeLogEntries(r io.Reader, handler logHandler) error {
+	body := &errorReader{r: r}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 4096), maxLogLineSize)
+
+	terminated := true
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		terminated = advance > 0 && data[advance-1] == '\n'
+		return advance, token, err
+	})
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			if !terminated && body.err != nil {
+				return &readError{fmt.Errorf("reading api response body: line %d cut short: %w", line, body.err)}
+			}
+			return &decodeError{fmt.Errorf("json: line %d: %w", line, err)}
+		}
+		if err := handler(entry); err != nil {
+			return fmt.Errorf("handler: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return &decodeError{fmt.Errorf("line %d: longer than %d bytes", line+1, maxLogLineSize)}
+	} else if err != nil {
+		return &readError{fmt.Errorf("reading api response body: %w", err)}
+	}
+
+	return nil
+}
+
+// errorReader records the error of the last failed read from r, other than
+// io.EOF.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// pullLogEntryByRayIDContext requests the given fields of the log entry of
+// the request with the given Ray ID in the given zone. If fields is empty,
+// defaultLogFields is used. errLogEntryNotFound is returned if there is no
+// such log entry.
+func (api *LogpullAPI) pullLogEntryByRayIDContext(ctx context.Context, zoneID, rayID string, fields []string) (LogEntry, error) {
+	if len(fields) == 0 {
+		fields = defaultLogFields
+	}
+
+	url := api.baseURL + "/zones/" + zoneID + "/logs/rayids/" + rayID
+	url += "?fields=" + strings.Join(fields, ",")
+	if api.timestamps != "" {
+		url += "&timestamps=" + api.timestamps
+	}
+
+	resp, err := api.get(ctx, zoneID, url)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxLogLineSize+1))
+	if err != nil {
+		return LogEntry{}, fmt.Errorf("reading api response body: %w", err)
+	}
+	if len(body) > maxLogLineSize {
+		return LogEntry{}, &decodeError{fmt.Errorf("longer than %d bytes", maxLogLineSize)}
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || bytes.Equal(body, []byte("null")) {
+		return LogEntry{}, errLogEntryNotFound
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return LogEntry{}, &decodeError{fmt.Errorf("json: %w", err)}
+	}
+	api.normalizeTimestamps(&entry)
+
+	return entry, nil
+}
+
+// pullFieldsContext returns the names of the fields available in the logs of
+// the given zone, as listed by the Logpull fields endpoint, in sorted order.
+func (api *LogpullAPI) pullFieldsContext(ctx context.Context, zoneID string) ([]string, error) {
+	url := api.baseURL + "/zones/" + zoneID + "/logs/received/fields"
+
+	resp, err := api.get(ctx, zoneID, url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// The endpoint returns an object mapping field names to descriptions.
+	var descriptions map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&descriptions); err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+
+	fields := make([]string, 0, len(descriptions))
+	for name := range descriptions {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+// retentionFlag is the body of requests to, and the result of responses from,
+// the log retention endpoint.
+type retentionFlag struct {
+	Flag bool `json:"flag"`
+}
+
+// GetRetentionContext reports whether log retention is enabled for the given
+// zone. Logs can only be pulled from zones with log retention enabled.
+func (api *LogpullAPI) GetRetentionContext(ctx context.Context, zoneID string) (bool, error) {
+	url := api.baseURL + "/zones/" + zoneID + "/logs/control/retention/flag"
+
+	resp, err := api.get(ctx, zoneID, url)
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	return decodeRetentionFlag(resp)
+}
+
+// SetRetentionContext enables or disables log retention for the given zone,
+// which requires the Logs Edit permission. Logs are only retained from the
+// moment retention is enabled.
+func (api *LogpullAPI) SetRetentionContext(ctx context.Context, zoneID string, enabled bool) error {
+	url := api.baseURL + "/zones/" + zoneID + "/logs/control/retention/flag"
+
+	body, err := json.Marshal(retentionFlag{enabled})
+	if err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	resp, err := api.do(ctx, zoneID, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	flag, err := decodeRetentionFlag(resp)
+	if err != nil {
+		return err
+	}
+	if flag != enabled {
+		return fmt.Errorf("log retention flag is still %t", flag)
+	}
+
+	return nil
+}
+
+// decodeRetentionFlag decodes the response of the log retention endpoint.
+func decodeRetentionFlag(resp *http.Response) (bool, error) {
+	var body struct {
+		Result retentionFlag `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, fmt.Errorf("json: %w", err)
+	}
+
+	return body.Result.Flag, nil
+}
+
+// get performs an authenticated GET request to the given URL, as do does.
+func (api *LogpullAPI) get(ctx context.Context, zoneID, url string) (*http.Response, error) {
+	return api.do(ctx, zoneID, http.MethodGet, url, nil)
+}
+
+// do performs an authenticated request concerning the given zone, with the
+// given method and JSON body, which may be nil, to the given URL, retrying
+// transient failures according to the retry policy. Requests are subject to
+// the rate limit, and when the API responds with HTTP 429, all requests are
+// paused for the requested delay. It returns an error unless the response
+// status is 200 OK, in which case the caller must close the response body.
+func (api *LogpullAPI) do(ctx context.Context, zoneID, method, url string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := api.wait(ctx); err != nil {
+			return nil, fmt.Errorf("waiting for rate limit: %w", err)
+		}
+
+		resp, err := api.doOnce(ctx, zoneID, method, url, body)
+
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusTooManyRequests {
+			atomic.AddUint64(&api.rateLimited, 1)
+			if d, ok := retryAfter(err); ok {
+				api.pause(time.Now().Add(d))
+			}
+		}
+
+		if err == nil || attempt >= api.maxRetries || !isRetryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		atomic.AddUint64(&api.retries, 1)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (after %d attempts: %s)", ctx.Err(), attempt+1, err)
+		case <-time.After(api.backoff(attempt, err)):
+		}
+	}
+}
+
+// doOnce performs a single attempt of do.
+func (api *LogpullAPI) doOnce(ctx context.Context, zoneID, method, url string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating api request: %w", err)
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", api.userAgent)
+	// Logs compress well, so responses are requested compressed. Setting
+	// the header explicitly, rather than relying on http.Transport, keeps
+	// compression enabled with custom HTTP clients.
+	req.Header.Add("Accept-Encoding", "gzip")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	api.authMu.RLock()
+	if api.authType == authToken {
+		req.Header.Add("Authorization", "Bearer "+api.apiToken)
+	}
+
+	if api.authType == authKeyEmail {
+		req.Header.Add("X-Auth-Key", api.apiKey)
+		req.Header.Add("X-Auth-Email", api.apiEmail)
+	}
+
+	if api.authType == authUserService {
+		req.Header.Add("X-Auth-User-Service-Key", api.apiUserService)
+	}
+	api.authMu.RUnlock()
+
+	start := time.Now()
+	resp, err := api.client(zoneID).Do(req)
+	if err != nil {
+		if api.requestHook != nil {
+			api.requestHook("error", time.Since(start), 0)
+		}
+		return nil, &requestError{fmt.Errorf("performing api request: %w", err)}
+	}
+
+	if hook := api.requestHook; hook != nil {
+		status := strconv.Itoa(resp.StatusCode)
+		resp.Body = &meteredBody{ReadCloser: resp.Body, done: func(n int64) {
+			hook(status, time.Since(start), n)
+		}}
+	}
+
+	if err := decompress(resp); err != nil {
+		resp.Body.Close()
+		return nil, &requestError{err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			err = fmt.Errorf("reading api response body: %w", err)
+		} else if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(respBody), "Retention is not turned on") {
+			err = fmt.Errorf("%w: %s", ErrLogRetentionDisabled, respBody)
+		} else {
+			err = &statusError{resp.StatusCode, resp.Header.Get("Retry-After"), fmt.Errorf("unexpected api response: %s: %s", resp.Status, respBody)}
+		}
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// transferSize returns the size of the body of the given response as
+// transferred, before decompression, or -1 if unknown.
+func transferSize(resp *http.Response) int64 {
+	if b, ok := resp.Body.(*gzipBody); ok {
+		return b.size
+	}
+	return resp.ContentLength
+}
+
+// decompress replaces the body of the given response with its decompressed
+// content, if it is gzip-compressed.
+func decompress(resp *http.Response) error {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return nil
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("decompressing api response body: %w", err)
+	}
+
+	resp.Body = &gzipBody{zr, resp.Body, resp.ContentLength}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// gzipBody is the decompressed body of a response, which closes the
+// underlying body when closed. size is the Content-Length of the compressed
+// body, or -1 if unknown.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+	size int64
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// meteredBody is the body of a response, which counts the bytes read from it
+// and calls done once it is closed.
+type meteredBody struct {
+	io.ReadCloser
+	bytes int64
+	once  sync.Once
+	done  func(n int64)
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.bytes) })
+	return err
+}
+
+// requestError is returned when an API request could not be performed at all,
+// e.g. due to a network error.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+// statusError is returned when the API responds with an unexpected status.
+type statusError struct {
+	statusCode int
+	retryAfter string
+	err        error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// stallError is returned when a log download is aborted because no data was
+// received for the stall timeout.
+type stallError struct {
+	err error
+}
+
+func (e *stallError) Error() string { return e.err.Error() }
+func (e *stallError) Unwrap() error { return e.err }
+
+// readError is returned when the API response body can't be read to the end,
+// e.g. because the connection dropped.
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string { return e.err.Error() }
+func (e *readError) Unwrap() error { return e.err }
+
+// oversizeError is returned when a log download is larger than the size
+// budget.
+type oversizeError struct {
+	size int64
+	err  error
+}
+
+func (e *oversizeError) Error() string { return e.err.Error() }
+func (e *oversizeError) Unwrap() error { return e.err }
+
+// decodeError is returned when a log entry in the API response can't be
+// decoded.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// isRetryable reports whether the given error returned by doOnce is likely
+// to be transient: network errors, rate limiting and server errors.
+func isRetryable(err error) bool {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return true
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode == http.StatusTooManyRequests || statusErr.statusCode >= 500
+	}
+
+	return false
+}
+
+// backoff returns how long to wait before retrying after the given failed
+// attempt. The delay grows exponentially from minBackoff up to maxBackoff,
+// with random jitter so that concurrent pulls don't retry in lockstep. A
+// Retry-After header sent by the API takes precedence, up to maxBackoff.
+func (api *LogpullAPI) backoff(attempt int, err error) time.Duration {
+	if d, ok := retryAfter(err); ok {
+		if d < api.maxBackoff {
+			return d
+		}
+		return api.maxBackoff
+	}
+
+	d := api.minBackoff
+	for i := 0; i < attempt && d < api.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > api.maxBackoff {
+		d = api.maxBackoff
+	}
+
+	// Full jitter over the upper half of the delay.
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	return d
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the
+// response which caused the given error, if any.
+func retryAfter(err error) (time.Duration, bool) {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.retryAfter == "" {
+		return 0, false
+	}
+
+	seconds, convErr := strconv.Atoi(statusErr.retryAfter)
+	if convErr != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// ZonesWithoutRetention returns the IDs of the given zones for which log
+// retention is disabled, in the order given.
+func ZonesWithoutRetention(ctx context.Context, api *LogpullAPI, zoneIDs []string) ([]string, error) {
+	var disabled []string
+	for _, zoneID := range zoneIDs {
+		enabled, err := api.GetRetentionContext(ctx, zoneID)
+		if err != nil {
+			return nil, fmt.Errorf("checking log retention of zone %s: %w", zoneID, err)
+		}
+		if !enabled {
+			disabled = append(disabled, zoneID)
+		}
+	}
+
+	return disabled, nil
+}
@@ -0,0 +1,37 @@
+//go:build go1.18
+// +build go1.18
+
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzDecodeLogEntries checks that arbitrary Logpull API responses never
+// cause a panic, that errors are reported as decodeError, and that decoded
+// entries always yield valid label values.
+func FuzzDecodeLogEntries(f *testing.F) {
+	f.Add(logEntryJSON)
+	f.Add([]byte(`{"FirewallMatchesActions": ["block", "log"], "FirewallMatchesSources": ["waf"]}` + "\n" + `{"CacheTieredFill": true}`))
+	f.Add([]byte(`{"EdgeResponseStatus": 1e400}`))
+	f.Add([]byte("{\"ClientRequestHost\": \"\xff\"}"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		err := DecodeLogEntries(bytes.NewReader(data), func(entry LogEntry) error {
+			for name := range logEntryFieldIndex {
+				if v := entry.Field(name); !utf8.ValidString(v) {
+					t.Errorf("invalid UTF-8 in field %s: %q", name, v)
+				}
+			}
+			return nil
+		})
+
+		var decodeErr *decodeError
+		if err != nil && !errors.As(err, &decodeErr) {
+			t.Errorf("expected decodeError, got %v", err)
+		}
+	})
+}
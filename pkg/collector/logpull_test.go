@@ -0,0 +1,1148 @@
+package collector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+var (
+	goodToken          = "good-token"
+	goodKey            = "good-key"
+	goodUserServiceKey = "good-user-service-key"
+	goodEmail          = "good@example.org"
+
+	goodZoneID                 = "good-zone-id"
+	nonexistentZoneID          = "nonexistent-zone-id"
+	unauthorizedZoneID         = "unauthorized-zone-id"
+	logRetentionDisabledZoneID = "log-retention-disabled-zone-id"
+
+	goodEnd        = time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC)
+	goodStart      = goodEnd.Add(-1 * time.Minute)
+	tooEarlyEnd    = time.Date(2021, time.January, 1, 1, 0, 0, 0, time.UTC)
+	tooEarlyStart  = tooEarlyEnd.Add(-1 * time.Minute)
+	tooRecentEnd   = time.Date(2021, time.January, 1, 18, 0, 0, 0, time.UTC)
+	tooRecentStart = tooRecentEnd.Add(-1 * time.Minute)
+
+	logEntryJSON     = []byte(`{"ClientRequestHost": "example.org", "EdgeResponseStatus": 200, "OriginResponseStatus": 200}`)
+	expectedLogEntry = LogEntry{ClientRequestHost: "example.org", EdgeResponseStatus: 200, OriginResponseStatus: 200}
+
+	nopLogHandler = func(LogEntry) error { return nil }
+)
+
+// mockHandlerFunc allows us to write HTTP handler functions that return
+// errors. If an error is returned, it is passed to t.Fatal.
+func mockHandlerFunc(t *testing.T, h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func mockLogpullHandler(w http.ResponseWriter, r *http.Request) error {
+	pathRegexp := regexp.MustCompile(`/zones/(.+)/logs/received`)
+
+	if !pathRegexp.MatchString(r.URL.Path) {
+		return fmt.Errorf("called unexpected endpoint: %s", r.URL.Path)
+	}
+
+	zoneID := pathRegexp.FindStringSubmatch(r.URL.Path)[1]
+
+	authOk := r.Header.Get("Authorization") == "Bearer "+goodToken
+	authOk = authOk || r.Header.Get("X-Auth-Key") == goodKey && r.Header.Get("X-Auth-Email") == goodEmail
+	authOk = authOk || r.Header.Get("X-Auth-User-Service-Key") == goodUserServiceKey
+	if !authOk {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`))
+		return err
+	}
+
+	if zoneID == nonexistentZoneID || zoneID == unauthorizedZoneID {
+		w.WriteHeader(http.StatusForbidden)
+		_, err := w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`))
+		return err
+	}
+
+	if zoneID == logRetentionDisabledZoneID {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("Retention is not turned on. Please enable log retention"))
+		return err
+	}
+
+	start, err := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("bad query: error parsing start time: must be unix timestamp or rfc3339 string"))
+		return err
+	}
+
+	end, err := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("bad query: error parsing end time: must be unix timestamp or rfc3339 string"))
+		return err
+	}
+
+	if end.Before(start) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("bad query: error parsing time: invalid time range: start not before end"))
+		return err
+	}
+
+	if start == tooEarlyStart || end == tooEarlyEnd {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("bad query: error parsing time: invalid time range: too early: logs older than 168h0m0s are not available"))
+		return err
+	}
+
+	if start == tooRecentStart || end == tooRecentEnd {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte("bad query: error parsing time: invalid time range: too recent: minimum delay in serving logs is 1m0s"))
+		return err
+	}
+
+	_, err = w.Write(logEntryJSON)
+	return err
+}
+
+// TestPullLogEntries will attempt to pull logs from a mock Cloudflare API
+// server using sentinel 'good' parameters. It fails if the parsed LogEntry
+// does not match or expected value or if pullLogEntries returns an error.
+func TestPullLogEntries(t *testing.T) {
+	ts := httptest.NewServer(mockHandlerFunc(t, mockLogpullHandler))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, func(entry LogEntry) error {
+		if !reflect.DeepEqual(entry, expectedLogEntry) {
+			t.Error("parsed log entry did not match expected value")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// TestPullLogEntriesLiveEndpoint will attempt to pull the last minute of logs
+// against an actual Cloudflare zone with log retention enabled. It fails if
+// pullLogEntries returns an error.
+//
+// This test is skipped unless the EXPORTER_TEST_LIVE_ENDPOINT environment
+// variable is non-empty, and requires CLOUDFLARE_TEST_API_TOKEN and
+// CLOUDFLARE_TEST_ZONE_NAME to be set appropriately.
+func TestPullLogEntriesLiveEndpoint(t *testing.T) {
+	if os.Getenv("EXPORTER_TEST_LIVE_ENDPOINT") == "" {
+		t.Skip("skipping test of live API endpoint")
+	}
+
+	token := os.Getenv("CLOUDFLARE_TEST_API_TOKEN")
+	if token == "" {
+		t.Fatal("CLOUDFLARE_TEST_API_TOKEN must be specified")
+	}
+
+	zoneName := os.Getenv("CLOUDFLARE_TEST_ZONE_NAME")
+	if zoneName == "" {
+		t.Fatal("CLOUDFLARE_TEST_ZONE_NAME must be specified")
+	}
+
+	cfapi, err := cloudflare.NewWithAPIToken(token)
+	if err != nil {
+		t.Fatalf("creating cfapi client: %s", err)
+	}
+
+	zoneID, err := cfapi.ZoneIDByName(strings.TrimSpace(zoneName))
+	if err != nil {
+		t.Fatalf("zone id lookup: %s", err)
+	}
+
+	end := time.Now().Add(-1 * time.Minute)
+	start := end.Add(-1 * time.Minute)
+
+	lpapi, err := NewLogpullAPIWithToken(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = lpapi.pullLogEntries(zoneID, start, end, nil, nopLogHandler)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPullLogEntriesErrors attempts to pull logs from a mock Cloudflare API
+// server with combinations of valid and invalid parameters. It fails when
+// pullLogEntries returns an error when an error isn't expected, or the
+// inverse.
+func TestPullLogEntriesErrors(t *testing.T) {
+	testCases := []struct {
+		condition         string
+		isErrorExpected   bool
+		authType          authType
+		apiKey            string
+		apiEmail          string
+		apiUserServiceKey string
+		apiToken          string
+		zoneID            string
+		start             time.Time
+		end               time.Time
+	}{
+		{"with valid API key and email", false, authKeyEmail, goodKey, goodEmail, "", "", goodZoneID, goodStart, goodEnd},
+		{"with invalid API key", true, authKeyEmail, "garbage", goodEmail, "", "", goodZoneID, goodStart, goodEnd},
+		{"with invalid API email", true, authKeyEmail, goodKey, "garbage", "", "", goodZoneID, goodStart, goodEnd},
+
+		{"with valid user service key", false, authUserService, "", "", goodUserServiceKey, "", goodZoneID, goodStart, goodEnd},
+		{"with invalid user service key", true, authUserService, "", "", "garbage", "", goodZoneID, goodStart, goodEnd},
+
+		{"with valid API token", false, authToken, "", "", "", goodToken, goodZoneID, goodStart, goodEnd},
+		{"with invalid API token", true, authToken, "", "", "", "garbage", goodZoneID, goodStart, goodEnd},
+
+		{"with valid zone ID", false, authKeyEmail, goodKey, goodEmail, "", "", goodZoneID, goodStart, goodEnd},
+		{"with nonexistent zone ID", true, authKeyEmail, goodKey, goodEmail, "", "", nonexistentZoneID, goodStart, goodEnd},
+		{"with unauthorized zone ID", true, authKeyEmail, goodKey, goodEmail, "", "", unauthorizedZoneID, goodStart, goodEnd},
+		{"with log retention disabled for zone ID", true, authKeyEmail, goodKey, goodEmail, "", "", logRetentionDisabledZoneID, goodStart, goodEnd},
+
+		{"with valid time parameters", false, authKeyEmail, goodKey, goodEmail, "", "", goodZoneID, goodStart, goodEnd},
+		{"with too early time parameters", true, authKeyEmail, goodKey, goodEmail, "", "", goodZoneID, tooEarlyStart, tooEarlyEnd},
+		{"with too recent time parameters", true, authKeyEmail, goodKey, goodEmail, "", "", goodZoneID, tooRecentStart, tooRecentEnd},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			ts := httptest.NewServer(mockHandlerFunc(t, mockLogpullHandler))
+			defer ts.Close()
+
+			opts := []LogpullOption{WithBaseURL(ts.URL), WithHTTPClient(ts.Client())}
+
+			var api *LogpullAPI
+			var err error
+			switch c.authType {
+			case authKeyEmail:
+				api, err = NewLogpullAPI(c.apiKey, c.apiEmail, opts...)
+			case authUserService:
+				api, err = newLogpullAPIWithUserServiceKey(c.apiUserServiceKey, opts...)
+			case authToken:
+				api, err = NewLogpullAPIWithToken(c.apiToken, opts...)
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = api.pullLogEntries(c.zoneID, c.start, c.end, nil, nopLogHandler)
+			if err == nil && c.isErrorExpected {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if err != nil && !c.isErrorExpected {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+// TestPullLogEntriesAPIErrorContext attempts to pull logs from a mock
+// Cloudflare API which will intentionally return non-successful responses. The
+// expectation is that the response body will be returned in an error message
+// to the caller.
+func TestPullLogEntriesAPIErrorContext(t *testing.T) {
+	msg := "the server's on fire"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler)
+	if err == nil || !strings.Contains(err.Error(), msg) {
+		t.Error("expected an error containing the response body from the server")
+	}
+}
+
+// TestPullLogEntriesContext checks that pullLogEntriesContext returns an error
+// rather than waiting on the server once the context is done.
+func TestPullLogEntriesContext(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = api.pullLogEntriesContext(ctx, goodZoneID, goodStart, goodEnd, nil, nopLogHandler)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestPullLogEntriesLogRetentionDisabled checks that pullLogEntries returns
+// ErrLogRetentionDisabled for zones without log retention.
+func TestPullLogEntriesLogRetentionDisabled(t *testing.T) {
+	ts := httptest.NewServer(mockHandlerFunc(t, mockLogpullHandler))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = api.pullLogEntries(logRetentionDisabledZoneID, goodStart, goodEnd, nil, nopLogHandler)
+	if !errors.Is(err, ErrLogRetentionDisabled) {
+		t.Errorf("expected errLogRetentionDisabled, got %v", err)
+	}
+}
+
+// TestPullLogEntriesRetry checks that transient failures are retried up to
+// the configured number of times, and that other failures are not retried.
+func TestPullLogEntriesRetry(t *testing.T) {
+	testCases := []struct {
+		condition        string
+		statuses         []int
+		maxRetries       int
+		isErrorExpected  bool
+		expectedRequests int
+	}{
+		{"with success", []int{http.StatusOK}, 3, false, 1},
+		{"with transient server error", []int{http.StatusServiceUnavailable, http.StatusOK}, 3, false, 2},
+		{"with transient rate limiting", []int{http.StatusTooManyRequests, http.StatusOK}, 3, false, 2},
+		{"with persistent server error", []int{http.StatusInternalServerError}, 2, true, 3},
+		{"with client error", []int{http.StatusForbidden}, 3, true, 1},
+		{"with retries disabled", []int{http.StatusInternalServerError}, 0, true, 1},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := c.statuses[len(c.statuses)-1]
+				if requests < len(c.statuses) {
+					status = c.statuses[requests]
+				}
+				requests++
+
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					if _, err := w.Write(logEntryJSON); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}
+			}))
+			defer ts.Close()
+
+			api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(c.maxRetries, time.Millisecond, time.Millisecond))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler)
+			if err == nil && c.isErrorExpected {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if err != nil && !c.isErrorExpected {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if requests != c.expectedRequests {
+				t.Errorf("expected %d requests, got %d", c.expectedRequests, requests)
+			}
+
+			if retries := api.retryCount(); retries != uint64(c.expectedRequests-1) {
+				t.Errorf("expected %d retries, got %d", c.expectedRequests-1, retries)
+			}
+		})
+	}
+}
+
+// TestPullLogEntriesRateLimit checks that requests are paced by the rate
+// limit, and that responses with HTTP 429 are counted and pause subsequent
+// requests for the requested delay.
+func TestPullLogEntriesRateLimit(t *testing.T) {
+	var times []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRateLimit(20, 1))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err == nil {
+		t.Error("expected error when rate limited without retries")
+	}
+
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Errorf("expected second request to be paused by Retry-After, got %v", times)
+	}
+
+	if limited := api.rateLimitedCount(); limited != 1 {
+		t.Errorf("expected 1 rate limited request, got %d", limited)
+	}
+
+	for _, c := range []struct {
+		rps   float64
+		burst int
+	}{{-1, 1}, {1, 0}} {
+		if _, err := NewLogpullAPI(goodKey, goodEmail, WithRateLimit(c.rps, c.burst)); err == nil {
+			t.Errorf("expected error when called with rps %v and burst %d", c.rps, c.burst)
+		}
+	}
+}
+
+// TestRetention checks that GetRetentionContext and SetRetentionContext read
+// and update the log retention flag of a zone.
+func TestRetention(t *testing.T) {
+	flags := map[string]bool{goodZoneID: true}
+	ts := httptest.NewServer(mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+		pathRegexp := regexp.MustCompile(`/zones/(.+)/logs/control/retention/flag`)
+		if !pathRegexp.MatchString(r.URL.Path) {
+			return fmt.Errorf("called unexpected endpoint: %s", r.URL.Path)
+		}
+		zoneID := pathRegexp.FindStringSubmatch(r.URL.Path)[1]
+
+		if r.Method == http.MethodPost {
+			var body retentionFlag
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return err
+			}
+			flags[zoneID] = body.Flag
+		}
+
+		_, err := fmt.Fprintf(w, `{"success":true,"errors":[],"messages":[],"result":{"flag":%t}}`, flags[zoneID])
+		return err
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	disabled, err := ZonesWithoutRetention(context.Background(), api, []string{goodZoneID, logRetentionDisabledZoneID})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(disabled, []string{logRetentionDisabledZoneID}) {
+		t.Errorf("expected log retention to be disabled for %s only, got %v", logRetentionDisabledZoneID, disabled)
+	}
+
+	if err := api.SetRetentionContext(context.Background(), logRetentionDisabledZoneID, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	enabled, err := api.GetRetentionContext(context.Background(), logRetentionDisabledZoneID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !enabled {
+		t.Error("expected log retention to be enabled")
+	}
+}
+
+// TestPullLogEntryByRayID checks that pullLogEntryByRayIDContext returns the
+// log entry of the requested Ray ID, and errLogEntryNotFound if there is none.
+func TestPullLogEntryByRayID(t *testing.T) {
+	const goodRayID = "5f1f0a1b2c3d4e5f"
+
+	ts := httptest.NewServer(mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/zones/"+goodZoneID+"/logs/rayids/"+goodRayID {
+			_, err := w.Write(logEntryJSON)
+			return err
+		}
+		return nil
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	entry, err := api.pullLogEntryByRayIDContext(context.Background(), goodZoneID, goodRayID, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(entry, expectedLogEntry) {
+		t.Error("parsed log entry did not match expected value")
+	}
+
+	if _, err := api.pullLogEntryByRayIDContext(context.Background(), goodZoneID, "unknown", nil); !errors.Is(err, errLogEntryNotFound) {
+		t.Errorf("expected errLogEntryNotFound, got %v", err)
+	}
+}
+
+// TestDecodeLogEntries checks that malformed and adversarial input is rejected
+// with a decodeError, and that invalid UTF-8 yields valid label values.
+func TestDecodeLogEntries(t *testing.T) {
+	testCases := []struct {
+		condition       string
+		input           string
+		isErrorExpected bool
+	}{
+		{"with valid entries", string(logEntryJSON) + "\n" + string(logEntryJSON), false},
+		{"with empty input", "", false},
+		{"with truncated entry", `{"ClientRequestHost": "exa`, true},
+		{"with huge number", `{"EdgeResponseStatus": 1e400}`, true},
+		{"with overflowing number", `{"EdgeResponseBytes": 99999999999999999999}`, true},
+		{"with deeply nested object", `{"ClientRequestHost": ` + strings.Repeat("[", 100000) + strings.Repeat("]", 100000) + `}`, true},
+		{"with nested object in string field", `{"ClientRequestHost": {"name": "example.org"}}`, true},
+		{"with overlong line", `{"ClientRequestHost": "` + strings.Repeat("a", maxLogLineSize) + `"}`, true},
+		{"with invalid UTF-8", "{\"ClientRequestHost\": \"example\xff.org\"}", false},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			err := DecodeLogEntries(strings.NewReader(c.input), func(entry LogEntry) error {
+				for name := range logEntryFieldIndex {
+					if v := entry.Field(name); !utf8.ValidString(v) {
+						t.Errorf("invalid UTF-8 in field %s: %q", name, v)
+					}
+				}
+				return nil
+			})
+
+			var decodeErr *decodeError
+			if c.isErrorExpected && !errors.As(err, &decodeErr) {
+				t.Errorf("expected decodeError when called %s, got %v", c.condition, err)
+			}
+			if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error when called %s: %s", c.condition, err)
+			}
+		})
+	}
+}
+
+// TestLogEntryField checks that array, boolean and nested object fields are
+// formatted as label values, and that object fields require a key.
+func TestLogEntryField(t *testing.T) {
+	var entry LogEntry
+	jsonBody := `{"CacheTieredFill": true, "FirewallMatchesActions": ["block", "log"], "RequestHeaders": {"user-agent": "curl/7.64.1"}}`
+	if err := json.Unmarshal([]byte(jsonBody), &entry); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name      string
+		supported bool
+		expected  string
+	}{
+		{"CacheTieredFill", true, "true"},
+		{"FirewallMatchesActions", true, "block,log"},
+		{"RequestHeaders.user-agent", true, "curl/7.64.1"},
+		{"RequestHeaders.referer", true, ""},
+		{"RequestHeaders", false, ""},
+		{"ClientRequestHost.name", false, ""},
+		{"Unknown", false, ""},
+	}
+
+	for _, c := range testCases {
+		if got := isLogEntryField(c.name); got != c.supported {
+			t.Errorf("isLogEntryField(%q) = %t, want %t", c.name, got, c.supported)
+		}
+		if got := entry.Field(c.name); got != c.expected {
+			t.Errorf("field(%q) = %q, want %q", c.name, got, c.expected)
+		}
+	}
+
+	if got := logpullField("RequestHeaders.user-agent"); got != "RequestHeaders" {
+		t.Errorf("logpullField returned %q, want RequestHeaders", got)
+	}
+}
+
+// TestPullLogEntriesGzip checks that compressed responses are requested and
+// transparently decompressed.
+func TestPullLogEntriesGzip(t *testing.T) {
+	ts := httptest.NewServer(mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			_, err := w.Write(logEntryJSON)
+			return err
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		if _, err := zw.Write(logEntryJSON); err != nil {
+			return err
+		}
+		return zw.Close()
+	}))
+	defer ts.Close()
+
+	// Compression is disabled in the transport, so that responses are only
+	// compressed if the client requests it explicitly.
+	client := ts.Client()
+	client.Transport.(*http.Transport).DisableCompression = true
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	entries := 0
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, func(entry LogEntry) error {
+		if !reflect.DeepEqual(entry, expectedLogEntry) {
+			t.Error("parsed log entry did not match expected value")
+		}
+		entries++
+		return nil
+	}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if entries != 1 {
+		t.Errorf("expected 1 log entry, got %d", entries)
+	}
+}
+
+// TestPullLogEntriesRequestHook checks that the request hook is called for
+// every attempted request, with its status and response size.
+func TestPullLogEntriesRequestHook(t *testing.T) {
+	attempt := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := w.Write(logEntryJSON); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+
+	type request struct {
+		status string
+		size   int64
+	}
+	var requests []request
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	api.SetRequestHook(func(status string, duration time.Duration, size int64) {
+		if duration <= 0 {
+			t.Errorf("expected positive duration, got %s", duration)
+		}
+		requests = append(requests, request{status, size})
+	})
+
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	ts.Close()
+	if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err == nil {
+		t.Error("expected error when the server is down")
+	}
+
+	expected := []request{{"503", 0}, {"200", int64(len(logEntryJSON))}, {"error", 0}, {"error", 0}}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+}
+
+// TestLogpullOptions checks that the options of the Logpull API client are
+// validated, and that the User-Agent header is set.
+func TestLogpullOptions(t *testing.T) {
+	testCases := []struct {
+		condition string
+		opt       LogpullOption
+	}{
+		{"with nil HTTP client", WithHTTPClient(nil)},
+		{"with empty base URL", WithBaseURL("")},
+		{"with empty user agent", withUserAgent("")},
+		{"with negative retries", WithRetry(-1, time.Second, time.Second)},
+		{"with zero backoff", WithRetry(1, 0, time.Second)},
+		{"with inverted backoff", WithRetry(1, 2*time.Second, time.Second)},
+		{"with negative rate limit", WithRateLimit(-1, 1)},
+		{"with zero sample rate", WithSample(0)},
+		{"with negative size budget", WithSizeBudget(-1, OversizeSkip)},
+		{"with unknown oversize action", WithSizeBudget(1, "truncate")},
+		{"with nil client isolation group", WithClientIsolation(func() *http.Client { return nil }, nil)},
+	}
+
+	for _, c := range testCases {
+		if _, err := NewLogpullAPIWithToken(goodToken, c.opt); err == nil {
+			t.Errorf("expected error when called %s", c.condition)
+		}
+	}
+
+	var userAgents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+	}))
+	defer ts.Close()
+
+	for _, opts := range [][]LogpullOption{nil, {withUserAgent("custom/1.0")}} {
+		api, err := NewLogpullAPIWithToken(goodToken, append(opts, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))...)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	expected := []string{defaultUserAgent, "custom/1.0"}
+	if !reflect.DeepEqual(userAgents, expected) {
+		t.Errorf("expected user agents %v, got %v", expected, userAgents)
+	}
+}
+
+// TestNewLogpullAPIFromCloudflare checks that clients created from a
+// Cloudflare API client share its credentials and base URL.
+func TestNewLogpullAPIFromCloudflare(t *testing.T) {
+	ts := httptest.NewServer(mockHandlerFunc(t, mockLogpullHandler))
+	defer ts.Close()
+
+	tokenAPI, err := cloudflare.NewWithAPIToken(goodToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	keyAPI, err := cloudflare.New(goodKey, goodEmail)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	userServiceAPI, err := cloudflare.NewWithUserServiceKey(goodUserServiceKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, cfapi := range []*cloudflare.API{tokenAPI, keyAPI, userServiceAPI} {
+		cfapi.BaseURL = ts.URL
+
+		api, err := NewLogpullAPIFromCloudflare(cfapi, WithHTTPClient(ts.Client()))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if err := api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	if _, err := NewLogpullAPIFromCloudflare(nil); err == nil {
+		t.Error("expected error when called with nil client")
+	}
+	if _, err := NewLogpullAPIFromCloudflare(&cloudflare.API{}); err == nil {
+		t.Error("expected error when called without credentials")
+	}
+}
+
+// TestPullLogEntriesStall checks that stalled downloads are aborted, and
+// retried unless log entries without a timestamp to resume from were
+// already passed to the handler.
+func TestPullLogEntriesStall(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	testCases := []struct {
+		condition        string
+		partial          bool
+		isErrorExpected  bool
+		expectedRequests int
+	}{
+		{"before any entries", false, false, 2},
+		{"after an entry", true, true, 1},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests > 1 {
+					if _, err := w.Write(logEntryJSON); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					return
+				}
+
+				if c.partial {
+					if _, err := w.Write(append(logEntryJSON, '\n')); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}
+				w.(http.Flusher).Flush()
+				select {
+				case <-done:
+				case <-r.Context().Done():
+				}
+			}))
+			defer ts.Close()
+
+			api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(1, time.Millisecond, time.Millisecond), WithStallTimeout(50*time.Millisecond))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, nopLogHandler)
+			var stallErr *stallError
+			if c.isErrorExpected && !errors.As(err, &stallErr) {
+				t.Errorf("expected stallError, got %v", err)
+			} else if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if requests != c.expectedRequests {
+				t.Errorf("expected %d requests, got %d", c.expectedRequests, requests)
+			}
+			if stalls := api.stallCount(); stalls != 1 {
+				t.Errorf("expected 1 stall, got %d", stalls)
+			}
+		})
+	}
+
+	if _, err := NewLogpullAPI(goodKey, goodEmail, WithStallTimeout(-time.Second)); err == nil {
+		t.Error("expected error when called with negative timeout")
+	}
+}
+
+// TestPullLogEntriesSizeBudget checks that log downloads larger than the size
+// budget are skipped, split or sampled before being read.
+func TestPullLogEntriesSizeBudget(t *testing.T) {
+	start := goodEnd.Add(-4 * time.Second)
+
+	testCases := []struct {
+		action           string
+		maxBytes         int64
+		isErrorExpected  bool
+		expectedRequests int
+		expectedActions  []string
+		expectedWeight   float64
+	}{
+		{OversizeSkip, 6, true, 1, []string{OversizeSkip}, 0},
+		{OversizeSplit, 3, false, 7, []string{OversizeSplit, OversizeSplit, OversizeSplit}, 4},
+		{OversizeSplit, 2, true, 3, []string{OversizeSplit, OversizeSplit, OversizeSkip}, 0},
+		{OversizeSample, 6, false, 2, []string{OversizeSample}, 2},
+	}
+
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s within %d bytes", c.action, c.maxBytes), func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				query := r.URL.Query()
+				from, _ := time.Parse(time.RFC3339, query.Get("start"))
+				to, _ := time.Parse(time.RFC3339, query.Get("end"))
+
+				// Every second of the window holds a 3-byte entry,
+				// and sampled windows a single one.
+				n := int(to.Sub(from) / time.Second)
+				if query.Get("sample") != "" {
+					n = 1
+				}
+				if _, err := w.Write([]byte(strings.Repeat("{}\n", n))); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}))
+			defer ts.Close()
+
+			api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithSizeBudget(c.maxBytes, c.action))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var actions []string
+			api.SetOversizeHook(func(zoneID string, start, end time.Time, size int64, action string) {
+				actions = append(actions, action)
+			})
+
+			weight := 0.0
+			err = api.pullLogEntries(goodZoneID, start, goodEnd, nil, func(entry LogEntry) error {
+				weight += entry.weight()
+				return nil
+			})
+			var oversizeErr *oversizeError
+			if c.isErrorExpected && !errors.As(err, &oversizeErr) {
+				t.Errorf("expected oversizeError, got %v", err)
+			} else if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if requests != c.expectedRequests {
+				t.Errorf("expected %d requests, got %d", c.expectedRequests, requests)
+			}
+			if !reflect.DeepEqual(actions, c.expectedActions) {
+				t.Errorf("expected actions %v, got %v", c.expectedActions, actions)
+			}
+			if !c.isErrorExpected && weight != c.expectedWeight {
+				t.Errorf("expected entries weighing %g, got %g", c.expectedWeight, weight)
+			}
+		})
+	}
+}
+
+// TestPullLogEntriesResume checks that interrupted downloads are resumed at
+// the timestamp of the last complete log entry received, without passing it
+// to the handler twice, whether the connection drops between two lines or in
+// the middle of one, and whether or not the response is compressed.
+func TestPullLogEntriesResume(t *testing.T) {
+	first := `{"EdgeEndTimestamp": 1500000000000000001, "RayID": "a"}` + "\n"
+
+	cases := map[string]struct {
+		body   string
+		isGzip bool
+	}{
+		"line boundary": {first, false},
+		"mid-line":      {first + `{"EdgeEndTimestamp": 15000`, false},
+		"gzip mid-line": {first + `{"EdgeEndTimestamp": 15000`, true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var starts []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				starts = append(starts, r.URL.Query().Get("start"))
+				if len(starts) > 1 {
+					if _, err := w.Write([]byte(first + `{"EdgeEndTimestamp": 1500000000000000002, "RayID": "b"}`)); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					return
+				}
+
+				body := []byte(c.body)
+				if c.isGzip {
+					// The compressed stream is flushed, but not closed,
+					// so that it is cut short like the connection.
+					var buf bytes.Buffer
+					zw := gzip.NewWriter(&buf)
+					if _, err := zw.Write(body); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					if err := zw.Flush(); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					body = buf.Bytes()
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+
+				// The connection drops, since the body is shorter than
+				// announced.
+				w.Header().Set("Content-Length", strconv.Itoa(2*len(body)))
+				if _, err := w.Write(body); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}))
+			defer ts.Close()
+
+			api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(1, time.Millisecond, time.Millisecond))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var timestamps []int64
+			err = api.pullLogEntries(goodZoneID, time.Unix(1500000000, 0), time.Unix(1500000060, 0), nil, func(entry LogEntry) error {
+				timestamps = append(timestamps, int64(entry.EdgeEndTimestamp))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(starts) != 2 || starts[1] != "1500000000000000001" {
+				t.Errorf("expected a second request starting at 1500000000000000001, got %q", starts)
+			}
+			if !reflect.DeepEqual(timestamps, []int64{1500000000000000001, 1500000000000000002}) {
+				t.Errorf("expected entries 1500000000000000001 and 1500000000000000002, got %v", timestamps)
+			}
+			if resumes := api.resumeCount(); resumes != 1 {
+				t.Errorf("expected 1 resume, got %d", resumes)
+			}
+		})
+	}
+}
+
+// TestPullLogEntriesResumeSharedTimestamp checks that a download interrupted
+// between entries sharing a timestamp is resumed at that timestamp, so that
+// the entries of it which were not received yet are not lost, while those
+// which were are not passed to the handler twice.
+func TestPullLogEntriesResumeSharedTimestamp(t *testing.T) {
+	var starts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		starts = append(starts, r.URL.Query().Get("start"))
+		if fields := r.URL.Query().Get("fields"); !strings.HasSuffix(fields, ",EdgeEndTimestamp,RayID") {
+			t.Errorf("unexpected fields requested: %s", fields)
+		}
+
+		if len(starts) > 1 {
+			body := `{"EdgeEndTimestamp": 1500000000000000002, "RayID": "b"}
+{"EdgeEndTimestamp": 1500000000000000002, "RayID": "c"}
+{"EdgeEndTimestamp": 1500000000000000002, "RayID": "d"}
+{"EdgeEndTimestamp": 1500000000000000003, "RayID": "e"}`
+			if _, err := w.Write([]byte(body)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			return
+		}
+
+		// The connection drops after two of the three entries of the
+		// second timestamp.
+		body := []byte(`{"EdgeEndTimestamp": 1500000000000000001, "RayID": "a"}
+{"EdgeEndTimestamp": 1500000000000000002, "RayID": "b"}
+{"EdgeEndTimestamp": 1500000000000000002, "RayID": "c"}
+`)
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(body)))
+		if _, err := w.Write(body); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()), WithRetry(1, time.Millisecond, time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var rayIDs []string
+	err = api.pullLogEntries(goodZoneID, time.Unix(1500000000, 0), time.Unix(1500000060, 0), []string{"ClientRequestHost"}, func(entry LogEntry) error {
+		rayIDs = append(rayIDs, entry.RayID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(starts) != 2 || starts[1] != "1500000000000000002" {
+		t.Errorf("expected a second request starting at 1500000000000000002, got %q", starts)
+	}
+	if expected := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(rayIDs, expected) {
+		t.Errorf("expected entries %v, got %v", expected, rayIDs)
+	}
+}
+
+// TestPullLogEntriesTimestamps checks that the timestamps of log entries are
+// requested in the configured format, and passed to the handler in Unix
+// nanoseconds whatever the format.
+func TestPullLogEntriesTimestamps(t *testing.T) {
+	testCases := []struct {
+		condition string
+		format    string
+		timestamp string
+		expected  int64
+	}{
+		{"with default format", "", `1500000001000000002`, 1500000001000000002},
+		{"with unixnano", TimestampsUnixNano, `1500000001000000002`, 1500000001000000002},
+		{"with unix", TimestampsUnix, `1500000001`, 1500000001000000000},
+		{"with rfc3339", TimestampsRFC3339, `"2017-07-14T02:40:01Z"`, 1500000001000000000},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			ts := httptest.NewServer(mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+				if timestamps := r.URL.Query().Get("timestamps"); timestamps != c.format {
+					return fmt.Errorf("unexpected timestamps requested: %q", timestamps)
+				}
+				_, err := w.Write([]byte(`{"EdgeEndTimestamp": ` + c.timestamp + `}`))
+				return err
+			}))
+			defer ts.Close()
+
+			opts := []LogpullOption{WithBaseURL(ts.URL), WithHTTPClient(ts.Client())}
+			if c.format != "" {
+				opts = append(opts, WithTimestamps(c.format))
+			}
+			api, err := NewLogpullAPI(goodKey, goodEmail, opts...)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var timestamps []int64
+			err = api.pullLogEntries(goodZoneID, goodStart, goodEnd, nil, func(entry LogEntry) error {
+				timestamps = append(timestamps, int64(entry.EdgeEndTimestamp))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(timestamps, []int64{c.expected}) {
+				t.Errorf("expected timestamp %d, got %v", c.expected, timestamps)
+			}
+		})
+	}
+
+	if _, err := NewLogpullAPI(goodKey, goodEmail, WithTimestamps("unixmilli")); err == nil {
+		t.Error("expected error when called with unknown format")
+	}
+}
+
+// TestLogpullClientIsolation checks that the requests concerning every group
+// of zones are sent with the group's own HTTP client.
+func TestLogpullClientIsolation(t *testing.T) {
+	ts := httptest.NewServer(mockHandlerFunc(t, func(w http.ResponseWriter, r *http.Request) error {
+		_, err := w.Write(logEntryJSON)
+		return err
+	}))
+	defer ts.Close()
+
+	var created int
+	newClient := func() *http.Client {
+		created++
+		return &http.Client{Transport: ts.Client().Transport}
+	}
+	groups := map[string]string{"zone-a": "account-1", "zone-b": "account-1", "zone-c": "account-2"}
+	group := func(zoneID string) string {
+		return groups[zoneID]
+	}
+
+	api, err := NewLogpullAPI(goodKey, goodEmail, WithBaseURL(ts.URL), WithClientIsolation(newClient, group))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, zoneID := range []string{"zone-a", "zone-b", "zone-c", "zone-a"} {
+		if err := api.pullLogEntries(zoneID, goodStart, goodEnd, nil, nopLogHandler); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if created != 2 {
+		t.Errorf("got %d clients, want 2", created)
+	}
+	if api.client("zone-a") != api.client("zone-b") || api.client("zone-a") == api.client("zone-c") {
+		t.Error("expected clients to be shared within account groups only")
+	}
+}
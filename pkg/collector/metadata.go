@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// ZoneMetadataLabels are the labels of per-zone metrics which may be taken
+// from the metadata of the zones, by the function giving their value.
+var ZoneMetadataLabels = map[string]func(cloudflare.Zone) string{
+	"zone_account": func(z cloudflare.Zone) string { return z.Account.Name },
+	"zone_plan":    func(z cloudflare.Zone) string { return z.Plan.Name },
+}
+
+// ZoneMetadataLabelNames returns the names of the supported zone metadata
+// labels, sorted.
+func ZoneMetadataLabelNames() []string {
+	names := make([]string, 0, len(ZoneMetadataLabels))
+	for name := range ZoneMetadataLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
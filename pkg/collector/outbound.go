@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OutboundMetrics counts the exporter's outbound HTTP requests and bytes by
+// destination host, so that its traffic can be reconciled against firewall
+// logs.
+type OutboundMetrics struct {
+	requests      *prometheus.CounterVec
+	sentBytes     *prometheus.CounterVec
+	receivedBytes *prometheus.CounterVec
+}
+
+// NewOutboundMetrics creates a new OutboundMetrics.
+func NewOutboundMetrics() *OutboundMetrics {
+	return &OutboundMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_outbound_requests_total",
+			Help: "The number of outbound HTTP requests of the exporter, by destination host",
+		}, []string{"host"}),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_outbound_sent_bytes_total",
+			Help: "The number of bytes sent in the bodies of outbound HTTP requests of the exporter, by destination host",
+		}, []string{"host"}),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cloudflare_logpull_outbound_received_bytes_total",
+			Help: "The number of bytes received in the bodies of responses to outbound HTTP requests of the exporter, by destination host",
+		}, []string{"host"}),
+	}
+}
+
+// Describe is a required method of the prometheus.Collector interface.
+func (m *OutboundMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.sentBytes.Describe(ch)
+	m.receivedBytes.Describe(ch)
+}
+
+// Collect is a required method of the prometheus.Collector interface.
+func (m *OutboundMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.sentBytes.Collect(ch)
+	m.receivedBytes.Collect(ch)
+}
+
+// Transport returns an http.RoundTripper which counts the requests sent by
+// next. Requests are counted when they are sent, whether or not they
+// succeed, and response bytes as they are read.
+func (m *OutboundMetrics) Transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		host := req.URL.Hostname()
+		m.requests.WithLabelValues(host).Inc()
+		if req.ContentLength > 0 {
+			m.sentBytes.WithLabelValues(host).Add(float64(req.ContentLength))
+		}
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		received := m.receivedBytes.WithLabelValues(host)
+		resp.Body = &meteredBody{ReadCloser: resp.Body, done: func(n int64) {
+			received.Add(float64(n))
+		}}
+		return resp, nil
+	})
+}
+
+// roundTripperFunc is a function implementing http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutboundMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte("hello")); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	m := NewOutboundMetrics()
+	client := &http.Client{Transport: m.Transport(http.DefaultTransport)}
+
+	resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if _, err := client.Get("http://localhost:1/unreachable"); err == nil {
+		t.Error("expected error when the host is unreachable")
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logpull_outbound_received_bytes_total The number of bytes received in the bodies of responses to outbound HTTP requests of the exporter, by destination host
+		# TYPE cloudflare_logpull_outbound_received_bytes_total counter
+		cloudflare_logpull_outbound_received_bytes_total{host="127.0.0.1"} 5
+		# HELP cloudflare_logpull_outbound_requests_total The number of outbound HTTP requests of the exporter, by destination host
+		# TYPE cloudflare_logpull_outbound_requests_total counter
+		cloudflare_logpull_outbound_requests_total{host="127.0.0.1"} 1
+		cloudflare_logpull_outbound_requests_total{host="localhost"} 1
+		# HELP cloudflare_logpull_outbound_sent_bytes_total The number of bytes sent in the bodies of outbound HTTP requests of the exporter, by destination host
+		# TYPE cloudflare_logpull_outbound_sent_bytes_total counter
+		cloudflare_logpull_outbound_sent_bytes_total{host="127.0.0.1"} 4
+	`)
+
+	if err := testutil.CollectAndCompare(m, expected); err != nil {
+		t.Errorf("unexpected collecting result:\n%s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PathGroupConfig maps the request paths matching a regular expression to a
+// group, reported in the path_group label. The group may refer to submatches
+// of the expression, such as $1 or ${name}.
+type PathGroupConfig struct {
+	Regex string `yaml:"regex"`
+	Group string `yaml:"group"`
+}
+
+// pathGroup is a compiled PathGroupConfig.
+type pathGroup struct {
+	regex *regexp.Regexp
+	group string
+}
+
+// PathGrouper groups request paths, so that per-endpoint traffic can be
+// reported without a series for every path ever requested.
+type PathGrouper struct {
+	groups []pathGroup
+}
+
+// NewPathGrouper creates a new PathGrouper from the given mappings, which are
+// tried in order.
+func NewPathGrouper(configs []PathGroupConfig) (*PathGrouper, error) {
+	g := &PathGrouper{groups: make([]pathGroup, len(configs))}
+	for i, cfg := range configs {
+		if cfg.Group == "" {
+			return nil, errors.New("invalid parameter: group must not be empty")
+		}
+
+		regex, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("compiling path pattern %q: %w", cfg.Regex, err)
+		}
+		g.groups[i] = pathGroup{regex: regex, group: cfg.Group}
+	}
+
+	return g, nil
+}
+
+// group returns the group of the path of the given request URI, which may
+// include a query string, or otherLabelValue if no mapping matches.
+func (g *PathGrouper) group(uri string) string {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+
+	for _, pg := range g.groups {
+		if match := pg.regex.FindStringSubmatchIndex(uri); match != nil {
+			return string(pg.regex.ExpandString(nil, pg.group, uri, match))
+		}
+	}
+
+	return otherLabelValue
+}
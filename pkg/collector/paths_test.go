@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"testing"
+)
+
+// TestPathGrouper checks that paths are grouped by the first matching
+// mapping, ignoring query strings.
+func TestPathGrouper(t *testing.T) {
+	g, err := NewPathGrouper([]PathGroupConfig{
+		{Regex: `^/api/v(\d+)/users/[^/]+$`, Group: "/api/v$1/users/:id"},
+		{Regex: `^/api/`, Group: "/api"},
+		{Regex: `^/$`, Group: "/"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		uri      string
+		expected string
+	}{
+		{"/api/v2/users/42", "/api/v2/users/:id"},
+		{"/api/v2/users/42?fields=name", "/api/v2/users/:id"},
+		{"/api/v2/users/42/posts", "/api"},
+		{"/?q=/api/", "/"},
+		{"/static/app.js", otherLabelValue},
+		{"", otherLabelValue},
+	}
+
+	for _, c := range testCases {
+		if got := g.group(c.uri); got != c.expected {
+			t.Errorf("group(%q) = %q, want %q", c.uri, got, c.expected)
+		}
+	}
+}
+
+// TestPathGrouperErrors checks that invalid mappings are rejected.
+func TestPathGrouperErrors(t *testing.T) {
+	if _, err := NewPathGrouper([]PathGroupConfig{{Regex: "(", Group: "group"}}); err == nil {
+		t.Error("expected error when called with invalid pattern")
+	}
+	if _, err := NewPathGrouper([]PathGroupConfig{{Regex: "^/"}}); err == nil {
+		t.Error("expected error when called without group")
+	}
+}
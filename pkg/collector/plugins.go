@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/aggregator"
+)
+
+// registeredAggregations returns the aggregations registered by plugins. It is
+// a variable so that tests can register their own.
+var registeredAggregations = aggregator.Registered
+
+// CheckPluginFields checks that the log entries of the collector support the
+// fields of every aggregation registered with the aggregator package.
+func CheckPluginFields() error {
+	for _, r := range registeredAggregations() {
+		for _, field := range r.Aggregation.Fields() {
+			if !isLogEntryField(field) {
+				return fmt.Errorf("aggregation %s reads unsupported field %q", r.Name, field)
+			}
+		}
+	}
+	return nil
+}
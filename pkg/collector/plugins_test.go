@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/aggregator"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// customerRE extracts customer IDs from the request URIs of the test plugin.
+var customerRE = regexp.MustCompile(`^/customers/([^/?]+)`)
+
+// customerAggregation counts requests by customer ID.
+type customerAggregation struct {
+	desc   *prometheus.Desc
+	fields []string
+}
+
+func (a customerAggregation) Fields() []string {
+	return a.fields
+}
+
+func (a customerAggregation) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+func (a customerAggregation) Window(window aggregator.Window) aggregator.Aggregator {
+	return &customerAggregator{desc: a.desc, window: window, counts: make(map[string]float64)}
+}
+
+// customerAggregator is the aggregator of customerAggregation.
+type customerAggregator struct {
+	desc   *prometheus.Desc
+	window aggregator.Window
+	counts map[string]float64
+}
+
+func (a *customerAggregator) Observe(entry aggregator.Entry, weight float64) {
+	if m := customerRE.FindStringSubmatch(entry.Field("ClientRequestURI")); m != nil {
+		a.counts[m[1]] += weight
+	}
+}
+
+func (a *customerAggregator) Emit(ch chan<- prometheus.Metric) {
+	for customer, count := range a.counts {
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, count, a.window.Zone, customer)
+	}
+}
+
+// withAggregatorPlugins replaces the registered aggregations for the duration
+// of a test.
+func withAggregatorPlugins(t *testing.T, registrations ...aggregator.Registration) {
+	saved := registeredAggregations
+	registeredAggregations = func() []aggregator.Registration {
+		return registrations
+	}
+	t.Cleanup(func() {
+		registeredAggregations = saved
+	})
+}
+
+// TestAggregatorPlugin checks that registered aggregations observe the log
+// entries of every window, and that their fields are requested.
+func TestAggregatorPlugin(t *testing.T) {
+	desc := prometheus.NewDesc("cloudflare_logs_customer_requests", "Requests by customer", []string{"zone", "customer"}, nil)
+	withAggregatorPlugins(t, aggregator.Registration{
+		Name:        "customers",
+		Aggregation: customerAggregation{desc, []string{"ClientRequestURI"}},
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("fields"), "ClientRequestURI") {
+			t.Errorf("plugin field not requested: %s", r.URL.Query().Get("fields"))
+		}
+		jsonBody := []byte(`{"ClientRequestHost": "example.org", "ClientRequestURI": "/customers/42/orders"}
+{"ClientRequestHost": "example.org", "ClientRequestURI": "/customers/42?page=2"}
+{"ClientRequestHost": "example.org", "ClientRequestURI": "/about"}`)
+		if _, err := w.Write(jsonBody); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPI("", "", WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c, err := New(api, []string{"zone-a"}, time.Minute, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := strings.NewReader(`
+		# HELP cloudflare_logs_customer_requests Requests by customer
+		# TYPE cloudflare_logs_customer_requests gauge
+		cloudflare_logs_customer_requests{customer="42",zone="zone-a"} 2
+	`)
+
+	if err := testutil.CollectAndCompare(c, expected, "cloudflare_logs_customer_requests"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCheckPluginFields checks that aggregations reading fields which log
+// entries don't support are rejected.
+func TestCheckPluginFields(t *testing.T) {
+	desc := prometheus.NewDesc("test", "Test", nil, nil)
+	testCases := []struct {
+		condition       string
+		fields          []string
+		isErrorExpected bool
+	}{
+		{"with supported fields", []string{"ClientRequestURI", "RequestHeaders.user-agent"}, false},
+		{"with unsupported field", []string{"Unknown"}, true},
+		{"with object field without key", []string{"RequestHeaders"}, true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			withAggregatorPlugins(t, aggregator.Registration{
+				Name:        "plugin",
+				Aggregation: customerAggregation{desc, c.fields},
+			})
+
+			err := CheckPluginFields()
+			if c.isErrorExpected && err == nil {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if !c.isErrorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
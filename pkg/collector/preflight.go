@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// preflightDelay is how long before now the window of preflight pulls ends,
+// comfortably earlier than the minute required by the Logpull API.
+const preflightDelay = 5 * time.Minute
+
+// VerifyTokenContext checks that the API token of the client is active, using
+// the token verification endpoint. Clients authenticated otherwise are not
+// checked.
+func (api *LogpullAPI) VerifyTokenContext(ctx context.Context) error {
+	api.authMu.RLock()
+	isToken := api.authType == authToken
+	api.authMu.RUnlock()
+
+	if !isToken {
+		return nil
+	}
+
+	resp, err := api.get(ctx, "", api.baseURL+"/user/tokens/verify")
+	if err != nil {
+		return explainPreflightError(err)
+	}
+
+	defer resp.Body.Close()
+
+	var result struct {
+		Result struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("json: %w", err)
+	}
+
+	if result.Result.Status != "active" {
+		return fmt.Errorf("the API token is %s; create a new one", result.Result.Status)
+	}
+	return nil
+}
+
+// PreflightZoneContext pulls a single log entry of a second of the given
+// zone's logs, which is the least the Logpull API allows, to check that the
+// logs can be pulled before any metrics are collected.
+func (api *LogpullAPI) PreflightZoneContext(ctx context.Context, zoneID string) error {
+	end := time.Now().Add(-preflightDelay).Truncate(time.Second)
+	start := end.Add(-time.Second)
+	url := api.baseURL + "/zones/" + zoneID + "/logs/received?start=" + formatLogpullTime(start) +
+		"&end=" + formatLogpullTime(end) + "&count=1&fields=" + strings.Join(resumeLogFields, ",")
+
+	resp, err := api.get(ctx, zoneID, url)
+	if err != nil {
+		return explainPreflightError(err)
+	}
+
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+		return fmt.Errorf("reading api response body: %w", err)
+	}
+	return nil
+}
+
+// explainPreflightError adds the likely cause of the given error of a
+// preflight request, and how to fix it, to the error.
+func explainPreflightError(err error) error {
+	if errors.Is(err, ErrLogRetentionDisabled) {
+		return fmt.Errorf("%w; enable it, or set EXPORTER_RETENTION_CHECK to enable or graphql", err)
+	}
+
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) {
+		return err
+	}
+
+	switch statusErr.statusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("the credentials are invalid or expired: %w", err)
+	case http.StatusForbidden:
+		return fmt.Errorf("the credentials lack the Logs:Read permission for the zone, or access to it: %w", err)
+	case http.StatusBadRequest, http.StatusNotFound:
+		return fmt.Errorf("the zone does not exist, or its ID is wrong: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestVerifyToken checks that inactive API tokens are reported, and that
+// other credentials are not verified.
+func TestVerifyToken(t *testing.T) {
+	status := "active"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/tokens/verify" {
+			t.Errorf("called unexpected endpoint: %s", r.URL.Path)
+		}
+		if _, err := w.Write([]byte(`{"success":true,"result":{"id":"abc","status":"` + status + `"}}`)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	api, err := NewLogpullAPIWithToken(goodToken, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := api.VerifyTokenContext(context.Background()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	status = "expired"
+	if err := api.VerifyTokenContext(context.Background()); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected error for expired token, got %v", err)
+	}
+
+	api, err = NewLogpullAPI(goodKey, goodEmail, WithBaseURL("http://127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := api.VerifyTokenContext(context.Background()); err != nil {
+		t.Errorf("unexpected error for API key: %s", err)
+	}
+}
+
+// TestPreflightZone checks that the errors of preflight pulls name their
+// likely cause.
+func TestPreflightZone(t *testing.T) {
+	testCases := []struct {
+		condition string
+		status    int
+		body      string
+		cause     string
+	}{
+		{"with access", http.StatusOK, "", ""},
+		{"without permission", http.StatusForbidden, `{"success":false}`, "Logs:Read"},
+		{"with retention disabled", http.StatusBadRequest, `{"success":false,"errors":[{"message":"Retention is not turned on"}]}`, "EXPORTER_RETENTION_CHECK"},
+		{"with wrong zone", http.StatusBadRequest, `{"success":false,"errors":[{"message":"Invalid zone identifier"}]}`, "zone does not exist"},
+	}
+
+	for _, c := range testCases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/logs/received") || r.URL.Query().Get("count") != "1" {
+				t.Errorf("called unexpected endpoint: %s", r.URL)
+			}
+			w.WriteHeader(c.status)
+			if _, err := w.Write([]byte(c.body)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}))
+
+		api, err := NewLogpullAPIWithToken(goodToken, WithBaseURL(ts.URL), WithHTTPClient(ts.Client()))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		err = api.PreflightZoneContext(context.Background(), goodZoneID)
+		if c.cause == "" && err != nil {
+			t.Errorf("unexpected error when called %s: %s", c.condition, err)
+		} else if c.cause != "" && (err == nil || !strings.Contains(err.Error(), c.cause)) {
+			t.Errorf("expected error naming %q when called %s, got %v", c.cause, c.condition, err)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// schemaCheckInterval is how often the fields available in a zone's logs are
+// compared against those seen previously.
+const schemaCheckInterval = time.Hour
+
+// zoneSchema is the latest known set of fields available in a zone's logs.
+type zoneSchema struct {
+	checked time.Time
+	fields  map[string]bool
+	changes float64
+	missing float64
+}
+
+// schemaTracker detects when Cloudflare adds, removes or renames the fields
+// available in the logs of each zone, so that pipelines don't silently miss
+// new data or lose fields they rely on. It is safe for concurrent use.
+type schemaTracker struct {
+	mu    sync.Mutex
+	zones map[string]*zoneSchema
+}
+
+func newSchemaTracker() *schemaTracker {
+	return &schemaTracker{zones: make(map[string]*zoneSchema)}
+}
+
+// due reports whether the fields of the given zone should be checked again.
+func (s *schemaTracker) due(zoneID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := s.zones[zoneID]
+	return !ok || time.Since(z.checked) >= schemaCheckInterval
+}
+
+// update records the fields currently available in the given zone's logs, and
+// returns the fields added and removed since the previous update. The first
+// update of a zone establishes its baseline, and reports no changes. requested
+// are the fields the exporter pulls, which are counted as missing if they are
+// no longer available.
+func (s *schemaTracker) update(zoneID string, available, requested []string) (added, removed []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fields := make(map[string]bool, len(available))
+	for _, name := range available {
+		fields[name] = true
+	}
+
+	z, ok := s.zones[zoneID]
+	if !ok {
+		z = &zoneSchema{}
+		s.zones[zoneID] = z
+	} else {
+		for _, name := range available {
+			if !z.fields[name] {
+				added = append(added, name)
+			}
+		}
+		for name := range z.fields {
+			if !fields[name] {
+				removed = append(removed, name)
+			}
+		}
+		sort.Strings(removed)
+		if len(added) > 0 || len(removed) > 0 {
+			z.changes++
+		}
+	}
+
+	z.missing = 0
+	for _, name := range requested {
+		if !fields[name] {
+			z.missing++
+		}
+	}
+
+	z.checked = time.Now()
+	z.fields = fields
+
+	return added, removed
+}
+
+// snapshot returns the number of schema changes detected so far for the given
+// zone, and the number of requested fields missing from its logs.
+func (s *schemaTracker) snapshot(zoneID string) (changes, missing float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := s.zones[zoneID]
+	if !ok {
+		return 0, 0
+	}
+	return z.changes, z.missing
+}
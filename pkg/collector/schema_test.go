@@ -0,0 +1,34 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestSchemaTracker checks that changes to the available fields are detected
+// after the first update, and that missing requested fields are counted.
+func TestSchemaTracker(t *testing.T) {
+	s := newSchemaTracker()
+
+	if !s.due("zone-a") {
+		t.Error("expected check to be due for unknown zone")
+	}
+
+	added, removed := s.update("zone-a", []string{"ClientIP", "EdgeResponseStatus"}, []string{"EdgeResponseStatus"})
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no changes on first update, got %v and %v", added, removed)
+	}
+
+	if s.due("zone-a") {
+		t.Error("expected check not to be due right after an update")
+	}
+
+	added, removed = s.update("zone-a", []string{"ClientIP", "EdgeResponseStatusCode"}, []string{"EdgeResponseStatus"})
+	if !reflect.DeepEqual(added, []string{"EdgeResponseStatusCode"}) || !reflect.DeepEqual(removed, []string{"EdgeResponseStatus"}) {
+		t.Errorf("unexpected changes: %v and %v", added, removed)
+	}
+
+	if changes, missing := s.snapshot("zone-a"); changes != 1 || missing != 1 {
+		t.Errorf("unexpected snapshot: %v changes, %v missing", changes, missing)
+	}
+}
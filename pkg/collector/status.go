@@ -0,0 +1,238 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// zoneStatus is the latest state of a zone's collection, as served by the
+// status API.
+type zoneStatus struct {
+	ZoneID       string     `json:"zone_id"`
+	WindowStart  *time.Time `json:"window_start,omitempty"`
+	WindowEnd    *time.Time `json:"window_end,omitempty"`
+	Requests     float64    `json:"requests"`
+	ServerErrors float64    `json:"server_errors"`
+	ErrorRatio   float64    `json:"error_ratio"`
+	EmptyWindows float64    `json:"empty_windows"`
+	LagSeconds   float64    `json:"lag_seconds,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+
+	// RecentErrors are the latest errors of the zone, newest first.
+	RecentErrors []zoneError `json:"recent_errors,omitempty"`
+
+	// errors is a ring buffer of the latest errors, the oldest of which
+	// is at nextError once it is full.
+	errors    []zoneError
+	nextError int
+}
+
+// zoneError is an error which occurred while collecting a zone, as served by
+// the status API. Its category is the stage at which it occurred, as in the
+// stage label of the error counter.
+type zoneError struct {
+	Time     time.Time `json:"time"`
+	Category string    `json:"category"`
+	Message  string    `json:"message"`
+}
+
+// maxErrorMessageLength is the length, in bytes, beyond which the messages of
+// recent errors are truncated.
+const maxErrorMessageLength = 512
+
+// statusTracker keeps the latest aggregates of every zone, and serves them as
+// JSON so that internal tooling can query the exporter directly instead of
+// going through Prometheus. It is safe for concurrent use.
+type statusTracker struct {
+	mu        sync.Mutex
+	zones     map[string]*zoneStatus
+	maxErrors int
+}
+
+func newStatusTracker(zoneIDs []string) *statusTracker {
+	zones := make(map[string]*zoneStatus, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		zones[zoneID] = &zoneStatus{ZoneID: zoneID}
+	}
+
+	return &statusTracker{zones: zones, maxErrors: 10}
+}
+
+// setMaxErrors sets the number of recent errors kept for every zone, which is
+// 10 by default. A value of zero disables them.
+func (s *statusTracker) setMaxErrors(n int) error {
+	if n < 0 {
+		return errors.New("invalid parameter: n must not be negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxErrors = n
+	for _, z := range s.zones {
+		z.errors = z.recentErrors()
+		if len(z.errors) > n {
+			z.errors = z.errors[:n]
+		}
+		// Keep the ring buffer in chronological order.
+		for i, j := 0, len(z.errors)-1; i < j; i, j = i+1, j-1 {
+			z.errors[i], z.errors[j] = z.errors[j], z.errors[i]
+		}
+		z.nextError = 0
+	}
+	return nil
+}
+
+// setZones replaces the tracked zones, keeping the state of those which
+// remain.
+func (s *statusTracker) setZones(zoneIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zones := make(map[string]*zoneStatus, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		if z, ok := s.zones[zoneID]; ok {
+			zones[zoneID] = z
+		} else {
+			zones[zoneID] = &zoneStatus{ZoneID: zoneID}
+		}
+	}
+	s.zones = zones
+}
+
+// recordSuccess records a successful pull of the given window of a zone's
+// logs, with the number of requests and 5xx responses it contained.
+func (s *statusTracker) recordSuccess(zoneID string, start, end time.Time, requests, serverErrors float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	z := s.zones[zoneID]
+	z.WindowStart = &start
+	z.WindowEnd = &end
+	z.Requests = requests
+	z.ServerErrors = serverErrors
+	z.ErrorRatio = 0
+	if requests > 0 {
+		z.ErrorRatio = serverErrors / requests
+	} else {
+		z.EmptyWindows++
+	}
+	z.LastSuccess = &now
+}
+
+// emptyWindows returns the number of windows pulled without any requests,
+// keyed by zone ID.
+func (s *statusTracker) emptyWindows() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]float64, len(s.zones))
+	for zoneID, z := range s.zones {
+		counts[zoneID] = z.EmptyWindows
+	}
+	return counts
+}
+
+// recordFailure records a failed pull of a zone's logs.
+func (s *statusTracker) recordFailure(zoneID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	z := s.zones[zoneID]
+	z.LastFailure = &now
+	z.LastError = err.Error()
+
+	if s.maxErrors == 0 {
+		return
+	}
+
+	message := err.Error()
+	if len(message) > maxErrorMessageLength {
+		// Back off to the start of a UTF-8 sequence, so that the
+		// message stays valid.
+		n := maxErrorMessageLength
+		for n > 0 && message[n]&0xc0 == 0x80 {
+			n--
+		}
+		message = message[:n] + "..."
+	}
+
+	e := zoneError{Time: now, Category: errorStage(err), Message: message}
+	if len(z.errors) < s.maxErrors {
+		z.errors = append(z.errors, e)
+	} else {
+		z.errors[z.nextError] = e
+		z.nextError = (z.nextError + 1) % len(z.errors)
+	}
+}
+
+// recentErrors returns the errors in the ring buffer of z, newest first.
+func (z *zoneStatus) recentErrors() []zoneError {
+	recent := make([]zoneError, 0, len(z.errors))
+	for i := len(z.errors) - 1; i >= 0; i-- {
+		recent = append(recent, z.errors[(z.nextError+i)%len(z.errors)])
+	}
+	return recent
+}
+
+// lag returns the time elapsed since the end of the latest window of the given
+// zone, and whether a window was collected at all.
+func (s *statusTracker) lag(zoneID string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := s.zones[zoneID]
+	if !ok || z.WindowEnd == nil {
+		return 0, false
+	}
+	return time.Since(*z.WindowEnd), true
+}
+
+// snapshot returns a copy of the status of every zone, sorted by zone ID. The
+// lag of each zone is the time elapsed since the end of its latest window.
+func (s *statusTracker) snapshot() []zoneStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	zones := make([]zoneStatus, 0, len(s.zones))
+	for _, z := range s.zones {
+		status := *z
+		status.RecentErrors = z.recentErrors()
+		status.errors = nil
+		if z.WindowEnd != nil {
+			status.LagSeconds = now.Sub(*z.WindowEnd).Seconds()
+		}
+		zones = append(zones, status)
+	}
+
+	sort.Slice(zones, func(i, j int) bool {
+		return zones[i].ZoneID < zones[j].ZoneID
+	})
+
+	return zones
+}
+
+// ServeHTTP serves the status of every zone as a JSON object.
+func (s *statusTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Zones []zoneStatus `json:"zones"`
+	}{s.snapshot()}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,87 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestStatusTracker checks that the status API serves the latest aggregates
+// and errors of every zone.
+func TestStatusTracker(t *testing.T) {
+	s := newStatusTracker([]string{"zone-b", "zone-a"})
+	s.recordSuccess("zone-a", goodStart, goodEnd, 200, 10)
+	s.recordFailure("zone-b", errors.New("the server's on fire"))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/zones", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+
+	var body struct {
+		Zones []zoneStatus `json:"zones"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(body.Zones) != 2 || body.Zones[0].ZoneID != "zone-a" || body.Zones[1].ZoneID != "zone-b" {
+		t.Fatalf("expected zones sorted by ID, got %v", body.Zones)
+	}
+
+	a := body.Zones[0]
+	if a.Requests != 200 || a.ServerErrors != 10 || a.ErrorRatio != 0.05 {
+		t.Errorf("unexpected aggregates for zone-a: %+v", a)
+	}
+	if a.WindowEnd == nil || !a.WindowEnd.Equal(goodEnd) || a.LagSeconds < time.Since(goodEnd).Seconds()-60 {
+		t.Errorf("unexpected window or lag for zone-a: %+v", a)
+	}
+
+	b := body.Zones[1]
+	if b.LastError != "the server's on fire" || b.LastFailure == nil || b.LastSuccess != nil {
+		t.Errorf("unexpected status for zone-b: %+v", b)
+	}
+}
+
+// TestStatusTrackerRecentErrors checks that the latest errors of a zone are
+// kept, newest first, with their messages truncated.
+func TestStatusTrackerRecentErrors(t *testing.T) {
+	s := newStatusTracker([]string{"zone-a"})
+	if err := s.setMaxErrors(2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s.recordFailure("zone-a", errors.New("first"))
+	s.recordFailure("zone-a", &decodeError{errors.New("second")})
+	s.recordFailure("zone-a", errors.New(strings.Repeat("é", maxErrorMessageLength)))
+
+	recent := s.snapshot()[0].RecentErrors
+	if len(recent) != 2 {
+		t.Fatalf("got %d recent errors, want 2", len(recent))
+	}
+	if recent[0].Category != stagePull || len(recent[0].Message) > maxErrorMessageLength+3 || !utf8.ValidString(recent[0].Message) {
+		t.Errorf("unexpected newest error: %+v", recent[0])
+	}
+	if recent[1].Category != stageDecode || recent[1].Message != "second" {
+		t.Errorf("unexpected oldest error: %+v", recent[1])
+	}
+
+	if err := s.setMaxErrors(1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.recordFailure("zone-a", errors.New("fourth"))
+	if recent := s.snapshot()[0].RecentErrors; len(recent) != 1 || recent[0].Message != "fourth" {
+		t.Errorf("unexpected recent errors after shrinking: %+v", recent)
+	}
+
+	if err := s.setMaxErrors(-1); err == nil {
+		t.Error("expected error when called with negative limit")
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// Aggregator plugins are linked into the exporter by importing their packages
+// in this file for their side effects, e.g.
+//
+//	import _ "example.com/cloudflare-customers"
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// probeFailurePeriod is how long collections may keep failing before the
+// exporter is reported as unhealthy.
+const probeFailurePeriod = 5 * time.Minute
+
+// readinessInterval is how long the outcome of a readiness check is reused,
+// so that frequent probes don't exhaust the Cloudflare API rate limit.
+const readinessInterval = time.Minute
+
+// probes serves the health and readiness endpoints used by orchestrators such
+// as Kubernetes. It is safe for concurrent use.
+type probes struct {
+	readinessCheck func() error
+
+	mu          sync.Mutex
+	started     time.Time
+	lastSuccess time.Time
+	lastFailed  bool
+	checked     time.Time
+	checkErr    error
+}
+
+// newProbes creates a new probes. readinessCheck is called to determine
+// whether the exporter is able to collect its zones, and should verify its
+// access to the Cloudflare API.
+func newProbes(readinessCheck func() error) (*probes, error) {
+	if readinessCheck == nil {
+		return nil, errors.New("invalid parameter: readinessCheck must not be nil")
+	}
+
+	return &probes{
+		readinessCheck: readinessCheck,
+		started:        time.Now(),
+	}, nil
+}
+
+// zoneAccessCheck returns a readiness check verifying that the credentials of
+// the zones collected, as returned by zones, grant access to their logs. Log
+// retention isn't required of the zones in retentionDisabled.
+func zoneAccessCheck(credentials *zoneCredentials, zones func() []string, retentionDisabled *zoneSet) func() error {
+	return func() error {
+		return credentials.each(zones(), func(set *credentialSet, zoneIDs []string) error {
+			return checkZoneAccess(set.cloudflareAPI(), zoneIDs, retentionDisabled.has)
+		})
+	}
+}
+
+// observe records the outcome of a collection; ok is true if all zones were
+// collected successfully.
+func (p *probes) observe(ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastFailed = !ok
+	if ok {
+		p.lastSuccess = time.Now()
+	}
+}
+
+// health returns an error if the latest collection failed, and none has
+// succeeded within probeFailurePeriod. An exporter which isn't being scraped
+// is considered healthy, so that a Prometheus outage doesn't cause restarts.
+func (p *probes) health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.lastFailed {
+		return nil
+	}
+
+	since := p.lastSuccess
+	if since.IsZero() {
+		since = p.started
+	}
+
+	if time.Since(since) > probeFailurePeriod {
+		return fmt.Errorf("collections failing since %s", since.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// readiness returns the outcome of the readiness check, which is only
+// performed again once readinessInterval has passed.
+func (p *probes) readiness() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.checked.IsZero() || time.Since(p.checked) > readinessInterval {
+		p.checkErr = p.readinessCheck()
+		p.checked = time.Now()
+	}
+
+	return p.checkErr
+}
+
+// healthHandler returns an HTTP handler for the health endpoint.
+func (p *probes) healthHandler() http.Handler {
+	return probeHandler(p.health)
+}
+
+// readinessHandler returns an HTTP handler for the readiness endpoint.
+func (p *probes) readinessHandler() http.Handler {
+	return probeHandler(p.readiness)
+}
+
+// probeHandler serves 200 OK if check succeeds, and 503 Service Unavailable
+// with the error otherwise.
+func probeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// TestProbesHealth checks that the health endpoint only fails once
+// collections have been failing for longer than probeFailurePeriod.
+func TestProbesHealth(t *testing.T) {
+	p, err := newProbes(func() error { return nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		condition       string
+		lastSuccess     time.Time
+		lastFailed      bool
+		isErrorExpected bool
+	}{
+		{"before any collection", time.Time{}, false, false},
+		{"after a successful collection", time.Now(), false, false},
+		{"after a recent failure", time.Now().Add(-1 * time.Minute), true, false},
+		{"after sustained failures", time.Now().Add(-1 * time.Hour), true, true},
+	}
+
+	for _, test := range tests {
+		p.lastSuccess = test.lastSuccess
+		p.lastFailed = test.lastFailed
+
+		w := httptest.NewRecorder()
+		p.healthHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		if test.isErrorExpected && w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected error when called %s", test.condition)
+		}
+		if !test.isErrorExpected && w.Code != http.StatusOK {
+			t.Errorf("unexpected status when called %s: %d", test.condition, w.Code)
+		}
+	}
+}
+
+// TestProbesReadiness checks that the readiness endpoint reports the outcome
+// of the readiness check, and reuses it within readinessInterval.
+func TestProbesReadiness(t *testing.T) {
+	calls := 0
+	p, err := newProbes(func() error {
+		calls++
+		return errors.New("invalid token")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		p.readinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("unexpected status: %d", w.Code)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected readiness check to be called once, got %d", calls)
+	}
+
+	if _, err := newProbes(nil); err == nil {
+		t.Error("expected error when called with nil readinessCheck")
+	}
+}
+
+// TestZoneAccessCheck checks that the readiness check fails for zones without
+// log retention, unless they are collected regardless, such as through the
+// GraphQL Analytics API.
+func TestZoneAccessCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zones/zone-graphql/logs/control/retention/flag" {
+			http.Error(w, `{"success": false, "errors": [{"code": 1001, "message": "Invalid zone identifier"}]}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"success": true, "result": {"flag": false}}`)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfapi, err := cloudflare.NewWithAPIToken(goodToken, cloudflare.HTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cfapi.BaseURL = ts.URL
+
+	set := &credentialSet{cfapi: cfapi}
+	credentials := &zoneCredentials{}
+	credentials.set(map[string]*credentialSet{"zone-graphql": set, "zone-unknown": set})
+
+	testCases := []struct {
+		condition         string
+		zoneIDs           []string
+		retentionDisabled map[string]bool
+		isErrorExpected   bool
+	}{
+		{"with a GraphQL zone", []string{"zone-graphql"}, map[string]bool{"zone-graphql": true}, false},
+		{"with a zone without log retention", []string{"zone-graphql"}, nil, true},
+		{"with an inaccessible zone", []string{"zone-unknown"}, map[string]bool{"zone-unknown": true}, true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			retentionDisabled := &zoneSet{}
+			retentionDisabled.set(c.retentionDisabled)
+
+			p, err := newProbes(zoneAccessCheck(credentials, func() []string { return c.zoneIDs }, retentionDisabled))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			w := httptest.NewRecorder()
+			p.readinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if c.isErrorExpected && w.Code != http.StatusServiceUnavailable {
+				t.Errorf("expected error when called %s", c.condition)
+			}
+			if !c.isErrorExpected && w.Code != http.StatusOK {
+				t.Errorf("unexpected status when called %s: %d %s", c.condition, w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	prommodel "github.com/prometheus/common/model"
+)
+
+// relabelConfig is a relabeling rule applied to the exposed series, in the
+// format of the metric_relabel_configs of Prometheus. The metric name is
+// available as the __name__ label, but can't be changed.
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+// The supported relabeling actions.
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+)
+
+// relabelRule is a compiled relabelConfig.
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// compileRelabelConfigs validates the given relabeling rules, filling in the
+// defaults of Prometheus: the replace action, the separator ";", the regular
+// expression "(.*)" and the replacement "$1".
+func compileRelabelConfigs(configs []relabelConfig) ([]relabelRule, error) {
+	rules := make([]relabelRule, len(configs))
+	for i, cfg := range configs {
+		rule := relabelRule{
+			sourceLabels: cfg.SourceLabels,
+			separator:    ";",
+			targetLabel:  cfg.TargetLabel,
+			replacement:  "$1",
+			action:       cfg.Action,
+		}
+		if cfg.Separator != nil {
+			rule.separator = *cfg.Separator
+		}
+		if cfg.Replacement != nil {
+			rule.replacement = *cfg.Replacement
+		}
+		if rule.action == "" {
+			rule.action = relabelReplace
+		}
+
+		regex := "(.*)"
+		if cfg.Regex != nil {
+			regex = *cfg.Regex
+		}
+		var err error
+		if rule.regex, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+			return nil, fmt.Errorf("relabel rule %d: compiling regex: %w", i, err)
+		}
+
+		switch rule.action {
+		case relabelReplace:
+			if !prommodel.LabelName(rule.targetLabel).IsValid() || rule.targetLabel == prommodel.MetricNameLabel {
+				return nil, fmt.Errorf("relabel rule %d: invalid target label %q", i, rule.targetLabel)
+			}
+		case relabelKeep, relabelDrop:
+			if len(rule.sourceLabels) == 0 {
+				return nil, fmt.Errorf("relabel rule %d: %s requires source labels", i, rule.action)
+			}
+		case relabelLabelDrop, relabelLabelKeep:
+		default:
+			return nil, fmt.Errorf("relabel rule %d: unsupported action %q", i, rule.action)
+		}
+
+		rules[i] = rule
+	}
+
+	return rules, nil
+}
+
+// apply applies the rule to the given labels, which include the metric name
+// as __name__, and reports whether the series is kept.
+func (r relabelRule) apply(labels map[string]string) bool {
+	values := make([]string, len(r.sourceLabels))
+	for i, name := range r.sourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.action {
+	case relabelKeep:
+		return r.regex.MatchString(value)
+	case relabelDrop:
+		return !r.regex.MatchString(value)
+	case relabelLabelDrop, relabelLabelKeep:
+		for name := range labels {
+			if name != prommodel.MetricNameLabel && r.regex.MatchString(name) == (r.action == relabelLabelDrop) {
+				delete(labels, name)
+			}
+		}
+	default:
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		result := string(r.regex.ExpandString(nil, r.replacement, value, match))
+		if result == "" {
+			delete(labels, r.targetLabel)
+		} else {
+			labels[r.targetLabel] = result
+		}
+	}
+
+	return true
+}
+
+// relabelGatherer applies relabeling rules to the series gathered by another
+// prometheus.Gatherer.
+type relabelGatherer struct {
+	next prometheus.Gatherer
+
+	mu    sync.RWMutex
+	rules []relabelRule
+}
+
+// newRelabelGatherer creates a new relabelGatherer for the given gatherer and
+// relabeling rules.
+func newRelabelGatherer(next prometheus.Gatherer, configs []relabelConfig) (*relabelGatherer, error) {
+	g := &relabelGatherer{next: next}
+	if err := g.setRules(configs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// setRules replaces the relabeling rules while the gatherer is in use.
+func (g *relabelGatherer) setRules(configs []relabelConfig) error {
+	rules, err := compileRelabelConfigs(configs)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.rules = rules
+	return nil
+}
+
+// Gather is a required method of the prometheus.Gatherer interface. Series
+// whose labels become identical after relabeling are reported as an error,
+// along with the remaining series, as Prometheus would reject them.
+func (g *relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.next.Gather()
+
+	g.mu.RLock()
+	rules := g.rules
+	g.mu.RUnlock()
+
+	if len(rules) == 0 {
+		return families, err
+	}
+
+	var errs prometheus.MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	kept := families[:0]
+	for _, family := range families {
+		seen := make(map[uint64]bool)
+		metrics := family.Metric[:0]
+
+	metrics:
+		for _, m := range family.Metric {
+			labels := map[string]string{prommodel.MetricNameLabel: family.GetName()}
+			for _, pair := range m.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+
+			for _, rule := range rules {
+				if !rule.apply(labels) {
+					continue metrics
+				}
+			}
+
+			m.Label = labelPairs(labels)
+			signature := prommodel.LabelsToSignature(labels)
+			if seen[signature] {
+				errs = append(errs, fmt.Errorf("duplicate series of %s after relabeling: %v", family.GetName(), labels))
+				continue
+			}
+			seen[signature] = true
+			metrics = append(metrics, m)
+		}
+
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			kept = append(kept, family)
+		}
+	}
+
+	if len(errs) > 0 {
+		return kept, errs
+	}
+	return kept, nil
+}
+
+// labelPairs returns the given labels, except for the metric name, as label
+// pairs sorted by name.
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != prommodel.MetricNameLabel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]*dto.LabelPair, len(names))
+	for i, name := range names {
+		name, value := name, labels[name]
+		pairs[i] = &dto.LabelPair{Name: &name, Value: &value}
+	}
+	return pairs
+}
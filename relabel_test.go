@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestRelabelGatherer checks that the relabeling rules are applied in order
+// to every series, and can be replaced.
+func TestRelabelGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	responses := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_logs_http_responses",
+		Help: "Test metric",
+	}, []string{"client_request_host", "edge_response_status"})
+	registry.MustRegister(responses)
+
+	responses.WithLabelValues("example.org", "200").Set(3)
+	responses.WithLabelValues("staging.example.org", "200").Set(2)
+	responses.WithLabelValues("example.org", "404").Set(1)
+
+	regex := func(s string) *string { return &s }
+	g, err := newRelabelGatherer(registry, []relabelConfig{
+		{SourceLabels: []string{"client_request_host"}, Regex: regex(`staging\..*`), Action: "drop"},
+		{SourceLabels: []string{"__name__", "edge_response_status"}, Regex: regex(`.*_responses;(\d)\d\d`), TargetLabel: "edge_response_class", Replacement: regex("${1}xx")},
+		{Regex: regex("edge_response_status"), Action: "labeldrop"},
+		{TargetLabel: "environment", Replacement: regex("production")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `
+# HELP cloudflare_logs_http_responses Test metric
+# TYPE cloudflare_logs_http_responses gauge
+cloudflare_logs_http_responses{client_request_host="example.org",edge_response_class="2xx",environment="production"} 3
+cloudflare_logs_http_responses{client_request_host="example.org",edge_response_class="4xx",environment="production"} 1
+`
+	if err := testutil.GatherAndCompare(g, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if err := g.setRules([]relabelConfig{{Regex: regex("edge_response_status"), Action: "labeldrop"}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := g.Gather(); err == nil {
+		t.Error("expected error when series are duplicated after relabeling")
+	}
+
+	if err := g.setRules(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count := testutil.CollectAndCount(responses); count != 3 {
+		t.Errorf("got %d series, want 3", count)
+	}
+}
+
+// TestCompileRelabelConfigsErrors checks that invalid rules are rejected.
+func TestCompileRelabelConfigsErrors(t *testing.T) {
+	regex := "("
+
+	testCases := []struct {
+		condition string
+		config    relabelConfig
+	}{
+		{"with invalid regex", relabelConfig{Regex: &regex, TargetLabel: "label"}},
+		{"without target label", relabelConfig{SourceLabels: []string{"label"}}},
+		{"with metric name as target label", relabelConfig{TargetLabel: "__name__"}},
+		{"with keep action without source labels", relabelConfig{Action: "keep"}},
+		{"with unknown action", relabelConfig{Action: "hashmod"}},
+	}
+
+	for _, c := range testCases {
+		if _, err := compileRelabelConfigs([]relabelConfig{c.config}); err == nil {
+			t.Errorf("expected error when called %s", c.condition)
+		}
+	}
+}
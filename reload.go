@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnSignal calls reload every time the process receives SIGHUP, and
+// passes any error to errorHandler. It never returns.
+func reloadOnSignal(reload func() error, errorHandler func(error)) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	for range ch {
+		if err := reload(); err != nil {
+			errorHandler(err)
+		}
+	}
+}
+
+// reloadHandler returns an HTTP handler which calls reload on POST requests,
+// and serves 500 Internal Server Error with the error if it fails.
+func reloadHandler(reload func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+}
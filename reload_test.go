@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestReloadHandler checks that the reload endpoint only reloads on POST
+// requests, and reports reload failures.
+func TestReloadHandler(t *testing.T) {
+	calls := 0
+	var reloadErr error
+	h := reloadHandler(func() error {
+		calls++
+		return reloadErr
+	})
+
+	tests := []struct {
+		condition    string
+		method       string
+		reloadErr    error
+		expectedCode int
+		expectedCall bool
+	}{
+		{"with GET", http.MethodGet, nil, http.StatusMethodNotAllowed, false},
+		{"with POST", http.MethodPost, nil, http.StatusOK, true},
+		{"with failing reload", http.MethodPost, errors.New("invalid config"), http.StatusInternalServerError, true},
+	}
+
+	for _, test := range tests {
+		calls = 0
+		reloadErr = test.reloadErr
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, "/-/reload", nil))
+
+		if w.Code != test.expectedCode {
+			t.Errorf("unexpected status when called %s: %d", test.condition, w.Code)
+		}
+		if (calls == 1) != test.expectedCall {
+			t.Errorf("unexpected number of reloads when called %s: %d", test.condition, calls)
+		}
+	}
+}
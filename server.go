@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// listenerConfig configures an address on which the exporter serves HTTP
+// requests. TLS is enabled if a certificate and key are given, and clients
+// must present a certificate signed by the client CA if one is given. HTTP
+// basic authentication is required if a username is given. Only the
+// endpoints of the given handlers are served, or all of them if none are
+// given, so that metrics can be served apart from admin endpoints.
+type listenerConfig struct {
+	Address           string   `yaml:"address"`
+	TLSCertFile       string   `yaml:"tls_cert_file"`
+	TLSKeyFile        string   `yaml:"tls_key_file"`
+	TLSClientCAFile   string   `yaml:"tls_client_ca_file"`
+	BasicAuthUsername string   `yaml:"basic_auth_username"`
+	BasicAuthPassword string   `yaml:"basic_auth_password"`
+	Handlers          []string `yaml:"handlers"`
+}
+
+// The handlers which listeners may serve, each a group of endpoints.
+const (
+	// handlerMetrics serves /metrics.
+	handlerMetrics = "metrics"
+
+	// handlerStatus serves /api/v1/zones.
+	handlerStatus = "status"
+
+	// handlerHealth serves /healthz and /readyz.
+	handlerHealth = "health"
+
+	// handlerAdmin serves /-/reload, /debug/vars and /debug/pprof/, as far
+	// as they are enabled.
+	handlerAdmin = "admin"
+)
+
+// listenerHandlers are the handlers served by listeners without handlers.
+var listenerHandlers = []string{handlerMetrics, handlerStatus, handlerHealth, handlerAdmin}
+
+// endpoints maps the handlers which listeners may serve to their endpoints,
+// keyed by pattern.
+type endpoints map[string]map[string]http.Handler
+
+// listenersFromAddrs creates plain listener configurations from a
+// comma-separated list of addresses.
+func listenersFromAddrs(addrs string) []listenerConfig {
+	var listeners []listenerConfig
+	for _, addr := range strings.Split(addrs, ",") {
+		listeners = append(listeners, listenerConfig{Address: strings.TrimSpace(addr)})
+	}
+	return listeners
+}
+
+// validate checks that the listener configuration is complete.
+func (l listenerConfig) validate() error {
+	if l.Address == "" {
+		return errors.New("address must not be empty")
+	}
+
+	if (l.TLSCertFile == "") != (l.TLSKeyFile == "") {
+		return fmt.Errorf("%s: tls_cert_file and tls_key_file must be specified together", l.Address)
+	}
+
+	if l.TLSClientCAFile != "" && l.TLSCertFile == "" {
+		return fmt.Errorf("%s: tls_client_ca_file specified without tls_cert_file", l.Address)
+	}
+
+	if l.BasicAuthUsername == "" && l.BasicAuthPassword != "" {
+		return fmt.Errorf("%s: basic_auth_password specified without basic_auth_username", l.Address)
+	}
+
+	seen := make(map[string]bool)
+	for _, h := range l.Handlers {
+		if !isListenerHandler(h) {
+			return fmt.Errorf("%s: unknown handler %q, must be one of %s", l.Address, h, strings.Join(listenerHandlers, ", "))
+		}
+		if seen[h] {
+			return fmt.Errorf("%s: handler %q specified twice", l.Address, h)
+		}
+		seen[h] = true
+	}
+
+	return nil
+}
+
+// isListenerHandler reports whether h is one of listenerHandlers.
+func isListenerHandler(h string) bool {
+	for _, known := range listenerHandlers {
+		if h == known {
+			return true
+		}
+	}
+	return false
+}
+
+// handler returns a handler serving the given endpoints of the listener's
+// handlers. A dedicated mux is used, since importing net/http/pprof registers
+// its handlers with http.DefaultServeMux unconditionally.
+func (l listenerConfig) handler(e endpoints) http.Handler {
+	handlers := l.Handlers
+	if len(handlers) == 0 {
+		handlers = listenerHandlers
+	}
+
+	mux := http.NewServeMux()
+	for _, h := range handlers {
+		for pattern, handler := range e[h] {
+			mux.Handle(pattern, handler)
+		}
+	}
+	return mux
+}
+
+// server returns an HTTP server for the listener, which serves handler.
+func (l listenerConfig) server(handler http.Handler) (*http.Server, error) {
+	if l.BasicAuthUsername != "" {
+		handler = basicAuthHandler(l.BasicAuthUsername, l.BasicAuthPassword, handler)
+	}
+
+	srv := &http.Server{
+		Addr:    l.Address,
+		Handler: handler,
+	}
+
+	if l.TLSClientCAFile != "" {
+		pem, err := ioutil.ReadFile(l.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: reading client CA: %w", l.Address, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found in %s", l.Address, l.TLSClientCAFile)
+		}
+
+		srv.TLSConfig = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+		}
+	}
+
+	return srv, nil
+}
+
+// serve serves the given endpoints on all of the given listeners, as far as
+// their handlers include them, and returns as soon as any of them fails.
+func serve(listeners []listenerConfig, e endpoints, logf func(format string, v ...interface{})) error {
+	if len(listeners) == 0 {
+		return errors.New("invalid parameter: listeners must not be empty")
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	for i, l := range listeners {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("invalid listener: %w", err)
+		}
+
+		srv, err := l.server(l.handler(e))
+		if err != nil {
+			return fmt.Errorf("invalid listener: %w", err)
+		}
+		servers[i] = srv
+	}
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(l listenerConfig, srv *http.Server) {
+			switch {
+			case l.TLSClientCAFile != "":
+				logf("Listening on %s (mutual TLS)", l.Address)
+				errs <- srv.ListenAndServeTLS(l.TLSCertFile, l.TLSKeyFile)
+			case l.TLSCertFile != "":
+				logf("Listening on %s (TLS)", l.Address)
+				errs <- srv.ListenAndServeTLS(l.TLSCertFile, l.TLSKeyFile)
+			default:
+				logf("Listening on %s", l.Address)
+				errs <- srv.ListenAndServe()
+			}
+		}(l, servers[i])
+	}
+
+	return <-errs
+}
+
+// basicAuthHandler wraps handler, requiring requests to authenticate with the
+// given username and password.
+func basicAuthHandler(username, password string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		userOk := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+		passOk := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOk || !passOk {
+			w.Header().Set("WWW-Authenticate", `Basic realm="cloudflare-logpull-exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestListenersFromAddrs checks that a comma-separated list of addresses is
+// split into listener configurations.
+func TestListenersFromAddrs(t *testing.T) {
+	got := listenersFromAddrs("0.0.0.0:9299, [::]:9299")
+	want := []listenerConfig{{Address: "0.0.0.0:9299"}, {Address: "[::]:9299"}}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestListenerConfigValidate checks that incomplete listener configurations
+// are rejected.
+func TestListenerConfigValidate(t *testing.T) {
+	testCases := []struct {
+		condition       string
+		listener        listenerConfig
+		isErrorExpected bool
+	}{
+		{"with address only", listenerConfig{Address: ":9299"}, false},
+		{"with TLS", listenerConfig{Address: ":9299", TLSCertFile: "crt", TLSKeyFile: "key"}, false},
+		{"with mutual TLS", listenerConfig{Address: ":9299", TLSCertFile: "crt", TLSKeyFile: "key", TLSClientCAFile: "ca"}, false},
+		{"with basic auth", listenerConfig{Address: ":9299", BasicAuthUsername: "user", BasicAuthPassword: "pass"}, false},
+		{"without address", listenerConfig{}, true},
+		{"with TLS certificate but no key", listenerConfig{Address: ":9299", TLSCertFile: "crt"}, true},
+		{"with client CA but no TLS", listenerConfig{Address: ":9299", TLSClientCAFile: "ca"}, true},
+		{"with basic auth password but no username", listenerConfig{Address: ":9299", BasicAuthPassword: "pass"}, true},
+		{"with handlers", listenerConfig{Address: ":9299", Handlers: []string{"metrics", "health"}}, false},
+		{"with unknown handler", listenerConfig{Address: ":9299", Handlers: []string{"pprof"}}, true},
+		{"with duplicate handler", listenerConfig{Address: ":9299", Handlers: []string{"admin", "admin"}}, true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			err := c.listener.validate()
+			if err == nil && c.isErrorExpected {
+				t.Errorf("expected error when called %s", c.condition)
+			} else if err != nil && !c.isErrorExpected {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+// TestListenerConfigHandler checks that listeners only serve the endpoints
+// of their handlers, and all endpoints without handlers.
+func TestListenerConfigHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	e := endpoints{
+		handlerMetrics: {"/metrics": ok},
+		handlerStatus:  {"/api/v1/zones": ok},
+		handlerHealth:  {"/healthz": ok, "/readyz": ok},
+		handlerAdmin:   {"/-/reload": ok},
+	}
+
+	testCases := []struct {
+		condition string
+		handlers  []string
+		served    []string
+		notServed []string
+	}{
+		{"without handlers", nil, []string{"/metrics", "/api/v1/zones", "/healthz", "/readyz", "/-/reload"}, nil},
+		{"with metrics handler", []string{"metrics"}, []string{"/metrics"}, []string{"/api/v1/zones", "/healthz", "/-/reload"}},
+		{"with admin handlers", []string{"health", "admin"}, []string{"/healthz", "/readyz", "/-/reload"}, []string{"/metrics", "/api/v1/zones"}},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			handler := listenerConfig{Address: ":9299", Handlers: c.handlers}.handler(e)
+			for _, path := range c.served {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("expected %s to be served, got status %d", path, rec.Code)
+				}
+			}
+			for _, path := range c.notServed {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != http.StatusNotFound {
+					t.Errorf("expected %s not to be served, got status %d", path, rec.Code)
+				}
+			}
+		})
+	}
+}
+
+// TestBasicAuthHandler checks that requests are only passed through with the
+// correct credentials.
+func TestBasicAuthHandler(t *testing.T) {
+	handler := basicAuthHandler("user", "pass", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		condition      string
+		username       string
+		password       string
+		setAuth        bool
+		expectedStatus int
+	}{
+		{"with valid credentials", "user", "pass", true, http.StatusOK},
+		{"with invalid username", "garbage", "pass", true, http.StatusUnauthorized},
+		{"with invalid password", "user", "garbage", true, http.StatusUnauthorized},
+		{"without credentials", "", "", false, http.StatusUnauthorized},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if c.setAuth {
+				r.SetBasicAuth(c.username, c.password)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != c.expectedStatus {
+				t.Errorf("expected status %d, got %d", c.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+// TestListenerConfigServer checks that mutual TLS requires client
+// certificates signed by the configured CA, and that unusable CA files are
+// rejected.
+func TestListenerConfigServer(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	caFile, err := ioutil.TempFile("", "client-ca")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.Remove(caFile.Name())
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if _, err := caFile.Write(cert); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	caFile.Close()
+
+	l := listenerConfig{Address: ":9299", TLSCertFile: "crt", TLSKeyFile: "key", TLSClientCAFile: caFile.Name()}
+	srv, err := l.server(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if srv.TLSConfig == nil || srv.TLSConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("expected client certificates to be required")
+	}
+
+	for _, file := range []string{"/nonexistent", os.DevNull} {
+		l.TLSClientCAFile = file
+		if _, err := l.server(http.NotFoundHandler()); err == nil {
+			t.Errorf("expected error when called with client CA %s", file)
+		}
+	}
+}
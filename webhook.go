@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+)
+
+// webhookTimeout bounds how long a single webhook notification may take.
+const webhookTimeout = 10 * time.Second
+
+// webhookNotifier posts Slack-compatible notifications to a webhook URL when a
+// zone keeps failing to be collected, when its lag exceeds a limit, or when
+// log retention is found to be disabled for it. Each condition is only
+// notified once, when it starts, and again when it is resolved. It is safe for
+// concurrent use.
+type webhookNotifier struct {
+	url          string
+	httpClient   *http.Client
+	threshold    int
+	errorHandler func(error)
+
+	// zoneLabel returns the zone label of a zone, by which notifications
+	// name it.
+	zoneLabel func(zoneID string) string
+
+	// lag returns the time elapsed since the end of the latest window
+	// collected for a zone, if any, which is notified once it reaches
+	// lagLimit, unless lagLimit is zero.
+	lag      func(zoneID string) (time.Duration, bool)
+	lagLimit time.Duration
+
+	mu                sync.Mutex
+	failures          map[string]int
+	retentionDisabled map[string]bool
+	lagging           map[string]bool
+}
+
+// newWebhookNotifier creates a new webhookNotifier, which notifies once a zone
+// has failed threshold consecutive times. Errors delivering notifications are
+// passed to errorHandler.
+func newWebhookNotifier(url string, threshold int, errorHandler func(error)) (*webhookNotifier, error) {
+	if url == "" {
+		return nil, errors.New("invalid parameter: url must not be empty")
+	}
+
+	if threshold < 1 {
+		return nil, errors.New("invalid parameter: threshold must be positive")
+	}
+
+	return &webhookNotifier{
+		url:               url,
+		httpClient:        &http.Client{Timeout: webhookTimeout},
+		threshold:         threshold,
+		errorHandler:      errorHandler,
+		zoneLabel:         func(zoneID string) string { return zoneID },
+		failures:          make(map[string]int),
+		retentionDisabled: make(map[string]bool),
+		lagging:           make(map[string]bool),
+	}, nil
+}
+
+// setTransport makes requests use the given transport, instead of
+// http.DefaultTransport.
+func (n *webhookNotifier) setTransport(transport http.RoundTripper) {
+	n.httpClient.Transport = transport
+}
+
+// setZoneLabel makes notifications name zones by the given function, such as
+// the zone label of their metrics, instead of by their IDs.
+func (n *webhookNotifier) setZoneLabel(zoneLabel func(zoneID string) string) {
+	n.zoneLabel = zoneLabel
+}
+
+// setLagLimit notifies when the lag of a zone, as returned by the given
+// function, reaches the given limit. Returns an error if the limit is not
+// positive.
+func (n *webhookNotifier) setLagLimit(limit time.Duration, lag func(zoneID string) (time.Duration, bool)) error {
+	if limit <= 0 {
+		return errors.New("invalid parameter: limit must be positive")
+	}
+
+	n.lagLimit = limit
+	n.lag = lag
+	return nil
+}
+
+// observe records the outcome of collecting the given zone and sends any
+// resulting notifications in the background.
+func (n *webhookNotifier) observe(zoneID string, err error) {
+	var messages []string
+	zone := n.zoneLabel(zoneID)
+
+	n.mu.Lock()
+	if err == nil {
+		if n.failures[zoneID] >= n.threshold {
+			messages = append(messages, fmt.Sprintf("Zone %s recovered and is being collected again.", zone))
+		}
+		n.failures[zoneID] = 0
+		n.retentionDisabled[zoneID] = false
+	} else {
+		n.failures[zoneID]++
+		if n.failures[zoneID] == n.threshold {
+			messages = append(messages, fmt.Sprintf("Zone %s failed to be collected %d times in a row: %s", zone, n.threshold, err))
+		}
+		if errors.Is(err, collector.ErrLogRetentionDisabled) && !n.retentionDisabled[zoneID] {
+			n.retentionDisabled[zoneID] = true
+			messages = append(messages, fmt.Sprintf("Log retention is disabled for zone %s. No metrics can be collected for it until it is enabled.", zone))
+		}
+	}
+	if n.lagLimit > 0 {
+		// The lag of zones which were never collected is unknown, and
+		// left to the failure notifications.
+		if lag, ok := n.lag(zoneID); ok {
+			switch {
+			case lag >= n.lagLimit && !n.lagging[zoneID]:
+				n.lagging[zoneID] = true
+				messages = append(messages, fmt.Sprintf("Zone %s lags %s behind, over the limit of %s.", zone, lag.Truncate(time.Second), n.lagLimit))
+			case lag < n.lagLimit && n.lagging[zoneID]:
+				n.lagging[zoneID] = false
+				messages = append(messages, fmt.Sprintf("Zone %s caught up and lags %s behind.", zone, lag.Truncate(time.Second)))
+			}
+		}
+	}
+	n.mu.Unlock()
+
+	for _, msg := range messages {
+		go func(msg string) {
+			if err := n.notify(msg); err != nil {
+				n.errorHandler(err)
+			}
+		}(msg)
+	}
+}
+
+// notify posts the given message to the webhook.
+func (n *webhookNotifier) notify(text string) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	if err != nil {
+		return fmt.Errorf("encoding webhook payload: %w", err)
+	}
+
+	resp, err := n.httpClient.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("performing webhook request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading webhook response body: %w", err)
+		}
+		return fmt.Errorf("unexpected webhook response: %s: %s", resp.Status, respBody)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+)
+
+// TestWebhookNotifier checks that the notifier posts exactly one notification
+// when a zone reaches the failure threshold or has log retention disabled, and
+// one when it recovers.
+func TestWebhookNotifier(t *testing.T) {
+	messages := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		messages <- payload.Text
+	}))
+	defer ts.Close()
+
+	n, err := newWebhookNotifier(ts.URL, 2, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectMessage := func(substr string) {
+		t.Helper()
+		select {
+		case msg := <-messages:
+			if !strings.Contains(msg, substr) {
+				t.Errorf("expected message containing %q, got %q", substr, msg)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected message containing %q", substr)
+		}
+	}
+
+	failure := errors.New("the server's on fire")
+	n.observe("zone-a", failure)
+	n.observe("zone-a", failure)
+	expectMessage("failed to be collected 2 times")
+	n.observe("zone-a", failure)
+	n.observe("zone-a", nil)
+	expectMessage("recovered")
+
+	n.observe("zone-b", collector.ErrLogRetentionDisabled)
+	expectMessage("Log retention is disabled")
+	n.observe("zone-b", collector.ErrLogRetentionDisabled)
+	expectMessage("failed to be collected 2 times")
+
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected message: %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestWebhookNotifierLag checks that the notifier posts one notification when
+// the lag of a zone reaches the limit, and one when it falls below it again,
+// naming the zone by its label.
+func TestWebhookNotifierLag(t *testing.T) {
+	messages := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		messages <- payload.Text
+	}))
+	defer ts.Close()
+
+	n, err := newWebhookNotifier(ts.URL, 3, func(err error) {
+		t.Errorf("unexpected error: %s", err)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lags := map[string]time.Duration{}
+	n.setZoneLabel(func(zoneID string) string {
+		return "example.org"
+	})
+	if err := n.setLagLimit(0, nil); err == nil {
+		t.Error("expected error when called with a zero limit")
+	}
+	if err := n.setLagLimit(time.Hour, func(zoneID string) (time.Duration, bool) {
+		lag, ok := lags[zoneID]
+		return lag, ok
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectMessage := func(substr string) {
+		t.Helper()
+		select {
+		case msg := <-messages:
+			if !strings.Contains(msg, substr) {
+				t.Errorf("expected message containing %q, got %q", substr, msg)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected message containing %q", substr)
+		}
+	}
+
+	failure := errors.New("the server's on fire")
+	n.observe("zone-a", failure)
+	lags["zone-a"] = 30 * time.Minute
+	n.observe("zone-a", nil)
+	lags["zone-a"] = 90 * time.Minute
+	n.observe("zone-a", failure)
+	expectMessage("Zone example.org lags 1h30m0s behind")
+	lags["zone-a"] = 2 * time.Hour
+	n.observe("zone-a", failure)
+	lags["zone-a"] = time.Minute
+	n.observe("zone-a", nil)
+	expectMessage("Zone example.org caught up")
+
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected message: %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/bitgo/cloudflare-logpull-exporter/pkg/collector"
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// zoneFilter selects zones by name. A zone is selected if its name matches
+// include, if set, and does not match exclude, if set.
+type zoneFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newZoneFilter compiles the given include and exclude patterns, either of
+// which may be empty.
+func newZoneFilter(include, exclude string) (*zoneFilter, error) {
+	var f zoneFilter
+	var err error
+
+	if include != "" {
+		if f.include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("compiling include pattern: %w", err)
+		}
+	}
+
+	if exclude != "" {
+		if f.exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("compiling exclude pattern: %w", err)
+		}
+	}
+
+	return &f, nil
+}
+
+// matches reports whether the zone with the given name is selected.
+func (f *zoneFilter) matches(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// discoverZones lists all active zones accessible to the given Cloudflare API
+// client which are selected by filter, and returns their names keyed by ID.
+func discoverZones(cfapi *cloudflare.API, filter *zoneFilter) (map[string]string, error) {
+	zones, err := cfapi.ListZones()
+	if err != nil {
+		return nil, fmt.Errorf("listing zones: %w", err)
+	}
+
+	zoneNames := make(map[string]string)
+	for _, zone := range zones {
+		if zone.Status == "active" && filter.matches(zone.Name) {
+			zoneNames[zone.ID] = zone.Name
+		}
+	}
+
+	return zoneNames, nil
+}
+
+// checkZoneAccess verifies that the credentials of cfapi grant access to the
+// logs of every given zone, and that log retention is enabled for them,
+// unless it is known to be disabled, as reported by retentionDisabled: such
+// zones are collected through the GraphQL Analytics API, or were accepted
+// without log retention by the retention check.
+func checkZoneAccess(cfapi *cloudflare.API, zoneIDs []string, retentionDisabled func(zoneID string) bool) error {
+	for _, zoneID := range zoneIDs {
+		retention, err := cfapi.GetLogpullRetentionFlag(zoneID)
+		if err != nil {
+			return fmt.Errorf("checking log retention of zone %s: %w", zoneID, err)
+		}
+		if !retention.Flag && !retentionDisabled(zoneID) {
+			return fmt.Errorf("log retention is disabled for zone %s", zoneID)
+		}
+	}
+
+	return nil
+}
+
+// zoneSet is a set of zone IDs which may be replaced while it is in use, such
+// as on reload. It is safe for concurrent use.
+type zoneSet struct {
+	mu      sync.RWMutex
+	zoneIDs map[string]bool
+}
+
+// set replaces the zones of the set.
+func (s *zoneSet) set(zoneIDs map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.zoneIDs = zoneIDs
+}
+
+// has reports whether the given zone is in the set.
+func (s *zoneSet) has(zoneID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.zoneIDs[zoneID]
+}
+
+// fetchZoneAccounts fetches the details of the given zones, and returns the
+// IDs of their accounts, keyed by zone ID.
+func fetchZoneAccounts(cfapi *cloudflare.API, zoneIDs []string) (map[string]string, error) {
+	accounts := make(map[string]string, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		zone, err := cfapi.ZoneDetails(zoneID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching details of zone %s: %w", zoneID, err)
+		}
+		accounts[zoneID] = zone.Account.ID
+	}
+
+	return accounts, nil
+}
+
+// fetchZoneMetadata fetches the details of the given zones, and returns the
+// values of the given zone metadata labels, keyed by zone ID and label name.
+func fetchZoneMetadata(cfapi *cloudflare.API, zoneIDs []string, labels []string) (map[string]map[string]string, error) {
+	metadata := make(map[string]map[string]string, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		zone, err := cfapi.ZoneDetails(zoneID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching details of zone %s: %w", zoneID, err)
+		}
+
+		values := make(map[string]string, len(labels))
+		for _, label := range labels {
+			if value, ok := collector.ZoneMetadataLabels[label]; ok {
+				values[label] = value(zone)
+			}
+		}
+		metadata[zoneID] = values
+	}
+
+	return metadata, nil
+}
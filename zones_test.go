@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// TestZoneFilter checks that zone names are selected according to the include
+// and exclude patterns.
+func TestZoneFilter(t *testing.T) {
+	testCases := []struct {
+		condition string
+		include   string
+		exclude   string
+		name      string
+		expected  bool
+	}{
+		{"without patterns", "", "", "example.org", true},
+		{"with matching include pattern", `\.org$`, "", "example.org", true},
+		{"with non-matching include pattern", `\.com$`, "", "example.org", false},
+		{"with matching exclude pattern", "", `^staging\.`, "staging.example.org", false},
+		{"with non-matching exclude pattern", "", `^staging\.`, "example.org", true},
+		{"with both patterns matching", `\.org$`, `^staging\.`, "staging.example.org", false},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.condition, func(t *testing.T) {
+			f, err := newZoneFilter(c.include, c.exclude)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got := f.matches(c.name); got != c.expected {
+				t.Errorf("matches(%q) = %t, want %t", c.name, got, c.expected)
+			}
+		})
+	}
+}
+
+// TestZoneFilterErrors checks that invalid patterns are rejected.
+func TestZoneFilterErrors(t *testing.T) {
+	if _, err := newZoneFilter("(", ""); err == nil {
+		t.Error("expected error with invalid include pattern")
+	}
+
+	if _, err := newZoneFilter("", "("); err == nil {
+		t.Error("expected error with invalid exclude pattern")
+	}
+}
+
+// TestFetchZoneMetadata checks that the values of the requested zone metadata
+// labels are taken from the zone details.
+func TestFetchZoneMetadata(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zones/zone-a" {
+			http.Error(w, `{"success": false, "errors": [{"code": 1001, "message": "Invalid zone identifier"}]}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"success": true, "result": {"id": "zone-a", "name": "example.org", "account": {"id": "account-a", "name": "Team A"}, "plan": {"name": "Enterprise Website"}}}`)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfapi, err := cloudflare.NewWithAPIToken(goodToken, cloudflare.HTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cfapi.BaseURL = ts.URL
+
+	metadata, err := fetchZoneMetadata(cfapi, []string{"zone-a"}, []string{"zone_account", "zone_plan"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]map[string]string{"zone-a": {"zone_account": "Team A", "zone_plan": "Enterprise Website"}}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %v, got %v", expected, metadata)
+	}
+
+	if _, err := fetchZoneMetadata(cfapi, []string{"zone-b"}, []string{"zone_account"}); err == nil {
+		t.Error("expected error when called with an unknown zone")
+	}
+}